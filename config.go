@@ -12,6 +12,12 @@ type config struct {
 	StorageType    string `envconfig:"STORAGE_ADAPTER" default:"valkey"`
 	NumLines       int    `envconfig:"NUMBER_OF_LINES" default:"1000"`
 	AggregatorType string `envconfig:"AGGREGATOR_TYPE" default:"valkey"`
+	RouterConfig   string `envconfig:"ROUTER_CONFIG_FILE" default:""`
+	AuthConfig     string `envconfig:"WEBLOG_AUTH_CONFIG_FILE" default:""`
+	LimitsConfig   string `envconfig:"WEBLOG_LIMITS_FILE" default:""`
+	// ShutdownTimeoutSeconds bounds the drain on SIGTERM, within the termination grace period of
+	// the pod
+	ShutdownTimeoutSeconds int `envconfig:"SHUTDOWN_TIMEOUT_SEC" default:"25"`
 }
 
 func parseConfig(appName string) (*config, error) {
@@ -1,5 +1,7 @@
 package log
 
+import "fmt"
+
 // Aggregator is an interface for pluggable components that collect log messages delivered via
 // some transport mechanism
 type Aggregator interface {
@@ -15,3 +17,37 @@ type Aggregator interface {
 	// errored.
 	Stopped() <-chan error
 }
+
+// ConnectionState is the state of the connection of an aggregator to the service it consumes
+// messages from
+type ConnectionState int32
+
+const (
+	// ConnectionStateConnecting means the aggregator is establishing its first connection or
+	// reconnecting
+	ConnectionStateConnecting ConnectionState = iota
+	// ConnectionStateConnected means the last request to the service succeeded
+	ConnectionStateConnected
+	// ConnectionStateDisconnected means the last request to the service failed and the aggregator
+	// is waiting to reconnect
+	ConnectionStateDisconnected
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case ConnectionStateConnecting:
+		return "connecting"
+	case ConnectionStateConnected:
+		return "connected"
+	case ConnectionStateDisconnected:
+		return "disconnected"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int32(s))
+}
+
+// Connector is implemented by aggregators that consume messages from a remote service rather than
+// receiving them on their own listeners
+type Connector interface {
+	// ConnectionState returns the current state of the connection to the service
+	ConnectionState() ConnectionState
+}
@@ -12,5 +12,17 @@ func NewAggregator(aggregatorType string, storageAdapter storage.Adapter) (Aggre
 	if aggregatorType == "valkey" {
 		return newValkeyAggregator(storageAdapter), nil
 	}
+	if aggregatorType == "syslog" {
+		return newSyslogAggregator(storageAdapter), nil
+	}
+	if aggregatorType == "forward" {
+		return newForwardAggregator(storageAdapter), nil
+	}
+	if aggregatorType == "http" {
+		return newHTTPAggregator(storageAdapter), nil
+	}
+	if aggregatorType == "otlp" {
+		return newOTLPAggregator(storageAdapter), nil
+	}
 	return nil, fmt.Errorf("unrecognized aggregator type: '%s'", aggregatorType)
 }
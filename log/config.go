@@ -11,16 +11,65 @@ const (
 )
 
 type config struct {
-	ValkeyURL          string `envconfig:"DRYCC_VALKEY_URL" default:"redis://127.0.0.1:6379"`
-	ValkeyStream       string `envconfig:"DRYCC_VALKEY_STREAM" default:"logs"`
-	ValkeyStreamGroup  string `envconfig:"DRYCC_VALKEY_STREAM_GROUP" default:"logger"`
-	StopTimeoutSeconds int    `envconfig:"AGGREGATOR_STOP_TIMEOUT_SEC" default:"1"`
+	ValkeyURL                    string `envconfig:"DRYCC_VALKEY_URL" default:"redis://127.0.0.1:6379"`
+	ValkeyStream                 string `envconfig:"DRYCC_VALKEY_STREAM" default:"logs"`
+	ValkeyStreamGroup            string `envconfig:"DRYCC_VALKEY_STREAM_GROUP" default:"logger"`
+	ValkeyClaimMinIdleSeconds    int    `envconfig:"AGGREGATOR_VALKEY_CLAIM_MIN_IDLE_SEC" default:"60"`
+	ValkeyClaimIntervalSeconds   int    `envconfig:"AGGREGATOR_VALKEY_CLAIM_INTERVAL_SEC" default:"60"`
+	ValkeyConsumerMaxIdleSeconds int    `envconfig:"AGGREGATOR_VALKEY_CONSUMER_MAX_IDLE_SEC" default:"3600"`
+	ValkeyDeadLetterStream       string `envconfig:"AGGREGATOR_VALKEY_DEAD_LETTER_STREAM" default:"logs-dead-letter"`
+	ValkeyDeadLetterMaxLen       int64  `envconfig:"AGGREGATOR_VALKEY_DEAD_LETTER_MAX_LEN" default:"10000"`
+	ValkeyBackoffBaseMillis      int    `envconfig:"AGGREGATOR_VALKEY_BACKOFF_BASE_MS" default:"100"`
+	ValkeyBackoffMaxSeconds      int    `envconfig:"AGGREGATOR_VALKEY_BACKOFF_MAX_SEC" default:"30"`
+	ValkeyMaxFailures            int    `envconfig:"AGGREGATOR_VALKEY_MAX_FAILURES" default:"10"`
+	StopTimeoutSeconds           int    `envconfig:"AGGREGATOR_STOP_TIMEOUT_SEC" default:"1"`
+	SyncTimeoutSeconds           int    `envconfig:"AGGREGATOR_SYNC_TIMEOUT_SEC" default:"120"`
+	SyslogUDPAddr                string `envconfig:"AGGREGATOR_SYSLOG_UDP_ADDR" default:"0.0.0.0:1514"`
+	SyslogTCPAddr                string `envconfig:"AGGREGATOR_SYSLOG_TCP_ADDR" default:"0.0.0.0:1514"`
+	TCPIdleTimeoutSeconds        int    `envconfig:"AGGREGATOR_TCP_IDLE_TIMEOUT_SEC" default:"300"`
+	ForwardAddr                  string `envconfig:"AGGREGATOR_FORWARD_ADDR" default:"0.0.0.0:24224"`
+	ForwardSharedKey             string `envconfig:"AGGREGATOR_FORWARD_SHARED_KEY" default:""`
+	ForwardHostname              string `envconfig:"AGGREGATOR_FORWARD_HOSTNAME" default:""`
+	ForwardMaxChunkBytes         int64  `envconfig:"AGGREGATOR_FORWARD_MAX_CHUNK_BYTES" default:"10485760"`
+	HTTPAddr                     string `envconfig:"AGGREGATOR_HTTP_ADDR" default:"0.0.0.0:8089"`
+	HTTPMaxBodyBytes             int64  `envconfig:"AGGREGATOR_HTTP_MAX_BODY_BYTES" default:"10485760"`
+	OTLPAddr                     string `envconfig:"AGGREGATOR_OTLP_ADDR" default:"0.0.0.0:4318"`
 }
 
 func (c config) stopTimeoutDuration() time.Duration {
 	return time.Duration(c.StopTimeoutSeconds) * time.Second
 }
 
+func (c config) tcpIdleTimeoutDuration() time.Duration {
+	return time.Duration(c.TCPIdleTimeoutSeconds) * time.Second
+}
+
+func (c config) claimMinIdleDuration() time.Duration {
+	return time.Duration(c.ValkeyClaimMinIdleSeconds) * time.Second
+}
+
+func (c config) claimIntervalDuration() time.Duration {
+	return time.Duration(c.ValkeyClaimIntervalSeconds) * time.Second
+}
+
+func (c config) backoffBaseDuration() time.Duration {
+	return time.Duration(c.ValkeyBackoffBaseMillis) * time.Millisecond
+}
+
+func (c config) backoffMaxDuration() time.Duration {
+	return time.Duration(c.ValkeyBackoffMaxSeconds) * time.Second
+}
+
+// syncTimeoutDuration bounds storing the messages of a batch before it is acknowledged, such as a
+// batch of stream entries or a forward chunk. It should outlast the retries of a storage pipeline.
+func (c config) syncTimeoutDuration() time.Duration {
+	return time.Duration(c.SyncTimeoutSeconds) * time.Second
+}
+
+func (c config) consumerMaxIdleDuration() time.Duration {
+	return time.Duration(c.ValkeyConsumerMaxIdleSeconds) * time.Second
+}
+
 func parseConfig(appName string) (*config, error) {
 	ret := new(config)
 	if err := envconfig.Process(appName, ret); err != nil {
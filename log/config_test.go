@@ -16,6 +16,13 @@ func TestStopTimeoutDuration(t *testing.T) {
 	assert.Equal(t, c.stopTimeoutDuration(), time.Duration(c.StopTimeoutSeconds)*time.Second)
 }
 
+func TestSyncTimeoutDuration(t *testing.T) {
+	c := config{
+		SyncTimeoutSeconds: 120,
+	}
+	assert.Equal(t, 2*time.Minute, c.syncTimeoutDuration())
+}
+
 func TestParseConfig(t *testing.T) {
 	original, err := parseConfig("foo")
 	assert.NoError(t, err)
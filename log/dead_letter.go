@@ -0,0 +1,200 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/valkey-io/valkey-go"
+	"github.com/valkey-io/valkey-go/valkeycompat"
+)
+
+// fields of the dead-letter stream entries, data and retries are also set on replayed entries
+const (
+	deadLetterDataField    = "data"
+	deadLetterReasonField  = "error"
+	deadLetterIDField      = "id"
+	deadLetterRetriesField = "retries"
+	deadLetterReplayBatch  = 100
+)
+
+var (
+	errMissingData       = errors.New("stream entry has no data field")
+	errAggregatorStopped = errors.New("aggregator stopped")
+)
+
+// DeadLetter is a stream entry an aggregator failed to handle.
+type DeadLetter struct {
+	// ID is the ID of the entry in the dead-letter stream
+	ID string `json:"id"`
+	// OriginalID is the ID the entry had in the stream it was read from
+	OriginalID string `json:"original_id"`
+	// Reason is the error returned when handling the entry
+	Reason string `json:"reason"`
+	// Retries is the number of times the entry was replayed and failed again
+	Retries int    `json:"retries"`
+	Data    string `json:"data"`
+}
+
+// DeadLetterQueue is implemented by aggregators that keep the messages they failed to handle.
+type DeadLetterQueue interface {
+	// DeadLetters returns at most count dead letters, starting at the given ID ("-" for the oldest).
+	DeadLetters(ctx context.Context, start string, count int64) ([]DeadLetter, error)
+	// Replay puts the dead letters with the given IDs, or every dead letter when no ID is given,
+	// back into the stream they were read from and returns the number of replayed entries.
+	Replay(ctx context.Context, ids ...string) (int, error)
+}
+
+func deadLetterFromXMessage(message valkeycompat.XMessage) DeadLetter {
+	data, _ := message.Values[deadLetterDataField].(string)
+	originalID, _ := message.Values[deadLetterIDField].(string)
+	reason, _ := message.Values[deadLetterReasonField].(string)
+	return DeadLetter{
+		ID:         message.ID,
+		OriginalID: originalID,
+		Reason:     reason,
+		Retries:    deadLetterRetries(message.Values),
+		Data:       data,
+	}
+}
+
+// deadLetterRetries returns the number of times a stream entry was replayed from the dead-letter
+// stream, entries written by fluent-bit have none.
+func deadLetterRetries(values map[string]interface{}) int {
+	retries, _ := values[deadLetterRetriesField].(string)
+	n, _ := strconv.Atoi(retries)
+	return n
+}
+
+// deadLetter writes an entry that failed to be handled to the dead-letter stream. Entries replayed
+// from the dead-letter stream carry their retry count, which is kept across failures.
+func (a *valkeyAggregator) deadLetter(ctx context.Context, valkeyCmdable valkeycompat.Cmdable, message valkeycompat.XMessage, reason error) error {
+	data, _ := message.Values[deadLetterDataField].(string)
+	return valkeyCmdable.XAdd(ctx, valkeycompat.XAddArgs{
+		Stream: a.cfg.ValkeyDeadLetterStream,
+		MaxLen: a.cfg.ValkeyDeadLetterMaxLen,
+		Approx: true,
+		ID:     "*",
+		Values: map[string]interface{}{
+			deadLetterDataField:    data,
+			deadLetterReasonField:  reason.Error(),
+			deadLetterIDField:      message.ID,
+			deadLetterRetriesField: strconv.Itoa(deadLetterRetries(message.Values)),
+		},
+	}).Err()
+}
+
+// DeadLetters is the DeadLetterQueue interface implementation
+func (a *valkeyAggregator) DeadLetters(ctx context.Context, start string, count int64) ([]DeadLetter, error) {
+	if a.cfg.ValkeyDeadLetterStream == "" {
+		return nil, ErrDeadLetterDisabled
+	}
+	valkeyCmdable, err := a.admin()
+	if err != nil {
+		return nil, err
+	}
+	messages, err := valkeyCmdable.XRangeN(ctx, a.cfg.ValkeyDeadLetterStream, start, "+", count).Result()
+	if err != nil {
+		return nil, err
+	}
+	deadLetters := make([]DeadLetter, 0, len(messages))
+	for _, message := range messages {
+		deadLetters = append(deadLetters, deadLetterFromXMessage(message))
+	}
+	return deadLetters, nil
+}
+
+// Replay is the DeadLetterQueue interface implementation
+func (a *valkeyAggregator) Replay(ctx context.Context, ids ...string) (int, error) {
+	if a.cfg.ValkeyDeadLetterStream == "" {
+		return 0, ErrDeadLetterDisabled
+	}
+	valkeyCmdable, err := a.admin()
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	if len(ids) == 0 {
+		// only the entries dead-lettered before the request are replayed, as replayed entries that
+		// fail again are dead-lettered anew while this runs
+		last, err := valkeyCmdable.XRevRangeN(ctx, a.cfg.ValkeyDeadLetterStream, "+", "-", 1).Result()
+		if err != nil || len(last) == 0 {
+			return 0, err
+		}
+		// replayed entries are deleted, so the oldest remaining entries are read until none is left
+		for {
+			messages, err := valkeyCmdable.XRangeN(ctx, a.cfg.ValkeyDeadLetterStream, "-", last[0].ID, deadLetterReplayBatch).Result()
+			if err != nil || len(messages) == 0 {
+				return replayed, err
+			}
+			for _, message := range messages {
+				if err := a.replay(ctx, valkeyCmdable, message); err != nil {
+					return replayed, err
+				}
+				replayed++
+			}
+		}
+	}
+	for _, id := range ids {
+		messages, err := valkeyCmdable.XRangeN(ctx, a.cfg.ValkeyDeadLetterStream, id, id, 1).Result()
+		if err != nil {
+			return replayed, err
+		}
+		if len(messages) == 0 {
+			return replayed, fmt.Errorf("%w: %s", ErrDeadLetterNotFound, id)
+		}
+		if err := a.replay(ctx, valkeyCmdable, messages[0]); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+// admin returns the client serving the dead-letter requests, connecting it on the first one. It is
+// closed once the aggregator is stopped.
+func (a *valkeyAggregator) admin() (valkeycompat.Cmdable, error) {
+	a.adminMu.Lock()
+	defer a.adminMu.Unlock()
+	if a.ctx.Err() != nil {
+		return nil, errAggregatorStopped
+	}
+	if a.adminClient == nil {
+		valkeyClient, err := valkey.NewClient(a.clientOption)
+		if err != nil {
+			return nil, err
+		}
+		a.adminClient = valkeyClient
+	}
+	return valkeycompat.NewAdapter(a.adminClient), nil
+}
+
+// closeAdminClient closes the client serving the dead-letter requests, if connected
+func (a *valkeyAggregator) closeAdminClient() {
+	a.adminMu.Lock()
+	defer a.adminMu.Unlock()
+	if a.adminClient != nil {
+		a.adminClient.Close()
+		a.adminClient = nil
+	}
+}
+
+// replay adds a dead letter back to the stream with an incremented retry count and deletes it from
+// the dead-letter stream.
+func (a *valkeyAggregator) replay(ctx context.Context, valkeyCmdable valkeycompat.Cmdable, message valkeycompat.XMessage) error {
+	deadLetter := deadLetterFromXMessage(message)
+	err := valkeyCmdable.XAdd(ctx, valkeycompat.XAddArgs{
+		Stream: a.cfg.ValkeyStream,
+		ID:     "*",
+		Values: map[string]interface{}{
+			deadLetterDataField:    deadLetter.Data,
+			deadLetterRetriesField: strconv.Itoa(deadLetter.Retries + 1),
+		},
+	}).Err()
+	if err != nil {
+		return err
+	}
+	return valkeyCmdable.XDel(ctx, a.cfg.ValkeyDeadLetterStream, message.ID).Err()
+}
@@ -1,10 +1,19 @@
 package log
 
 import (
+	"errors"
 	"fmt"
 	"time"
 )
 
+var (
+	// ErrDeadLetterDisabled is the error returned by a DeadLetterQueue when no dead-letter stream is
+	// configured
+	ErrDeadLetterDisabled = errors.New("dead-letter stream is not configured")
+	// ErrDeadLetterNotFound is the error returned when replaying a dead letter that does not exist
+	ErrDeadLetterNotFound = errors.New("dead-letter entry not found")
+)
+
 // ErrStopTimedOut is the error returned if a (Aggregator).Stop call times out before the stop is
 // complete
 type ErrStopTimedOut struct {
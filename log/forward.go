@@ -0,0 +1,412 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+const (
+	// forwardEventTimeExt is the msgpack extension type used by the Forward protocol for timestamps
+	// with nanosecond precision.
+	forwardEventTimeExt = 0
+	// forwardHandshakeMaxBytes bounds the PING message of the handshake, which is received before
+	// the client is authenticated.
+	forwardHandshakeMaxBytes = 64 * 1024
+	// forwardMaxDepth bounds the nesting of the maps and arrays of a record.
+	forwardMaxDepth = 32
+)
+
+var (
+	errForwardMalformed = errors.New("malformed forward message")
+	errForwardTooLarge  = errors.New("forward message too large")
+)
+
+// forwardEntry is a single [time, record] pair of a Forward protocol message.
+type forwardEntry struct {
+	time   time.Time
+	record map[string]interface{}
+}
+
+// forwardOption holds the options a client may send along with a Forward protocol message.
+type forwardOption struct {
+	chunk      string
+	compressed string
+}
+
+// forwardDecoder is a msgpack decoder counting down the bytes the message being decoded may still
+// take. The lengths a message declares are checked against the bytes left before anything is
+// allocated for them, so that a client cannot make the aggregator allocate for more elements than
+// its messages may take bytes.
+type forwardDecoder struct {
+	*msgpack.Decoder
+	limited  *forwardLimitReader
+	buffered *bufio.Reader
+	maxBytes int64
+}
+
+// forwardLimitReader reads up to remaining bytes from r, and fails with errForwardTooLarge once
+// they are read unless r is at its end.
+type forwardLimitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (f *forwardLimitReader) Read(p []byte) (int, error) {
+	if f.remaining <= 0 {
+		var probe [1]byte
+		if n, err := f.r.Read(probe[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		return 0, errForwardTooLarge
+	}
+	if int64(len(p)) > f.remaining {
+		p = p[:f.remaining]
+	}
+	n, err := f.r.Read(p)
+	f.remaining -= int64(n)
+	return n, err
+}
+
+// newForwardDecoder returns a decoder of messages of up to maxBytes bytes, which decodes binary
+// strings as strings, as older clients send record values as bin.
+func newForwardDecoder(r io.Reader, maxBytes int64) *forwardDecoder {
+	limited := &forwardLimitReader{r: r, remaining: maxBytes}
+	buffered := bufio.NewReader(limited)
+	dec := msgpack.NewDecoder(buffered)
+	dec.UseLooseInterfaceDecoding(true)
+	return &forwardDecoder{Decoder: dec, limited: limited, buffered: buffered, maxBytes: maxBytes}
+}
+
+// limit lets the next message take up to n bytes, besides those read ahead already
+func (d *forwardDecoder) limit(n int64) {
+	d.limited.remaining = n
+}
+
+// checkLen fails when n elements of at least size bytes each take more than the bytes left
+func (d *forwardDecoder) checkLen(n int, size int64) error {
+	if int64(n) > (d.limited.remaining+int64(d.buffered.Buffered()))/size {
+		return errForwardTooLarge
+	}
+	return nil
+}
+
+// decodeBytes decodes a string or binary string into bytes
+func (d *forwardDecoder) decodeBytes() ([]byte, error) {
+	n, err := d.DecodeBytesLen()
+	if err != nil || n <= 0 {
+		return nil, err
+	}
+	if err := d.checkLen(n, 1); err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	return b, d.ReadFull(b)
+}
+
+// decodeValue decodes a value of a record, up to depth maps and arrays deep
+func (d *forwardDecoder) decodeValue(depth int) (interface{}, error) {
+	c, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+	isMap := msgpcode.IsFixedMap(c) || c == msgpcode.Map16 || c == msgpcode.Map32
+	isArray := msgpcode.IsFixedArray(c) || c == msgpcode.Array16 || c == msgpcode.Array32
+	if !isMap && !isArray {
+		return d.DecodeInterface()
+	}
+	if depth <= 0 {
+		return nil, errForwardMalformed
+	}
+	if isArray {
+		n, err := d.DecodeArrayLen()
+		if err != nil {
+			return nil, err
+		}
+		if err := d.checkLen(n, 1); err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, 0, max(n, 0))
+		for i := 0; i < n; i++ {
+			v, err := d.decodeValue(depth - 1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	n, err := d.DecodeMapLen()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkLen(n, 2); err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{}, max(n, 0))
+	for i := 0; i < n; i++ {
+		k, err := d.DecodeString()
+		if err != nil {
+			return nil, err
+		}
+		if values[k], err = d.decodeValue(depth - 1); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// decodeForwardMessage decodes a single Forward protocol message in any of the Message, Forward,
+// PackedForward or CompressedPackedForward modes, of up to the maximum size of the decoder.
+func decodeForwardMessage(dec *forwardDecoder) (string, []forwardEntry, forwardOption, error) {
+	var option forwardOption
+	dec.limit(dec.maxBytes)
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return "", nil, option, err
+	}
+	if n < 2 || n > 4 {
+		return "", nil, option, errForwardMalformed
+	}
+	tag, err := dec.DecodeString()
+	if err != nil {
+		return "", nil, option, err
+	}
+	c, err := dec.PeekCode()
+	if err != nil {
+		return "", nil, option, err
+	}
+
+	var entries []forwardEntry
+	var packed []byte
+	consumed := 2
+	switch {
+	case msgpcode.IsFixedArray(c) || c == msgpcode.Array16 || c == msgpcode.Array32:
+		// Forward mode: [tag, [[time, record], ...], option]
+		count, err := dec.DecodeArrayLen()
+		if err != nil {
+			return "", nil, option, err
+		}
+		if err := dec.checkLen(count, 1); err != nil {
+			return "", nil, option, err
+		}
+		for i := 0; i < count; i++ {
+			entry, err := decodeForwardEntry(dec)
+			if err != nil {
+				return "", nil, option, err
+			}
+			entries = append(entries, entry)
+		}
+	case msgpcode.IsString(c) || msgpcode.IsBin(c):
+		// PackedForward mode: [tag, <msgpack stream of [time, record]>, option]
+		if packed, err = dec.decodeBytes(); err != nil {
+			return "", nil, option, err
+		}
+	default:
+		// Message mode: [tag, time, record, option]
+		if n < 3 {
+			return "", nil, option, errForwardMalformed
+		}
+		t, err := decodeForwardTime(dec)
+		if err != nil {
+			return "", nil, option, err
+		}
+		record, err := decodeForwardRecord(dec)
+		if err != nil {
+			return "", nil, option, err
+		}
+		entries = append(entries, forwardEntry{time: t, record: record})
+		consumed = 3
+	}
+
+	if n > consumed {
+		if option, err = decodeForwardOption(dec); err != nil {
+			return "", nil, option, err
+		}
+	}
+	if packed != nil {
+		if entries, err = decodePackedForwardEntries(packed, option.compressed, dec.maxBytes); err != nil {
+			return "", nil, option, err
+		}
+	}
+	return tag, entries, option, nil
+}
+
+// decodePackedForwardEntries decodes the entries of a PackedForward message, of up to maxBytes
+// bytes once decompressed.
+func decodePackedForwardEntries(packed []byte, compressed string, maxBytes int64) ([]forwardEntry, error) {
+	var r io.Reader = bytes.NewReader(packed)
+	switch compressed {
+	case "":
+	case "gzip":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	default:
+		return nil, fmt.Errorf("unsupported forward compression: '%s'", compressed)
+	}
+	dec := newForwardDecoder(r, maxBytes)
+	var entries []forwardEntry
+	for {
+		entry, err := decodeForwardEntry(dec)
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func decodeForwardEntry(dec *forwardDecoder) (forwardEntry, error) {
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return forwardEntry{}, err
+	}
+	if n != 2 {
+		return forwardEntry{}, errForwardMalformed
+	}
+	t, err := decodeForwardTime(dec)
+	if err != nil {
+		return forwardEntry{}, err
+	}
+	record, err := decodeForwardRecord(dec)
+	if err != nil {
+		return forwardEntry{}, err
+	}
+	return forwardEntry{time: t, record: record}, nil
+}
+
+// decodeForwardTime decodes either an EventTime extension or an integer (or float) number of
+// seconds since the epoch.
+func decodeForwardTime(dec *forwardDecoder) (time.Time, error) {
+	c, err := dec.PeekCode()
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch {
+	case msgpcode.IsExt(c):
+		extID, extLen, err := dec.DecodeExtHeader()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if extID != forwardEventTimeExt || extLen != 8 {
+			return time.Time{}, fmt.Errorf("unexpected forward time extension %d of length %d", extID, extLen)
+		}
+		buf := make([]byte, 8)
+		if err := dec.ReadFull(buf); err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(int64(binary.BigEndian.Uint32(buf[:4])), int64(binary.BigEndian.Uint32(buf[4:]))), nil
+	case c == msgpcode.Float || c == msgpcode.Double:
+		f, err := dec.DecodeFloat64()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, int64(f*float64(time.Second))), nil
+	default:
+		sec, err := dec.DecodeInt64()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(sec, 0), nil
+	}
+}
+
+func decodeForwardRecord(dec *forwardDecoder) (map[string]interface{}, error) {
+	v, err := dec.decodeValue(forwardMaxDepth)
+	if err != nil {
+		return nil, err
+	}
+	record, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errForwardMalformed
+	}
+	return record, nil
+}
+
+func decodeForwardOption(dec *forwardDecoder) (forwardOption, error) {
+	var option forwardOption
+	c, err := dec.PeekCode()
+	if err != nil {
+		return option, err
+	}
+	if c == msgpcode.Nil {
+		return option, dec.Skip()
+	}
+	values, err := decodeForwardRecord(dec)
+	if err != nil {
+		return option, err
+	}
+	option.chunk, _ = values["chunk"].(string)
+	option.compressed, _ = values["compressed"].(string)
+	if option.compressed == "text" {
+		option.compressed = ""
+	}
+	return option, nil
+}
+
+// messageFromForwardRecord maps a record, as produced by fluent-bit's kubernetes filter, onto a
+// Message.
+func messageFromForwardRecord(t time.Time, record map[string]interface{}) *Message {
+	message := &Message{Time: t}
+	message.Log = forwardString(record["log"])
+	if message.Log == "" {
+		message.Log = forwardString(record["message"])
+	}
+	message.Stream = forwardString(record["stream"])
+	message.Level = forwardString(record["level"])
+	if k8s, ok := record["kubernetes"].(map[string]interface{}); ok {
+		message.Kubernetes = Kubernetes{
+			Namespace:     forwardString(k8s["namespace_name"]),
+			PodID:         forwardString(k8s["pod_id"]),
+			PodName:       forwardString(k8s["pod_name"]),
+			ContainerName: forwardString(k8s["container_name"]),
+			Host:          forwardString(k8s["host"]),
+		}
+		if labels, ok := k8s["labels"].(map[string]interface{}); ok {
+			message.Kubernetes.Labels = make(map[string]string, len(labels))
+			for k, v := range labels {
+				message.Kubernetes.Labels[k] = forwardString(v)
+			}
+		}
+	}
+	return message
+}
+
+func forwardString(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	default:
+		return fmt.Sprint(s)
+	}
+}
+
+// forwardDigest computes the shared key digest exchanged during the Forward protocol handshake.
+func forwardDigest(salt, hostname string, nonce []byte, sharedKey string) string {
+	h := sha512.New()
+	h.Write([]byte(salt))
+	h.Write([]byte(hostname))
+	h.Write(nonce)
+	h.Write([]byte(sharedKey))
+	return hex.EncodeToString(h.Sum(nil))
+}
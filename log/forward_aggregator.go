@@ -0,0 +1,179 @@
+package log
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	l "log"
+	"net"
+	"os"
+
+	"github.com/drycc/logger/storage"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type forwardAggregator struct {
+	*listenerAggregator
+	handle func(*Message)
+	// sync waits for the messages handled to be stored, before their chunk is acknowledged
+	sync     func(context.Context) error
+	listener net.Listener
+}
+
+func newForwardAggregator(storageAdapter storage.Adapter) Aggregator {
+	return &forwardAggregator{
+		listenerAggregator: newListenerAggregator(nil),
+		handle: func(message *Message) {
+			if err := observeHandle("forward", handleMessage(message, storageAdapter)); err != nil {
+				l.Printf("handle message error: %v, %v", err, message)
+			}
+		},
+		sync: func(ctx context.Context) error {
+			return storage.Sync(ctx, storageAdapter)
+		},
+	}
+}
+
+// Listen starts the aggregator, accepting Fluent Forward protocol connections on the configured
+// TCP address. Invocations of this function are not concurrency safe and multiple serialized
+// invocations have no effect.
+func (a *forwardAggregator) Listen() error {
+	if a.listening {
+		return nil
+	}
+	cfg, err := a.config()
+	if err != nil {
+		return err
+	}
+	if a.listener, err = net.Listen("tcp", cfg.ForwardAddr); err != nil {
+		return err
+	}
+	a.wg.Add(1)
+	go a.serve()
+	a.start(a.listener)
+	return nil
+}
+
+func (a *forwardAggregator) serve() {
+	defer a.wg.Done()
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			a.fail(err)
+			return
+		}
+		a.wg.Add(1)
+		go a.serveConn(conn)
+	}
+}
+
+func (a *forwardAggregator) serveConn(conn net.Conn) {
+	defer a.wg.Done()
+	defer conn.Close()
+	stop := context.AfterFunc(a.ctx, func() { conn.Close() })
+	defer stop()
+	dec := newForwardDecoder(conn, a.cfg.ForwardMaxChunkBytes)
+	enc := msgpack.NewEncoder(conn)
+	if a.cfg.ForwardSharedKey != "" {
+		if err := a.renewReadDeadline(conn); err != nil {
+			l.Printf("forward connection error from %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		if err := a.handshake(dec, enc); err != nil {
+			l.Printf("forward handshake with %s failed: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+	for {
+		if err := a.renewReadDeadline(conn); err != nil {
+			l.Printf("forward connection error from %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		_, entries, option, err := decodeForwardMessage(dec)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				l.Printf("closing idle forward connection from %s", conn.RemoteAddr())
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				l.Printf("forward connection error from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		for _, entry := range entries {
+			a.handle(messageFromForwardRecord(entry.time, entry.record))
+		}
+		if option.chunk != "" {
+			// the chunk is acknowledged once its messages are stored, else the connection is closed
+			// and the client sends it again
+			if err := a.syncChunk(); err != nil {
+				l.Printf("storage sync error, not acknowledging chunk %s from %s: %v", option.chunk, conn.RemoteAddr(), err)
+				return
+			}
+			if err := enc.Encode(map[string]string{"ack": option.chunk}); err != nil {
+				l.Printf("forward ack to %s failed: %v", conn.RemoteAddr(), err)
+				return
+			}
+		}
+	}
+}
+
+// syncChunk waits for the messages of a chunk to be stored, or kept on disk to be stored, up to the
+// sync timeout
+func (a *forwardAggregator) syncChunk() error {
+	if a.sync == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(a.ctx, a.cfg.syncTimeoutDuration())
+	defer cancel()
+	return a.sync(ctx)
+}
+
+// handshake authenticates a client using the shared key: the server sends HELO with a nonce, the
+// client answers PING with a digest of the shared key and the server confirms with PONG.
+func (a *forwardAggregator) handshake(dec *forwardDecoder, enc *msgpack.Encoder) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	helo := []interface{}{"HELO", map[string]interface{}{"nonce": nonce, "auth": "", "keepalive": true}}
+	if err := enc.Encode(helo); err != nil {
+		return err
+	}
+	dec.limit(forwardHandshakeMaxBytes)
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return err
+	}
+	if n < 4 {
+		return errForwardMalformed
+	}
+	ping := make([]string, 4)
+	for i := range ping {
+		if ping[i], err = dec.DecodeString(); err != nil {
+			return err
+		}
+	}
+	// skip the username and password digest, user authentication is not supported
+	for i := 4; i < n; i++ {
+		if err := dec.Skip(); err != nil {
+			return err
+		}
+	}
+	if ping[0] != "PING" {
+		return fmt.Errorf("expected PING, got %s", ping[0])
+	}
+	clientHostname, salt, digest := ping[1], ping[2], ping[3]
+	hostname := a.cfg.ForwardHostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	// compared in constant time, so that the time taken does not leak how much of the digest matched
+	expected := forwardDigest(salt, clientHostname, nonce, a.cfg.ForwardSharedKey)
+	if subtle.ConstantTimeCompare([]byte(digest), []byte(expected)) != 1 {
+		enc.Encode([]interface{}{"PONG", false, "shared_key mismatch", hostname, ""})
+		return fmt.Errorf("shared key mismatch for client %s", clientHostname)
+	}
+	return enc.Encode([]interface{}{"PONG", true, "", hostname, forwardDigest(salt, hostname, nonce, a.cfg.ForwardSharedKey)})
+}
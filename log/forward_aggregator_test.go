@@ -0,0 +1,162 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func newTestForwardAggregator(t *testing.T, sharedKey string) (*forwardAggregator, chan *Message) {
+	return newTestSyncedForwardAggregator(t, sharedKey, nil)
+}
+
+// newTestSyncedForwardAggregator returns a forward aggregator listening, whose sync waits for the
+// messages handled to be stored
+func newTestSyncedForwardAggregator(t *testing.T, sharedKey string, sync func(context.Context) error) (*forwardAggregator, chan *Message) {
+	msg := make(chan *Message, 10)
+	aggregator := &forwardAggregator{
+		listenerAggregator: newListenerAggregator(&config{
+			ForwardAddr:           "127.0.0.1:0",
+			ForwardSharedKey:      sharedKey,
+			ForwardHostname:       "logger",
+			ForwardMaxChunkBytes:  forwardMaxBytes,
+			TCPIdleTimeoutSeconds: 1,
+			StopTimeoutSeconds:    1,
+			SyncTimeoutSeconds:    1,
+		}),
+		handle: func(message *Message) {
+			msg <- message
+		},
+		sync: sync,
+	}
+	assert.NoError(t, aggregator.Listen())
+	return aggregator, msg
+}
+
+func TestForwardAggregator(t *testing.T) {
+	aggregator, msg := newTestForwardAggregator(t, "")
+	stoppedCh := aggregator.Stopped()
+
+	conn, err := net.Dial("tcp", aggregator.listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	enc := msgpack.NewEncoder(conn)
+	dec := msgpack.NewDecoder(conn)
+	assert.NoError(t, enc.Encode([]interface{}{"kube.foo", packForwardEntries(t, 2), map[string]interface{}{"chunk": "abc"}}))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case message := <-msg:
+			assert.Equal(t, "test message\n", message.Log)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for forward messages")
+		}
+	}
+	ack, err := dec.DecodeMap()
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", ack["ack"])
+
+	assert.NoError(t, aggregator.Stop())
+	assert.NoError(t, <-stoppedCh, "aggregator stopped with error")
+}
+
+func forwardPing(t *testing.T, aggregator *forwardAggregator, sharedKey string) []interface{} {
+	conn, err := net.Dial("tcp", aggregator.listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	enc := msgpack.NewEncoder(conn)
+	dec := newForwardDecoder(conn, forwardMaxBytes)
+
+	v, err := dec.DecodeInterface()
+	assert.NoError(t, err)
+	helo := v.([]interface{})
+	assert.Equal(t, "HELO", helo[0])
+	nonce := []byte(helo[1].(map[string]interface{})["nonce"].(string))
+
+	digest := forwardDigest("salt", "client", nonce, sharedKey)
+	assert.NoError(t, enc.Encode([]interface{}{"PING", "client", "salt", digest, "", ""}))
+	v, err = dec.DecodeInterface()
+	assert.NoError(t, err)
+	pong := v.([]interface{})
+	assert.Equal(t, "PONG", pong[0])
+	if pong[1] == true {
+		assert.Equal(t, forwardDigest("salt", "logger", nonce, sharedKey), pong[4])
+		assert.NoError(t, enc.Encode([]interface{}{"kube.foo", forwardTime.Unix(), forwardRecord}))
+	}
+	return pong
+}
+
+func TestForwardAggregatorSyncBeforeAck(t *testing.T) {
+	synced := make(chan error, 1)
+	aggregator, msg := newTestSyncedForwardAggregator(t, "", func(ctx context.Context) error {
+		return <-synced
+	})
+	defer aggregator.Stop()
+	forward := func() (*msgpack.Decoder, net.Conn) {
+		conn, err := net.Dial("tcp", aggregator.listener.Addr().String())
+		assert.NoError(t, err)
+		assert.NoError(t, msgpack.NewEncoder(conn).Encode([]interface{}{"kube.foo", packForwardEntries(t, 1), map[string]interface{}{"chunk": "abc"}}))
+		<-msg
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		return msgpack.NewDecoder(conn), conn
+	}
+
+	// a chunk is acknowledged once its messages are stored
+	dec, conn := forward()
+	defer conn.Close()
+	synced <- nil
+	ack, err := dec.DecodeMap()
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", ack["ack"])
+
+	// and not when storing them failed, the connection is closed for the client to send it again
+	dec, conn = forward()
+	defer conn.Close()
+	synced <- errors.New("valkey down")
+	_, err = dec.DecodeMap()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestForwardAggregatorSharedKey(t *testing.T) {
+	aggregator, msg := newTestForwardAggregator(t, "secret")
+	defer aggregator.Stop()
+
+	pong := forwardPing(t, aggregator, "secret")
+	assert.Equal(t, true, pong[1])
+	select {
+	case message := <-msg:
+		assert.Equal(t, "test message\n", message.Log)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for forward messages")
+	}
+
+	pong = forwardPing(t, aggregator, "wrong")
+	assert.Equal(t, false, pong[1])
+	assert.Equal(t, "shared_key mismatch", pong[2])
+}
+
+func TestForwardAggregatorIdleTimeout(t *testing.T) {
+	aggregator, _ := newTestForwardAggregator(t, "")
+	defer aggregator.Stop()
+
+	// a connection sending no message within the idle timeout is closed
+	conn, err := net.Dial("tcp", aggregator.listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestForwardBasedAggregator(t *testing.T) {
+	a, err := NewAggregator("forward", &stubStorageAdapter{})
+	assert.NoError(t, err)
+	_, ok := a.(*forwardAggregator)
+	assert.True(t, ok, "expected a *log.forwardAggregator")
+}
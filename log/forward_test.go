@@ -0,0 +1,188 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// eventTime encodes a time as a Forward protocol EventTime extension
+type eventTime time.Time
+
+func newEventTime(t time.Time) *eventTime {
+	et := eventTime(t)
+	return &et
+}
+
+func (t *eventTime) MarshalMsgpack() ([]byte, error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b, uint32(time.Time(*t).Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(time.Time(*t).Nanosecond()))
+	return b, nil
+}
+
+func (t *eventTime) UnmarshalMsgpack([]byte) error {
+	return nil
+}
+
+func init() {
+	msgpack.RegisterExt(forwardEventTimeExt, (*eventTime)(nil))
+}
+
+const forwardMaxBytes = 1024 * 1024
+
+var (
+	forwardTime   = time.Date(2016, 10, 18, 20, 29, 38, 500, time.UTC)
+	forwardRecord = map[string]interface{}{
+		"log":    "test message\n",
+		"stream": "stderr",
+		"kubernetes": map[string]interface{}{
+			"namespace_name": "foo",
+			"pod_name":       "foo-web-845861952-nzf60",
+			"container_name": "foo-web",
+			"labels":         map[string]interface{}{"app": "foo", "type": "web", "version": "v2"},
+		},
+	}
+)
+
+func encodeForward(t *testing.T, v interface{}) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, msgpack.NewEncoder(buf).Encode(v))
+	return buf
+}
+
+func packForwardEntries(t *testing.T, count int) []byte {
+	buf := new(bytes.Buffer)
+	enc := msgpack.NewEncoder(buf)
+	for i := 0; i < count; i++ {
+		assert.NoError(t, enc.Encode([]interface{}{newEventTime(forwardTime), forwardRecord}))
+	}
+	return buf.Bytes()
+}
+
+func assertForwardEntries(t *testing.T, entries []forwardEntry, count int) {
+	assert.Len(t, entries, count)
+	for _, entry := range entries {
+		message := messageFromForwardRecord(entry.time, entry.record)
+		assert.True(t, forwardTime.Equal(message.Time))
+		assert.Equal(t, "test message\n", message.Log)
+		assert.Equal(t, "stderr", message.Stream)
+		assert.Equal(t, "foo", message.Kubernetes.Namespace)
+		assert.Equal(t, "foo-web", message.Kubernetes.ContainerName)
+		assert.Equal(t, map[string]string{"app": "foo", "type": "web", "version": "v2"}, message.Kubernetes.Labels)
+	}
+}
+
+func TestDecodeForwardMessageMode(t *testing.T) {
+	buf := encodeForward(t, []interface{}{"kube.foo", newEventTime(forwardTime), forwardRecord, map[string]interface{}{"chunk": "abc"}})
+	tag, entries, option, err := decodeForwardMessage(newForwardDecoder(buf, forwardMaxBytes))
+	assert.NoError(t, err)
+	assert.Equal(t, "kube.foo", tag)
+	assert.Equal(t, "abc", option.chunk)
+	assertForwardEntries(t, entries, 1)
+
+	// integer timestamps and no option
+	buf = encodeForward(t, []interface{}{"kube.foo", forwardTime.Unix(), forwardRecord})
+	_, entries, _, err = decodeForwardMessage(newForwardDecoder(buf, forwardMaxBytes))
+	assert.NoError(t, err)
+	assert.Equal(t, forwardTime.Unix(), entries[0].time.Unix())
+}
+
+func TestDecodeForwardForwardMode(t *testing.T) {
+	entry := []interface{}{newEventTime(forwardTime), forwardRecord}
+	buf := encodeForward(t, []interface{}{"kube.foo", []interface{}{entry, entry, entry}})
+	_, entries, _, err := decodeForwardMessage(newForwardDecoder(buf, forwardMaxBytes))
+	assert.NoError(t, err)
+	assertForwardEntries(t, entries, 3)
+}
+
+func TestDecodeForwardPackedForwardMode(t *testing.T) {
+	buf := encodeForward(t, []interface{}{"kube.foo", packForwardEntries(t, 2), map[string]interface{}{"size": 2}})
+	_, entries, _, err := decodeForwardMessage(newForwardDecoder(buf, forwardMaxBytes))
+	assert.NoError(t, err)
+	assertForwardEntries(t, entries, 2)
+}
+
+func TestDecodeForwardCompressedPackedForwardMode(t *testing.T) {
+	compressed := new(bytes.Buffer)
+	gz := gzip.NewWriter(compressed)
+	gz.Write(packForwardEntries(t, 4))
+	gz.Close()
+	buf := encodeForward(t, []interface{}{"kube.foo", compressed.Bytes(), map[string]interface{}{"compressed": "gzip", "chunk": "xyz"}})
+	_, entries, option, err := decodeForwardMessage(newForwardDecoder(buf, forwardMaxBytes))
+	assert.NoError(t, err)
+	assert.Equal(t, "xyz", option.chunk)
+	assertForwardEntries(t, entries, 4)
+
+	buf = encodeForward(t, []interface{}{"kube.foo", compressed.Bytes(), map[string]interface{}{"compressed": "lz4"}})
+	_, _, _, err = decodeForwardMessage(newForwardDecoder(buf, forwardMaxBytes))
+	assert.Error(t, err, "expected an error for an unsupported compression")
+}
+
+func TestDecodeMalformedForwardMessage(t *testing.T) {
+	for _, v := range []interface{}{
+		"not an array",
+		[]interface{}{"kube.foo"},
+		[]interface{}{"kube.foo", forwardTime.Unix()},
+		[]interface{}{"kube.foo", forwardTime.Unix(), "not a record"},
+		[]interface{}{"kube.foo", []interface{}{[]interface{}{forwardTime.Unix()}}},
+	} {
+		_, _, _, err := decodeForwardMessage(newForwardDecoder(encodeForward(t, v), forwardMaxBytes))
+		assert.Error(t, err, "expected an error decoding %v", v)
+	}
+}
+
+func TestDecodeForwardMessageTooLarge(t *testing.T) {
+	// lengths declared beyond the bytes left in the message are refused before allocating
+	for _, header := range [][]byte{
+		{0x93, 0xa8, 'k', 'u', 'b', 'e', '.', 'f', 'o', 'o', 0xdd, 0x7f, 0xff, 0xff, 0xff},
+		{0x93, 0xa8, 'k', 'u', 'b', 'e', '.', 'f', 'o', 'o', 0xc6, 0x7f, 0xff, 0xff, 0xff},
+		{0x93, 0xa8, 'k', 'u', 'b', 'e', '.', 'f', 'o', 'o', 0x01, 0xdf, 0x7f, 0xff, 0xff, 0xff},
+		{0x93, 0xa8, 'k', 'u', 'b', 'e', '.', 'f', 'o', 'o', 0x01, 0x81, 0xa3, 'l', 'o', 'g', 0xdd, 0x7f, 0xff, 0xff, 0xff},
+	} {
+		_, _, _, err := decodeForwardMessage(newForwardDecoder(bytes.NewReader(header), forwardMaxBytes))
+		assert.ErrorIs(t, err, errForwardTooLarge, "expected an error decoding % x", header)
+	}
+
+	record := map[string]interface{}{"log": strings.Repeat("a", 2*forwardMaxBytes)}
+	buf := encodeForward(t, []interface{}{"kube.foo", forwardTime.Unix(), record})
+	_, _, _, err := decodeForwardMessage(newForwardDecoder(buf, forwardMaxBytes))
+	assert.ErrorIs(t, err, errForwardTooLarge)
+
+	// as are packed entries taking more than the maximum once decompressed
+	compressed := new(bytes.Buffer)
+	gz := gzip.NewWriter(compressed)
+	gz.Write(packForwardEntries(t, forwardMaxBytes/100))
+	gz.Close()
+	buf = encodeForward(t, []interface{}{"kube.foo", compressed.Bytes(), map[string]interface{}{"compressed": "gzip"}})
+	_, _, _, err = decodeForwardMessage(newForwardDecoder(buf, forwardMaxBytes))
+	assert.ErrorIs(t, err, errForwardTooLarge)
+
+	// the maximum applies to each message of a connection
+	buf = new(bytes.Buffer)
+	for i := 0; i < 3; i++ {
+		buf.Write(encodeForward(t, []interface{}{"kube.foo", newEventTime(forwardTime), forwardRecord}).Bytes())
+	}
+	dec := newForwardDecoder(buf, int64(buf.Len()/2))
+	for i := 0; i < 3; i++ {
+		_, entries, _, err := decodeForwardMessage(dec)
+		assert.NoError(t, err)
+		assertForwardEntries(t, entries, 1)
+	}
+}
+
+func TestDecodeForwardMessageNesting(t *testing.T) {
+	var value interface{} = "test message"
+	for i := 0; i < forwardMaxDepth; i++ {
+		value = []interface{}{value}
+	}
+	buf := encodeForward(t, []interface{}{"kube.foo", forwardTime.Unix(), map[string]interface{}{"log": value}})
+	_, _, _, err := decodeForwardMessage(newForwardDecoder(buf, forwardMaxBytes))
+	assert.ErrorIs(t, err, errForwardMalformed)
+}
@@ -0,0 +1,175 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/drycc/logger/storage"
+	"github.com/gorilla/mux"
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxReportedErrors caps the number of per-message errors returned in an ingest response
+const maxReportedErrors = 10
+
+var errBodyTooLarge = errors.New("request body too large")
+
+type httpAggregator struct {
+	*listenerAggregator
+	handle   func([]byte) error
+	listener net.Listener
+	server   *http.Server
+}
+
+// ingestResult is the response body of a batch POST
+type ingestResult struct {
+	Accepted int           `json:"accepted"`
+	Rejected int           `json:"rejected"`
+	Errors   []ingestError `json:"errors,omitempty"`
+}
+
+type ingestError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+func newHTTPAggregator(storageAdapter storage.Adapter) Aggregator {
+	return &httpAggregator{
+		listenerAggregator: newListenerAggregator(nil),
+		handle: func(rawMessage []byte) error {
+			return observeHandle("http", handle(rawMessage, storageAdapter))
+		},
+	}
+}
+
+// Listen starts the aggregator, serving the ingest endpoint on the configured address.
+// Invocations of this function are not concurrency safe and multiple serialized invocations have
+// no effect.
+func (a *httpAggregator) Listen() error {
+	if a.listening {
+		return nil
+	}
+	cfg, err := a.config()
+	if err != nil {
+		return err
+	}
+	if a.listener, err = net.Listen("tcp", cfg.HTTPAddr); err != nil {
+		return err
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/logs", a.ingest).Methods("POST")
+	r.HandleFunc("/logs/", a.ingest).Methods("POST")
+	a.server = &http.Server{Handler: r}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		if err := a.server.Serve(a.listener); !errors.Is(err, http.ErrServerClosed) {
+			a.fail(err)
+		}
+	}()
+	a.start(a.server)
+	return nil
+}
+
+// ingest accepts a batch of messages as newline-delimited JSON or as a JSON array and responds
+// with the number of accepted and rejected messages.
+func (a *httpAggregator) ingest(w http.ResponseWriter, r *http.Request) {
+	data, err := readRequestBody(w, r, a.cfg.HTTPMaxBodyBytes)
+	if err != nil {
+		http.Error(w, err.Error(), requestBodyErrorStatus(err))
+		return
+	}
+	messages, err := splitBatch(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result := ingestResult{}
+	for i, rawMessage := range messages {
+		if err := a.handle(rawMessage); err != nil {
+			result.Rejected++
+			if len(result.Errors) < maxReportedErrors {
+				result.Errors = append(result.Errors, ingestError{Index: i, Error: err.Error()})
+			}
+			continue
+		}
+		result.Accepted++
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(result)
+}
+
+// splitBatch splits a JSON array or newline-delimited JSON into individual messages.
+func splitBatch(data []byte) ([]json.RawMessage, error) {
+	data = bytes.TrimSpace(data)
+	var messages []json.RawMessage
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %v", err)
+		}
+		return messages, nil
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			messages = append(messages, line)
+		}
+	}
+	return messages, nil
+}
+
+// readRequestBody reads a request body of at most maxBytes bytes, before and after decoding a
+// gzip or zstd Content-Encoding.
+func readRequestBody(w http.ResponseWriter, r *http.Request, maxBytes int64) ([]byte, error) {
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxBytes)
+	switch encoding := strings.ToLower(r.Header.Get("Content-Encoding")); encoding {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	case "zstd":
+		zr, err := zstd.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		body = zr
+	default:
+		return nil, errUnsupportedEncoding{encoding: encoding}
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxBytes+1))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || int64(len(data)) > maxBytes {
+		return nil, errBodyTooLarge
+	}
+	return data, err
+}
+
+type errUnsupportedEncoding struct {
+	encoding string
+}
+
+func (e errUnsupportedEncoding) Error() string {
+	return fmt.Sprintf("unsupported content encoding: %s", e.encoding)
+}
+
+func requestBodyErrorStatus(err error) int {
+	if errors.Is(err, errBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.As(err, new(errUnsupportedEncoding)) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,104 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHTTPAggregator(t *testing.T) (*httpAggregator, *[]string) {
+	var handled []string
+	aggregator := &httpAggregator{
+		listenerAggregator: newListenerAggregator(&config{
+			HTTPAddr:           "127.0.0.1:0",
+			HTTPMaxBodyBytes:   1024,
+			StopTimeoutSeconds: 1,
+		}),
+		handle: func(rawMessage []byte) error {
+			message := new(Message)
+			if err := json.Unmarshal(rawMessage, message); err != nil {
+				return err
+			}
+			handled = append(handled, message.Log)
+			return nil
+		},
+	}
+	assert.NoError(t, aggregator.Listen())
+	return aggregator, &handled
+}
+
+func postLogs(t *testing.T, a *httpAggregator, body []byte, encoding string) (*http.Response, ingestResult) {
+	req, err := http.NewRequest("POST", "http://"+a.listener.Addr().String()+"/logs", bytes.NewReader(body))
+	assert.NoError(t, err)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	result := ingestResult{}
+	if resp.StatusCode == http.StatusAccepted {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	}
+	return resp, result
+}
+
+func TestHTTPAggregatorNDJSON(t *testing.T) {
+	a, handled := newTestHTTPAggregator(t)
+	stoppedCh := a.Stopped()
+	body := `{"log": "one"}` + "\n\n" + `{"log":}` + "\n" + `{"log": "two"}` + "\n"
+	resp, result := postLogs(t, a, []byte(body), "")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, 2, result.Accepted)
+	assert.Equal(t, 1, result.Rejected)
+	assert.Equal(t, 1, result.Errors[0].Index)
+	assert.Equal(t, []string{"one", "two"}, *handled)
+
+	assert.NoError(t, a.Stop())
+	assert.NoError(t, <-stoppedCh, "aggregator stopped with error")
+}
+
+func TestHTTPAggregatorCompressedArray(t *testing.T) {
+	a, handled := newTestHTTPAggregator(t)
+	defer a.Stop()
+	body := []byte(`[{"log": "one"}, {"log": "two"}]`)
+
+	gzBody := new(bytes.Buffer)
+	gz := gzip.NewWriter(gzBody)
+	gz.Write(body)
+	gz.Close()
+	resp, result := postLogs(t, a, gzBody.Bytes(), "gzip")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, 2, result.Accepted)
+
+	zw, err := zstd.NewWriter(nil)
+	assert.NoError(t, err)
+	resp, result = postLogs(t, a, zw.EncodeAll(body, nil), "zstd")
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, 2, result.Accepted)
+	assert.Equal(t, []string{"one", "two", "one", "two"}, *handled)
+}
+
+func TestHTTPAggregatorInvalidRequests(t *testing.T) {
+	a, _ := newTestHTTPAggregator(t)
+	defer a.Stop()
+	resp, _ := postLogs(t, a, []byte(`[{"log": "one"`), "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = postLogs(t, a, []byte(`{}`), "br")
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	resp, _ = postLogs(t, a, []byte(strings.Repeat(`{"log": "x"}`+"\n", 100)), "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestHTTPBasedAggregator(t *testing.T) {
+	a, err := NewAggregator("http", &stubStorageAdapter{})
+	assert.NoError(t, err)
+	_, ok := a.(*httpAggregator)
+	assert.True(t, ok, "expected a *log.httpAggregator")
+}
@@ -0,0 +1,108 @@
+package log
+
+import (
+	"context"
+	"io"
+	l "log"
+	"net"
+	"sync"
+	"time"
+)
+
+// listenerAggregator holds the lifecycle shared by aggregators that receive messages on their own
+// listeners. Embedders bind their listeners in Listen, run every serving goroutine under wg and
+// call fail when a listener errors.
+type listenerAggregator struct {
+	listening bool
+	cfg       *config
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	done      chan struct{}
+	err       error
+	errOnce   sync.Once
+}
+
+func newListenerAggregator(cfg *config) *listenerAggregator {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &listenerAggregator{
+		cfg:    cfg,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+// config returns the aggregator configuration, parsing it from the environment on first use.
+func (a *listenerAggregator) config() (*config, error) {
+	if a.cfg == nil {
+		cfg, err := parseConfig(appName)
+		if err != nil {
+			return nil, err
+		}
+		a.cfg = cfg
+	}
+	return a.cfg, nil
+}
+
+// start marks the aggregator as listening. Once the aggregator is stopped the closers are closed,
+// serving goroutines are waited for and Stopped receives.
+func (a *listenerAggregator) start(closers ...io.Closer) {
+	a.listening = true
+	go func() {
+		<-a.ctx.Done()
+		for _, c := range closers {
+			c.Close()
+		}
+		a.wg.Wait()
+		a.errOnce.Do(func() {})
+		close(a.done)
+	}()
+}
+
+// fail stops the aggregator because a listener errored, unless it is already stopping.
+func (a *listenerAggregator) fail(err error) {
+	select {
+	case <-a.ctx.Done():
+	default:
+		l.Printf("aggregator listener error: %v", err)
+		a.errOnce.Do(func() { a.err = err })
+		a.cancel()
+	}
+}
+
+// Stop is the Aggregator interface implementation
+func (a *listenerAggregator) Stop() error {
+	a.cancel()
+	if !a.listening {
+		return nil
+	}
+	timeout := a.cfg.stopTimeoutDuration()
+	tmr := time.NewTimer(timeout)
+	defer tmr.Stop()
+	select {
+	case <-tmr.C:
+		return newErrStopTimedOut(timeout)
+	case <-a.done:
+		return nil
+	}
+}
+
+// Stopped is the Aggregator interface implementation
+func (a *listenerAggregator) Stopped() <-chan error {
+	retCh := make(chan error, 1)
+	go func() {
+		<-a.done
+		retCh <- a.err
+	}()
+	return retCh
+}
+
+// renewReadDeadline gives a TCP connection the idle timeout to send its next frame, so that the
+// connections of clients gone without closing them are closed. A zero timeout disables it.
+func (a *listenerAggregator) renewReadDeadline(conn net.Conn) error {
+	if timeout := a.cfg.tcpIdleTimeoutDuration(); timeout > 0 {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	return nil
+}
@@ -13,23 +13,33 @@ const (
 	podPattern              = `(\w.*)-(\w.*)-(\w.*)-(\w.*)`
 	controllerPattern       = `^(INFO|WARN|DEBUG|ERROR)\s+(\[(\S+)\])+:(.*)`
 	controllerContainerName = "drycc-controller"
-	timeFormat              = "2006-01-02T15:04:05-07:00"
+	timeFormat              = storage.TimeFormat
 )
 
 var (
 	controllerRegex = regexp.MustCompile(controllerPattern)
+	podRegex        = regexp.MustCompile(podPattern)
 )
 
 func handle(rawMessage []byte, storageAdapter storage.Adapter) error {
 	message := new(Message)
 	if err := json.Unmarshal(rawMessage, message); err != nil {
-		return err
+		return errDecode{err: err}
 	}
-	if fromController(message) {
-		if err := storageAdapter.Write(getApplicationFromControllerMessage(message), buildControllerLogMessage(message)); err != nil {
+	return handleMessage(message, storageAdapter)
+}
+
+// handleMessage routes an already decoded message and writes it to the storage adapter, along with
+// the metadata logs are filtered by.
+func handleMessage(message *Message, storageAdapter storage.Adapter) error {
+	app, line, fields, err := getRouter().route(message)
+	if err != nil {
+		return errRoute{err: err}
+	}
+	if app != "" {
+		if err := storageAdapter.Write(app, storage.EncodeLine(line, getMetadataFromMessage(message, fields))); err != nil {
 			fmt.Printf("storage message error, %v, %v", err, storageAdapter)
 		}
-
 	}
 	return nil
 }
@@ -39,11 +49,83 @@ func fromController(message *Message) bool {
 	return matched
 }
 
+// getApplicationFromControllerMessage returns the app a drycc-controller log line refers to, or an
+// empty string when the line does not match the controller pattern.
 func getApplicationFromControllerMessage(message *Message) string {
-	return controllerRegex.FindStringSubmatch(message.Log)[3]
+	if l := controllerRegex.FindStringSubmatch(message.Log); l != nil {
+		return l[3]
+	}
+	return ""
 }
 
-func buildControllerLogMessage(message *Message) string {
+// buildControllerLogMessage renders a drycc-controller log line. It fails when the line does not
+// match the controller pattern.
+func buildControllerLogMessage(message *Message) (string, error) {
 	l := controllerRegex.FindStringSubmatch(message.Log)
-	return fmt.Sprintf("%s drycc[controller]: %s %s", message.Time.Format(timeFormat), l[1], strings.Trim(l[4], " "))
+	if l == nil {
+		return "", fmt.Errorf("log does not match controller pattern: %q", message.Log)
+	}
+	return fmt.Sprintf("%s drycc[controller]: %s %s", message.Time.Format(timeFormat), l[1], strings.Trim(l[4], " ")), nil
+}
+
+// getApplicationFromMessage returns the app a container log line belongs to. The "app" label is
+// preferred, then the namespace, then the leading segment of the pod name.
+func getApplicationFromMessage(message *Message) string {
+	if app := message.Kubernetes.Labels["app"]; app != "" {
+		return app
+	}
+	if message.Kubernetes.Namespace != "" {
+		return message.Kubernetes.Namespace
+	}
+	if p := podRegex.FindStringSubmatch(message.Kubernetes.PodName); p != nil {
+		return p[1]
+	}
+	return ""
+}
+
+// getProcessTagFromMessage returns the "<type>.<version>" tag identifying the process that
+// emitted a log line, e.g. "web.v2". The process type falls back to the pod name when the "type"
+// label is missing and the version is omitted when the "version" label is missing.
+func getProcessTagFromMessage(message *Message) string {
+	procType := message.Kubernetes.Labels["type"]
+	if procType == "" {
+		if p := podRegex.FindStringSubmatch(message.Kubernetes.PodName); p != nil {
+			procType = p[2]
+		}
+	}
+	if version := message.Kubernetes.Labels["version"]; version != "" {
+		return fmt.Sprintf("%s.%s", procType, version)
+	}
+	return procType
+}
+
+func buildApplicationLogMessage(message *Message) string {
+	return fmt.Sprintf("%s drycc[%s]: %s", message.Time.Format(timeFormat), getProcessTagFromMessage(message), strings.TrimRight(message.Log, "\r\n"))
+}
+
+// getMetadataFromMessage returns the metadata stored along with the line of a message. The level
+// parsed from the log line is preferred to the level set by the log shipper.
+func getMetadataFromMessage(message *Message, fields map[string]string) storage.Metadata {
+	level := fields["level"]
+	if level == "" {
+		level = message.Level
+	}
+	return storage.Metadata{
+		Time:      message.Time,
+		Process:   getProcessTypeFromMessage(message),
+		Pod:       message.Kubernetes.PodName,
+		Container: message.Kubernetes.ContainerName,
+		Stream:    message.Stream,
+		Level:     strings.ToLower(level),
+	}
+}
+
+// getProcessTypeFromMessage returns the type of the process that emitted a log line, e.g. "web",
+// or "controller" for the lines of drycc-controller
+func getProcessTypeFromMessage(message *Message) string {
+	if fromController(message) {
+		return "controller"
+	}
+	procType, _, _ := strings.Cut(getProcessTagFromMessage(message), ".")
+	return procType
 }
@@ -3,6 +3,7 @@ package log
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/drycc/logger/storage"
 	"github.com/stretchr/testify/assert"
@@ -44,15 +45,24 @@ func TestBuildControllerLogMessageFromValidMessage(t *testing.T) {
 	message := new(Message)
 	err := json.Unmarshal([]byte(validControllerMessage), message)
 	assert.NoError(t, err, "error occurred parsing log message")
-	expected := buildControllerLogMessage(message)
+	expected, err := buildControllerLogMessage(message)
+	assert.NoError(t, err)
 	assert.Equal(t, expected,
 		"2016-10-18T20:29:38+00:00 drycc[controller]: INFO admin deployed 2fd9226",
 		"failed to build controller log")
 }
 
+func TestBuildControllerLogMessageFromInvalidMessage(t *testing.T) {
+	message := new(Message)
+	err := json.Unmarshal([]byte(invalidAppMessage), message)
+	assert.NoError(t, err, "error occurred parsing log message")
+	_, err = buildControllerLogMessage(message)
+	assert.Error(t, err, "expected an error for a non-controller line")
+	assert.Equal(t, "", getApplicationFromControllerMessage(message))
+}
+
 func TestHandleValidControllerMessage(t *testing.T) {
-	storage.LogRoot = "/tmp"
-	a, err := storage.NewAdapter("file", 1)
+	a, err := storage.NewAdapter("memory", 1)
 	assert.NoError(t, err, "error creating ring buffer")
 	err = handle([]byte(validControllerMessage), a)
 	assert.NoError(t, err, "error occurred storing log message")
@@ -63,9 +73,72 @@ func TestHandleValidControllerMessage(t *testing.T) {
 }
 
 func TestHandleInvalidControllerMessage(t *testing.T) {
-	storage.LogRoot = "/tmp"
-	a, err := storage.NewAdapter("file", 1)
+	a, err := storage.NewAdapter("memory", 1)
 	assert.NoError(t, err, "error creating ring buffer")
 	err = handle([]byte(badjson), a)
 	assert.Error(t, err, "no error occurred parsing json")
 }
+
+func TestGetApplicationFromAppMessage(t *testing.T) {
+	message := new(Message)
+	err := json.Unmarshal([]byte(invalidAppMessage), message)
+	assert.NoError(t, err, "error occurred parsing log message")
+	assert.Equal(t, "foo", getApplicationFromMessage(message), "failed to retrieve app from labels")
+	delete(message.Kubernetes.Labels, "app")
+	message.Kubernetes.Namespace = "bar"
+	assert.Equal(t, "bar", getApplicationFromMessage(message), "failed to retrieve app from namespace")
+	message.Kubernetes.Namespace = ""
+	assert.Equal(t, "foo", getApplicationFromMessage(message), "failed to retrieve app from pod name")
+}
+
+func TestBuildApplicationLogMessage(t *testing.T) {
+	message := new(Message)
+	err := json.Unmarshal([]byte(invalidAppMessage), message)
+	assert.NoError(t, err, "error occurred parsing log message")
+	message.Log = "test message\n"
+	assert.Equal(t, "2016-10-18T20:29:38+00:00 drycc[web.v2]: test message", buildApplicationLogMessage(message))
+	message.Kubernetes.Labels = map[string]string{}
+	assert.Equal(t, "2016-10-18T20:29:38+00:00 drycc[web]: test message", buildApplicationLogMessage(message))
+}
+
+func TestHandleAppMessage(t *testing.T) {
+	a, err := storage.NewAdapter("memory", 1)
+	assert.NoError(t, err, "error creating ring buffer")
+	err = handle([]byte(invalidAppMessage), a)
+	assert.NoError(t, err, "error occurred storing log message")
+	expected, _ := a.Read("foo", 1)
+	assert.Equal(t, expected[0],
+		"2016-10-18T20:29:38+00:00 drycc[web.v2]: test message",
+		"failed to acquire app log message")
+}
+
+func TestHandleMessageMetadata(t *testing.T) {
+	a, err := storage.NewAdapter("memory", 1)
+	assert.NoError(t, err, "error creating ring buffer")
+	err = handle([]byte(validControllerMessage), a)
+	assert.NoError(t, err, "error occurred storing log message")
+	entries, err := storage.ReadEntries(a, "foo", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "controller", entries[0].Metadata.Process)
+	assert.Equal(t, "drycc-controller", entries[0].Metadata.Container)
+	assert.Equal(t, "info", entries[0].Metadata.Level)
+
+	// the level set by the log shipper is used when the line has none
+	message := new(Message)
+	assert.NoError(t, json.Unmarshal([]byte(invalidAppMessage), message))
+	message.Level = "WARN"
+	assert.NoError(t, handleMessage(message, a))
+	entries, err = storage.ReadEntries(a, "foo", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "2016-10-18T20:29:38+00:00 drycc[web.v2]: test message", entries[0].Line)
+	metadata := entries[0].Metadata
+	assert.True(t, message.Time.Equal(metadata.Time))
+	metadata.Time = time.Time{}
+	assert.Equal(t, storage.Metadata{
+		Process:   "web",
+		Pod:       "foo-web-845861952-nzf60",
+		Container: "foo-web",
+		Stream:    "stderr",
+		Level:     "warn",
+	}, metadata)
+}
@@ -0,0 +1,77 @@
+package log
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	messagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logger",
+		Subsystem: "aggregator",
+		Name:      "messages_total",
+		Help:      "Number of messages consumed by the aggregator.",
+	}, []string{"aggregator"})
+	handleErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logger",
+		Subsystem: "aggregator",
+		Name:      "handle_errors_total",
+		Help:      "Number of messages the aggregator failed to handle, by reason.",
+	}, []string{"aggregator", "reason"})
+	valkeyErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logger",
+		Subsystem: "aggregator",
+		Name:      "valkey_errors_total",
+		Help:      "Number of valkey stream operations of the aggregator that failed, by operation: ack, dead_letter or reclaim.",
+	}, []string{"operation"})
+)
+
+// errDecode wraps the error of a message that could not be decoded
+type errDecode struct {
+	err error
+}
+
+func (e errDecode) Error() string { return e.err.Error() }
+
+func (e errDecode) Unwrap() error { return e.err }
+
+// errRoute wraps the error of a message the router failed to route
+type errRoute struct {
+	err error
+}
+
+func (e errRoute) Error() string { return e.err.Error() }
+
+func (e errRoute) Unwrap() error { return e.err }
+
+// handleErrorReason returns the reason label of a handle error
+func handleErrorReason(err error) string {
+	switch {
+	case errors.Is(err, errMissingData):
+		return "missing_data"
+	case errors.As(err, new(errDecode)):
+		return "decode"
+	case errors.As(err, new(errRoute)):
+		return "route"
+	}
+	return "other"
+}
+
+// The operations counted by valkeyErrorsTotal
+const (
+	operationAck        = "ack"
+	operationDeadLetter = "dead_letter"
+	operationReclaim    = "reclaim"
+)
+
+// observeHandle counts a message consumed by an aggregator and, when handling it failed, the
+// reason. It returns err so that it can wrap handle calls.
+func observeHandle(aggregator string, err error) error {
+	messagesTotal.WithLabelValues(aggregator).Inc()
+	if err != nil {
+		handleErrorsTotal.WithLabelValues(aggregator, handleErrorReason(err)).Inc()
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package log
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/drycc/logger/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleErrorReason(t *testing.T) {
+	a, err := storage.NewAdapter("memory", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "decode", handleErrorReason(handle([]byte("{"), a)))
+	assert.Equal(t, "route", handleErrorReason(handleMessage(&Message{
+		Log:        "not a controller log",
+		Kubernetes: Kubernetes{ContainerName: controllerContainerName},
+	}, a)))
+	assert.Equal(t, "missing_data", handleErrorReason(errMissingData))
+	assert.Equal(t, "other", handleErrorReason(errors.New("unexpected")))
+}
+
+func TestObserveHandle(t *testing.T) {
+	assert.NoError(t, observeHandle("test", nil))
+	err := errDecode{err: errors.New("invalid")}
+	assert.Equal(t, err, observeHandle("test", err))
+	assert.Equal(t, 2.0, testutil.ToFloat64(messagesTotal.WithLabelValues("test")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(handleErrorsTotal.WithLabelValues("test", "decode")))
+}
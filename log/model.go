@@ -16,6 +16,7 @@ type Message struct {
 	Stream     string     `json:"stream"`
 	Kubernetes Kubernetes `json:"kubernetes"`
 	Time       time.Time  `json:"time"`
+	Level      string     `json:"level,omitempty"`
 }
 
 // Kubernetes specific log message fields
@@ -0,0 +1,229 @@
+package log
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drycc/logger/storage"
+	"github.com/gorilla/mux"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	otlpProtobufContentType = "application/x-protobuf"
+	otlpJSONContentType     = "application/json"
+	// otlpPodLabelPrefix prefixes resource attributes holding pod labels, as set by the
+	// k8sattributes processor of the OpenTelemetry collector
+	otlpPodLabelPrefix = "k8s.pod.label."
+)
+
+type otlpAggregator struct {
+	*listenerAggregator
+	handle   func(*Message) error
+	listener net.Listener
+	server   *http.Server
+}
+
+func newOTLPAggregator(storageAdapter storage.Adapter) Aggregator {
+	return &otlpAggregator{
+		listenerAggregator: newListenerAggregator(nil),
+		handle: func(message *Message) error {
+			return observeHandle("otlp", handleMessage(message, storageAdapter))
+		},
+	}
+}
+
+// Listen starts the aggregator, serving the OTLP/HTTP logs endpoint on the configured address.
+// Invocations of this function are not concurrency safe and multiple serialized invocations have
+// no effect.
+func (a *otlpAggregator) Listen() error {
+	if a.listening {
+		return nil
+	}
+	cfg, err := a.config()
+	if err != nil {
+		return err
+	}
+	if a.listener, err = net.Listen("tcp", cfg.OTLPAddr); err != nil {
+		return err
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/logs", a.export).Methods("POST")
+	a.server = &http.Server{Handler: r}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		if err := a.server.Serve(a.listener); !errors.Is(err, http.ErrServerClosed) {
+			a.fail(err)
+		}
+	}()
+	a.start(a.server)
+	return nil
+}
+
+// export implements the OTLP/HTTP logs export, accepting protobuf and JSON encoded requests and
+// answering in the encoding of the request.
+func (a *otlpAggregator) export(w http.ResponseWriter, r *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != otlpProtobufContentType && contentType != otlpJSONContentType {
+		http.Error(w, fmt.Sprintf("unsupported content type: %s", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+	data, err := readRequestBody(w, r, a.cfg.HTTPMaxBodyBytes)
+	if err != nil {
+		http.Error(w, err.Error(), requestBodyErrorStatus(err))
+		return
+	}
+	req := new(collogspb.ExportLogsServiceRequest)
+	if contentType == otlpJSONContentType {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, req)
+	} else {
+		err = proto.Unmarshal(data, req)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := new(collogspb.ExportLogsServiceResponse)
+	var rejected int64
+	var lastErr error
+	for _, resourceLogs := range req.GetResourceLogs() {
+		for _, message := range messagesFromResourceLogs(resourceLogs, time.Now()) {
+			if err := a.handle(message); err != nil {
+				rejected++
+				lastErr = err
+			}
+		}
+	}
+	if rejected > 0 {
+		resp.PartialSuccess = &collogspb.ExportLogsPartialSuccess{
+			RejectedLogRecords: rejected,
+			ErrorMessage:       lastErr.Error(),
+		}
+	}
+
+	var body []byte
+	if contentType == otlpJSONContentType {
+		body, err = protojson.Marshal(resp)
+	} else {
+		body, err = proto.Marshal(resp)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// messagesFromResourceLogs maps the log records of a resource onto Messages. Kubernetes resource
+// attributes populate the Kubernetes fields and "k8s.pod.label.*" attributes become labels.
+func messagesFromResourceLogs(resourceLogs *logspb.ResourceLogs, now time.Time) []*Message {
+	k8s := Kubernetes{Labels: make(map[string]string)}
+	var serviceName string
+	for _, kv := range resourceLogs.GetResource().GetAttributes() {
+		value := otlpValueString(kv.GetValue())
+		switch key := kv.GetKey(); key {
+		case "k8s.namespace.name":
+			k8s.Namespace = value
+		case "k8s.pod.name":
+			k8s.PodName = value
+		case "k8s.pod.uid":
+			k8s.PodID = value
+		case "k8s.container.name":
+			k8s.ContainerName = value
+		case "k8s.node.name":
+			k8s.Host = value
+		case "host.name":
+			if k8s.Host == "" {
+				k8s.Host = value
+			}
+		case "service.name":
+			serviceName = value
+		default:
+			if strings.HasPrefix(key, otlpPodLabelPrefix) {
+				k8s.Labels[strings.TrimPrefix(key, otlpPodLabelPrefix)] = value
+			}
+		}
+	}
+	// outside of Kubernetes the service name is the only hint of the app
+	if _, ok := k8s.Labels["app"]; !ok && k8s.Namespace == "" && serviceName != "" {
+		k8s.Labels["app"] = serviceName
+	}
+
+	var messages []*Message
+	for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+		for _, record := range scopeLogs.GetLogRecords() {
+			message := &Message{
+				Log:        otlpValueString(record.GetBody()),
+				Kubernetes: k8s,
+				Level:      otlpLevel(record),
+				Time:       now,
+			}
+			if t := record.GetTimeUnixNano(); t != 0 {
+				message.Time = time.Unix(0, int64(t))
+			} else if t := record.GetObservedTimeUnixNano(); t != 0 {
+				message.Time = time.Unix(0, int64(t))
+			}
+			message.Stream = "stdout"
+			if record.GetSeverityNumber() >= logspb.SeverityNumber_SEVERITY_NUMBER_ERROR {
+				message.Stream = "stderr"
+			}
+			for _, kv := range record.GetAttributes() {
+				if kv.GetKey() == "log.iostream" {
+					message.Stream = otlpValueString(kv.GetValue())
+				}
+			}
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// otlpLevel maps the severity of a log record onto the levels used by drycc components, falling
+// back to the severity text when no severity number is set.
+func otlpLevel(record *logspb.LogRecord) string {
+	switch n := record.GetSeverityNumber(); {
+	case n >= logspb.SeverityNumber_SEVERITY_NUMBER_ERROR:
+		return "ERROR"
+	case n >= logspb.SeverityNumber_SEVERITY_NUMBER_WARN:
+		return "WARN"
+	case n >= logspb.SeverityNumber_SEVERITY_NUMBER_INFO:
+		return "INFO"
+	case n > logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED:
+		return "DEBUG"
+	}
+	return strings.ToUpper(record.GetSeverityText())
+}
+
+func otlpValueString(v *commonpb.AnyValue) string {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(value.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(value.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(value.DoubleValue, 'g', -1, 64)
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(value.BytesValue)
+	case *commonpb.AnyValue_ArrayValue, *commonpb.AnyValue_KvlistValue:
+		b, _ := protojson.Marshal(v)
+		return string(b)
+	}
+	return ""
+}
@@ -0,0 +1,157 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var otlpTime = time.Date(2016, 10, 18, 20, 29, 38, 0, time.UTC)
+
+func otlpString(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func newTestExportLogsRequest() *collogspb.ExportLogsServiceRequest {
+	return &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				otlpString("k8s.namespace.name", "foo"),
+				otlpString("k8s.pod.name", "foo-web-845861952-nzf60"),
+				otlpString("k8s.container.name", "foo-web"),
+				otlpString("k8s.pod.label.type", "web"),
+				otlpString("k8s.pod.label.version", "v2"),
+			}},
+			ScopeLogs: []*logspb.ScopeLogs{{
+				LogRecords: []*logspb.LogRecord{
+					{
+						TimeUnixNano:   uint64(otlpTime.UnixNano()),
+						SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+						Body:           &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "an error occurred"}},
+					},
+					{
+						ObservedTimeUnixNano: uint64(otlpTime.UnixNano()),
+						SeverityText:         "info",
+						Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 42}},
+						Attributes:           []*commonpb.KeyValue{otlpString("log.iostream", "stderr")},
+					},
+				},
+			}},
+		}},
+	}
+}
+
+func TestMessagesFromResourceLogs(t *testing.T) {
+	messages := messagesFromResourceLogs(newTestExportLogsRequest().ResourceLogs[0], time.Now())
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "an error occurred", messages[0].Log)
+	assert.Equal(t, "ERROR", messages[0].Level)
+	assert.Equal(t, "stderr", messages[0].Stream)
+	assert.True(t, otlpTime.Equal(messages[0].Time))
+	assert.Equal(t, "foo", messages[0].Kubernetes.Namespace)
+	assert.Equal(t, "foo-web-845861952-nzf60", messages[0].Kubernetes.PodName)
+	assert.Equal(t, "foo-web", messages[0].Kubernetes.ContainerName)
+	assert.Equal(t, map[string]string{"type": "web", "version": "v2"}, messages[0].Kubernetes.Labels)
+	assert.Equal(t, "42", messages[1].Log)
+	assert.Equal(t, "INFO", messages[1].Level)
+	assert.Equal(t, "stderr", messages[1].Stream)
+	assert.True(t, otlpTime.Equal(messages[1].Time))
+}
+
+func TestMessagesFromResourceLogsWithServiceName(t *testing.T) {
+	resourceLogs := &logspb.ResourceLogs{
+		Resource:  &resourcepb.Resource{Attributes: []*commonpb.KeyValue{otlpString("service.name", "bar")}},
+		ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{{}}}},
+	}
+	messages := messagesFromResourceLogs(resourceLogs, time.Now())
+	assert.Equal(t, "bar", messages[0].Kubernetes.Labels["app"])
+	assert.Equal(t, "stdout", messages[0].Stream)
+}
+
+func newTestOTLPAggregator(t *testing.T, handle func(*Message) error) *otlpAggregator {
+	aggregator := &otlpAggregator{
+		listenerAggregator: newListenerAggregator(&config{
+			OTLPAddr:           "127.0.0.1:0",
+			HTTPMaxBodyBytes:   1 << 20,
+			StopTimeoutSeconds: 1,
+		}),
+		handle: handle,
+	}
+	assert.NoError(t, aggregator.Listen())
+	return aggregator
+}
+
+func postOTLP(t *testing.T, a *otlpAggregator, contentType string, body []byte) (*http.Response, []byte) {
+	resp, err := http.Post("http://"+a.listener.Addr().String()+"/v1/logs", contentType, bytes.NewReader(body))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp, data
+}
+
+func TestOTLPAggregatorProtobuf(t *testing.T) {
+	var handled []*Message
+	a := newTestOTLPAggregator(t, func(message *Message) error {
+		handled = append(handled, message)
+		return nil
+	})
+	stoppedCh := a.Stopped()
+	body, err := proto.Marshal(newTestExportLogsRequest())
+	assert.NoError(t, err)
+	resp, data := postOTLP(t, a, otlpProtobufContentType, body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, otlpProtobufContentType, resp.Header.Get("Content-Type"))
+	exportResp := new(collogspb.ExportLogsServiceResponse)
+	assert.NoError(t, proto.Unmarshal(data, exportResp))
+	assert.Nil(t, exportResp.PartialSuccess)
+	assert.Len(t, handled, 2)
+
+	assert.NoError(t, a.Stop())
+	assert.NoError(t, <-stoppedCh, "aggregator stopped with error")
+}
+
+func TestOTLPAggregatorJSONPartialSuccess(t *testing.T) {
+	a := newTestOTLPAggregator(t, func(message *Message) error {
+		if message.Level == "ERROR" {
+			return errors.New("rejected")
+		}
+		return nil
+	})
+	defer a.Stop()
+	body, err := protojson.Marshal(newTestExportLogsRequest())
+	assert.NoError(t, err)
+	resp, data := postOTLP(t, a, "application/json; charset=utf-8", body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	exportResp := new(collogspb.ExportLogsServiceResponse)
+	assert.NoError(t, protojson.Unmarshal(data, exportResp))
+	assert.Equal(t, int64(1), exportResp.GetPartialSuccess().GetRejectedLogRecords())
+	assert.Equal(t, "rejected", exportResp.GetPartialSuccess().GetErrorMessage())
+}
+
+func TestOTLPAggregatorInvalidRequests(t *testing.T) {
+	a := newTestOTLPAggregator(t, func(*Message) error { return nil })
+	defer a.Stop()
+	resp, _ := postOTLP(t, a, "text/plain", []byte("hello"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	resp, _ = postOTLP(t, a, otlpJSONContentType, []byte("{"))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestOTLPBasedAggregator(t *testing.T) {
+	a, err := NewAggregator("otlp", &stubStorageAdapter{})
+	assert.NoError(t, err)
+	_, ok := a.(*otlpAggregator)
+	assert.True(t, ok, "expected a *log.otlpAggregator")
+}
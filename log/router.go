@@ -0,0 +1,348 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule selects log messages by their Kubernetes metadata and describes how a matching message is
+// parsed, which app it is stored under and how it is rendered into a log line.
+//
+// Example rule file (YAML, or the equivalent JSON):
+//
+//	rules:
+//	- name: builder
+//	  match:
+//	    container_name: ^drycc-builder$
+//	  parser: regex
+//	  pattern: '^(?P<level>\w+) \[(?P<app>[\w-]+)\]: (?P<message>.*)'
+//	  app: '{{.Fields.app}}'
+//	  format: '{{.Timestamp}} drycc[builder]: {{.Fields.level}} {{.Fields.message}}'
+type Rule struct {
+	Name  string    `yaml:"name"`
+	Match RuleMatch `yaml:"match"`
+	// Parser is the name of the parser used to extract fields from the log line: "none" (the
+	// default), "controller", "regex" or "json".
+	Parser string `yaml:"parser"`
+	// Pattern is the regular expression used by the "regex" parser. Named groups become fields.
+	Pattern string `yaml:"pattern"`
+	// Formatter is the name of a built-in formatter: "application" (the default) or "controller".
+	// The "controller" formatter renders the fields of the "controller" parser and requires it.
+	Formatter string `yaml:"formatter"`
+	// Format is a text/template rendering the stored line. It takes precedence over Formatter.
+	Format string `yaml:"format"`
+	// App is a text/template rendering the app the line is stored under. When empty, the "app"
+	// field extracted by the parser is used, falling back to the app derived from the message.
+	App string `yaml:"app"`
+}
+
+// RuleMatch holds the conditions a message must satisfy for a rule to apply. ContainerName and
+// Namespace are regular expressions, Labels must all be present with equal values and Stream must
+// equal the message stream. Empty conditions always match.
+type RuleMatch struct {
+	ContainerName string            `yaml:"container_name"`
+	Namespace     string            `yaml:"namespace"`
+	Labels        map[string]string `yaml:"labels"`
+	Stream        string            `yaml:"stream"`
+}
+
+// RouterConfig is the on-disk representation of the routing rules.
+type RouterConfig struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// routeContext is the data the app and format templates of a rule are executed against.
+type routeContext struct {
+	*Message
+	Fields    map[string]string
+	Timestamp string
+	App       string
+	Tag       string
+}
+
+type parserFunc func(*compiledRule, *Message) (map[string]string, error)
+
+type formatterFunc func(*routeContext) (string, error)
+
+var (
+	parsers = map[string]parserFunc{
+		"none":       parseNone,
+		"controller": parseController,
+		"regex":      parseRegex,
+		"json":       parseJSON,
+	}
+	formatters = map[string]formatterFunc{
+		"application": func(c *routeContext) (string, error) { return buildApplicationLogMessage(c.Message), nil },
+		"controller":  func(c *routeContext) (string, error) { return buildControllerLogMessage(c.Message) },
+	}
+	defaultRules = []Rule{
+		{
+			Name:      "controller",
+			Match:     RuleMatch{ContainerName: controllerContainerName},
+			Parser:    "controller",
+			Formatter: "controller",
+		},
+		{
+			Name:      "application",
+			Parser:    "none",
+			Formatter: "application",
+		},
+	}
+	currentRouter atomic.Pointer[Router]
+)
+
+func init() {
+	r, err := NewRouter(nil)
+	if err != nil {
+		panic(err)
+	}
+	SetRouter(r)
+}
+
+type compiledRule struct {
+	Rule
+	containerName *regexp.Regexp
+	namespace     *regexp.Regexp
+	pattern       *regexp.Regexp
+	parse         parserFunc
+	format        formatterFunc
+	formatTmpl    *template.Template
+	appTmpl       *template.Template
+}
+
+// Router maps log messages onto an app and a rendered log line using an ordered list of rules.
+// The first matching rule wins; messages matching no rule are dropped.
+type Router struct {
+	rules []*compiledRule
+}
+
+// NewRouter returns a Router evaluating the given rules, in order, before the built-in rules that
+// handle drycc-controller and application container logs.
+func NewRouter(rules []Rule) (*Router, error) {
+	r := &Router{}
+	for _, rule := range append(append([]Rule{}, rules...), defaultRules...) {
+		c, err := compileRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, c)
+	}
+	return r, nil
+}
+
+// LoadRouter reads routing rules from a YAML or JSON file and returns a Router evaluating them.
+func LoadRouter(path string) (*Router, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(RouterConfig)
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("error parsing router config %s: %v", path, err)
+	}
+	return NewRouter(cfg.Rules)
+}
+
+// SetRouter replaces the router used to handle incoming messages. It is safe to call while
+// aggregators are running.
+func SetRouter(r *Router) {
+	currentRouter.Store(r)
+}
+
+func getRouter() *Router {
+	return currentRouter.Load()
+}
+
+// Route returns the app and the rendered log line for a message. An empty app with a nil error
+// means no rule matched and the message should be dropped.
+func (r *Router) Route(message *Message) (string, string, error) {
+	app, line, _, err := r.route(message)
+	return app, line, err
+}
+
+// route returns the app, the rendered log line and the fields parsed from a message by the first
+// matching rule.
+func (r *Router) route(message *Message) (string, string, map[string]string, error) {
+	for _, rule := range r.rules {
+		if !rule.matches(message) {
+			continue
+		}
+		fields, err := rule.parse(rule, message)
+		if err != nil {
+			return "", "", nil, fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+		ctx := &routeContext{
+			Message:   message,
+			Fields:    fields,
+			Timestamp: message.Time.Format(timeFormat),
+			App:       getApplicationFromMessage(message),
+			Tag:       getProcessTagFromMessage(message),
+		}
+		app, err := rule.app(ctx)
+		if err != nil {
+			return "", "", nil, fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+		if app == "" {
+			return "", "", nil, fmt.Errorf("rule %s: could not determine app for message", rule.Name)
+		}
+		line, err := rule.line(ctx)
+		if err != nil {
+			return "", "", nil, fmt.Errorf("rule %s: %v", rule.Name, err)
+		}
+		return app, line, fields, nil
+	}
+	return "", "", nil, nil
+}
+
+func compileRule(rule Rule) (*compiledRule, error) {
+	c := &compiledRule{Rule: rule}
+	var err error
+	if rule.Match.ContainerName != "" {
+		if c.containerName, err = regexp.Compile(rule.Match.ContainerName); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid container_name: %v", rule.Name, err)
+		}
+	}
+	if rule.Match.Namespace != "" {
+		if c.namespace, err = regexp.Compile(rule.Match.Namespace); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid namespace: %v", rule.Name, err)
+		}
+	}
+	parserName := rule.Parser
+	if parserName == "" {
+		parserName = "none"
+	}
+	var ok bool
+	if c.parse, ok = parsers[parserName]; !ok {
+		return nil, fmt.Errorf("rule %s: unrecognized parser: '%s'", rule.Name, parserName)
+	}
+	if parserName == "regex" {
+		if c.pattern, err = regexp.Compile(rule.Pattern); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid pattern: %v", rule.Name, err)
+		}
+	}
+	if rule.Format != "" {
+		if c.formatTmpl, err = newRuleTemplate(rule.Name, rule.Format); err != nil {
+			return nil, err
+		}
+	} else {
+		formatterName := rule.Formatter
+		if formatterName == "" {
+			formatterName = "application"
+		}
+		if c.format, ok = formatters[formatterName]; !ok {
+			return nil, fmt.Errorf("rule %s: unrecognized formatter: '%s'", rule.Name, formatterName)
+		}
+		if formatterName == "controller" && parserName != "controller" {
+			return nil, fmt.Errorf("rule %s: formatter 'controller' requires parser 'controller', got '%s'", rule.Name, parserName)
+		}
+	}
+	if rule.App != "" {
+		if c.appTmpl, err = newRuleTemplate(rule.Name, rule.App); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func newRuleTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: invalid template: %v", name, err)
+	}
+	return t, nil
+}
+
+func (c *compiledRule) matches(message *Message) bool {
+	if c.containerName != nil && !c.containerName.MatchString(message.Kubernetes.ContainerName) {
+		return false
+	}
+	if c.namespace != nil && !c.namespace.MatchString(message.Kubernetes.Namespace) {
+		return false
+	}
+	for k, v := range c.Match.Labels {
+		if value, ok := message.Kubernetes.Labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return c.Match.Stream == "" || c.Match.Stream == message.Stream
+}
+
+func (c *compiledRule) app(ctx *routeContext) (string, error) {
+	if c.appTmpl != nil {
+		return executeTemplate(c.appTmpl, ctx)
+	}
+	if app := ctx.Fields["app"]; app != "" {
+		return app, nil
+	}
+	return ctx.App, nil
+}
+
+func (c *compiledRule) line(ctx *routeContext) (string, error) {
+	if c.formatTmpl != nil {
+		return executeTemplate(c.formatTmpl, ctx)
+	}
+	return c.format(ctx)
+}
+
+func executeTemplate(t *template.Template, ctx *routeContext) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, ctx); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func parseNone(_ *compiledRule, message *Message) (map[string]string, error) {
+	return map[string]string{"message": strings.TrimRight(message.Log, "\r\n")}, nil
+}
+
+func parseController(_ *compiledRule, message *Message) (map[string]string, error) {
+	l := controllerRegex.FindStringSubmatch(message.Log)
+	if l == nil {
+		return nil, fmt.Errorf("log does not match controller pattern: %q", message.Log)
+	}
+	return map[string]string{
+		"level":   l[1],
+		"app":     l[3],
+		"message": strings.Trim(l[4], " "),
+	}, nil
+}
+
+func parseRegex(c *compiledRule, message *Message) (map[string]string, error) {
+	l := c.pattern.FindStringSubmatch(message.Log)
+	if l == nil {
+		return nil, fmt.Errorf("log does not match pattern %s: %q", c.Pattern, message.Log)
+	}
+	fields := map[string]string{"message": strings.TrimRight(message.Log, "\r\n")}
+	for i, name := range c.pattern.SubexpNames() {
+		if i > 0 && name != "" {
+			fields[name] = l[i]
+		}
+	}
+	return fields, nil
+}
+
+func parseJSON(_ *compiledRule, message *Message) (map[string]string, error) {
+	values := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(message.Log), &values); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(values))
+	for k, v := range values {
+		if s, ok := v.(string); ok {
+			fields[k] = s
+		} else {
+			b, _ := json.Marshal(v)
+			fields[k] = string(b)
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,101 @@
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const routerConfig = `
+rules:
+- name: builder
+  match:
+    container_name: ^drycc-builder$
+    labels:
+      heritage: drycc
+  parser: regex
+  pattern: '^(?P<level>\w+) \[(?P<app>[\w-]+)\]: (?P<message>.*)'
+  format: '{{.Timestamp}} drycc[builder]: {{.Fields.level}} {{.Fields.message}}'
+- name: stderr-only
+  match:
+    namespace: ^bar$
+    stream: stderr
+  app: '{{index .Kubernetes.Labels "app"}}-errors'
+  format: '{{.Tag}} {{.Log}}'
+`
+
+func newTestMessage(t *testing.T, raw string) *Message {
+	message := new(Message)
+	err := json.Unmarshal([]byte(raw), message)
+	assert.NoError(t, err, "error occurred parsing log message")
+	return message
+}
+
+func TestDefaultRouter(t *testing.T) {
+	r, err := NewRouter(nil)
+	assert.NoError(t, err)
+	app, line, err := r.Route(newTestMessage(t, validControllerMessage))
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", app)
+	assert.Equal(t, "2016-10-18T20:29:38+00:00 drycc[controller]: INFO admin deployed 2fd9226", line)
+	app, line, err = r.Route(newTestMessage(t, invalidAppMessage))
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", app)
+	assert.Equal(t, "2016-10-18T20:29:38+00:00 drycc[web.v2]: test message", line)
+}
+
+func TestRouterControllerMismatch(t *testing.T) {
+	r, err := NewRouter(nil)
+	assert.NoError(t, err)
+	message := newTestMessage(t, validControllerMessage)
+	message.Log = "not a controller line"
+	_, _, err = r.Route(message)
+	assert.Error(t, err, "expected an error for a malformed controller line")
+}
+
+func TestLoadRouter(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "router.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(routerConfig), 0644))
+	r, err := LoadRouter(file)
+	assert.NoError(t, err)
+
+	message := newTestMessage(t, invalidAppMessage)
+	message.Kubernetes.ContainerName = "drycc-builder"
+	message.Log = "INFO [bar]: build complete"
+	app, line, err := r.Route(message)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", app)
+	assert.Equal(t, "2016-10-18T20:29:38+00:00 drycc[builder]: INFO build complete", line)
+
+	message = newTestMessage(t, invalidAppMessage)
+	message.Kubernetes.Namespace = "bar"
+	app, line, err = r.Route(message)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo-errors", app)
+	assert.Equal(t, "web.v2 test message", line)
+
+	// falls through to the built-in application rule
+	message.Stream = "stdout"
+	app, _, err = r.Route(message)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", app)
+}
+
+func TestNewRouterWithInvalidRules(t *testing.T) {
+	for _, rule := range []Rule{
+		{Name: "bad-parser", Parser: "bogus"},
+		{Name: "bad-formatter", Formatter: "bogus"},
+		{Name: "bad-pattern", Parser: "regex", Pattern: "("},
+		{Name: "bad-match", Match: RuleMatch{ContainerName: "("}},
+		{Name: "bad-template", Format: "{{.Log"},
+		{Name: "controller-formatter", Parser: "regex", Pattern: ".*", Formatter: "controller"},
+		{Name: "controller-formatter-default-parser", Formatter: "controller"},
+	} {
+		_, err := NewRouter([]Rule{rule})
+		assert.Error(t, err, "expected an error for rule %s", rule.Name)
+	}
+}
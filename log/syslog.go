@@ -0,0 +1,253 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxSyslogMessageSize = 64 * 1024
+	syslogNilValue       = "-"
+	rfc3164TimeFormat    = time.Stamp
+)
+
+var (
+	errSyslogMalformed = errors.New("malformed syslog message")
+	syslogBOM          = []byte("\xef\xbb\xbf")
+	// syslogLevels maps syslog severities onto the levels used by drycc components
+	syslogLevels = []string{"ERROR", "ERROR", "ERROR", "ERROR", "WARN", "INFO", "INFO", "DEBUG"}
+)
+
+// parseSyslog parses an RFC 5424 or RFC 3164 message into a Message. Structured data parameters
+// of an RFC 5424 message become labels, except namespace_name, pod_name, pod_id and
+// container_name which populate the matching Kubernetes fields. The APP-NAME (or TAG) is used as
+// the container name and as the "app" label when no such parameter is present.
+func parseSyslog(data []byte, now time.Time) (*Message, error) {
+	data = bytes.TrimRight(data, "\r\n\x00")
+	if len(data) < 3 || data[0] != '<' {
+		return nil, errSyslogMalformed
+	}
+	end := bytes.IndexByte(data, '>')
+	if end < 2 || end > 4 {
+		return nil, errSyslogMalformed
+	}
+	// PRI is one to three digits, strconv.Atoi would accept a sign
+	for _, c := range data[1:end] {
+		if c < '0' || c > '9' {
+			return nil, errSyslogMalformed
+		}
+	}
+	pri, err := strconv.Atoi(string(data[1:end]))
+	if err != nil || pri > 191 {
+		return nil, errSyslogMalformed
+	}
+	severity := pri % 8
+	message := &Message{
+		Level:      syslogLevels[severity],
+		Stream:     "stdout",
+		Kubernetes: Kubernetes{Labels: make(map[string]string)},
+	}
+	if severity <= 3 {
+		message.Stream = "stderr"
+	}
+	rest := data[end+1:]
+	if bytes.HasPrefix(rest, []byte("1 ")) {
+		err = parseRFC5424(rest[2:], message)
+	} else {
+		err = parseRFC3164(rest, message, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if message.Time.IsZero() {
+		message.Time = now
+	}
+	if _, ok := message.Kubernetes.Labels["app"]; !ok && message.Kubernetes.ContainerName != "" {
+		message.Kubernetes.Labels["app"] = message.Kubernetes.ContainerName
+	}
+	return message, nil
+}
+
+func parseRFC5424(data []byte, message *Message) error {
+	fields := make([]string, 5)
+	for i := range fields {
+		idx := bytes.IndexByte(data, ' ')
+		if idx < 0 {
+			return errSyslogMalformed
+		}
+		fields[i], data = string(data[:idx]), data[idx+1:]
+	}
+	if fields[0] != syslogNilValue {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return fmt.Errorf("invalid syslog timestamp %q: %v", fields[0], err)
+		}
+		message.Time = t
+	}
+	message.Kubernetes.Host = nilToEmpty(fields[1])
+	message.Kubernetes.ContainerName = nilToEmpty(fields[2])
+	message.Kubernetes.PodID = nilToEmpty(fields[3])
+
+	data, err := parseStructuredData(data, message)
+	if err != nil {
+		return err
+	}
+	data = bytes.TrimPrefix(data, []byte(" "))
+	message.Log = string(bytes.TrimPrefix(data, syslogBOM))
+	return nil
+}
+
+func parseStructuredData(data []byte, message *Message) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	if data[0] == '-' {
+		return data[1:], nil
+	}
+	for len(data) > 0 && data[0] == '[' {
+		// skip the SD-ID
+		idx := bytes.IndexAny(data, " ]")
+		if idx < 0 {
+			return nil, errSyslogMalformed
+		}
+		data = data[idx:]
+		for len(data) > 0 && data[0] == ' ' {
+			data = data[1:]
+			eq := bytes.IndexByte(data, '=')
+			if eq < 0 || len(data) < eq+2 || data[eq+1] != '"' {
+				return nil, errSyslogMalformed
+			}
+			name := string(data[:eq])
+			data = data[eq+2:]
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(data); i++ {
+				if data[i] == '\\' && i+1 < len(data) && strings.IndexByte(`"\]`, data[i+1]) >= 0 {
+					value.WriteByte(data[i+1])
+					i++
+				} else if data[i] == '"' {
+					data = data[i+1:]
+					closed = true
+					break
+				} else {
+					value.WriteByte(data[i])
+				}
+			}
+			if !closed {
+				return nil, errSyslogMalformed
+			}
+			setSyslogParam(message, name, value.String())
+		}
+		if len(data) == 0 || data[0] != ']' {
+			return nil, errSyslogMalformed
+		}
+		data = data[1:]
+	}
+	return data, nil
+}
+
+func setSyslogParam(message *Message, name, value string) {
+	switch name {
+	case "namespace_name":
+		message.Kubernetes.Namespace = value
+	case "pod_name":
+		message.Kubernetes.PodName = value
+	case "pod_id":
+		message.Kubernetes.PodID = value
+	case "container_name":
+		message.Kubernetes.ContainerName = value
+	default:
+		message.Kubernetes.Labels[name] = value
+	}
+}
+
+func parseRFC3164(data []byte, message *Message, now time.Time) error {
+	if len(data) > len(rfc3164TimeFormat) && data[len(rfc3164TimeFormat)] == ' ' {
+		t, err := time.ParseInLocation(rfc3164TimeFormat, string(data[:len(rfc3164TimeFormat)]), now.Location())
+		if err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			// messages sent just before new year arrive after it
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			message.Time = t
+			data = data[len(rfc3164TimeFormat)+1:]
+			if idx := bytes.IndexByte(data, ' '); idx > 0 {
+				message.Kubernetes.Host, data = string(data[:idx]), data[idx+1:]
+			}
+		}
+	}
+	// the TAG is terminated by the first non-alphanumeric character, usually '[' or ':'
+	tagEnd := bytes.IndexFunc(data, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' || r == '/')
+	})
+	if tagEnd > 0 && (data[tagEnd] == '[' || data[tagEnd] == ':') {
+		message.Kubernetes.ContainerName = string(data[:tagEnd])
+		data = data[tagEnd:]
+		if data[0] == '[' {
+			if idx := bytes.IndexByte(data, ']'); idx > 0 {
+				message.Kubernetes.PodID, data = string(data[1:idx]), data[idx+1:]
+			}
+		}
+		data = bytes.TrimPrefix(data, []byte(":"))
+		data = bytes.TrimPrefix(data, []byte(" "))
+	}
+	message.Log = string(data)
+	return nil
+}
+
+func nilToEmpty(s string) string {
+	if s == syslogNilValue {
+		return ""
+	}
+	return s
+}
+
+// readSyslogFrame reads a single message from a syslog TCP stream, supporting both octet-counted
+// and newline-delimited framing (RFC 6587). Octet counting is detected by a leading digit.
+func readSyslogFrame(r *bufio.Reader) ([]byte, error) {
+	b, err := r.Peek(1)
+	// skip stray delimiters left between frames
+	for err == nil && (b[0] == '\n' || b[0] == '\r') {
+		r.Discard(1)
+		b, err = r.Peek(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b[0] >= '0' && b[0] <= '9' {
+		lenStr, err := r.ReadString(' ')
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(lenStr, " "))
+		if err != nil || n <= 0 || n > maxSyslogMessageSize {
+			return nil, fmt.Errorf("invalid syslog frame length %q", lenStr)
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+	var frame []byte
+	for {
+		line, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		frame = append(frame, line...)
+		if len(frame) > maxSyslogMessageSize {
+			return nil, fmt.Errorf("syslog message exceeds %d bytes", maxSyslogMessageSize)
+		}
+		if !isPrefix {
+			return frame, nil
+		}
+	}
+}
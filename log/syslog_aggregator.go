@@ -0,0 +1,134 @@
+package log
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	l "log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/drycc/logger/storage"
+)
+
+type syslogAggregator struct {
+	*listenerAggregator
+	handle      func(*Message)
+	udpConn     net.PacketConn
+	tcpListener net.Listener
+}
+
+func newSyslogAggregator(storageAdapter storage.Adapter) Aggregator {
+	return &syslogAggregator{
+		listenerAggregator: newListenerAggregator(nil),
+		handle: func(message *Message) {
+			if err := observeHandle("syslog", handleMessage(message, storageAdapter)); err != nil {
+				l.Printf("handle message error: %v, %v", err, message)
+			}
+		},
+	}
+}
+
+// Listen starts the aggregator, binding the configured UDP and TCP addresses. An empty address
+// disables the corresponding transport. Invocations of this function are not concurrency safe and
+// multiple serialized invocations have no effect.
+func (a *syslogAggregator) Listen() error {
+	if a.listening {
+		return nil
+	}
+	cfg, err := a.config()
+	if err != nil {
+		return err
+	}
+	var closers []io.Closer
+	if cfg.SyslogUDPAddr != "" {
+		if a.udpConn, err = net.ListenPacket("udp", cfg.SyslogUDPAddr); err != nil {
+			return err
+		}
+		closers = append(closers, a.udpConn)
+	}
+	if cfg.SyslogTCPAddr != "" {
+		if a.tcpListener, err = net.Listen("tcp", cfg.SyslogTCPAddr); err != nil {
+			for _, c := range closers {
+				c.Close()
+			}
+			return err
+		}
+		closers = append(closers, a.tcpListener)
+	}
+	if a.udpConn != nil {
+		a.wg.Add(1)
+		go a.serveUDP()
+	}
+	if a.tcpListener != nil {
+		a.wg.Add(1)
+		go a.serveTCP()
+	}
+	a.start(closers...)
+	return nil
+}
+
+func (a *syslogAggregator) serveUDP() {
+	defer a.wg.Done()
+	buf := make([]byte, maxSyslogMessageSize)
+	for {
+		n, _, err := a.udpConn.ReadFrom(buf)
+		if err != nil {
+			a.fail(err)
+			return
+		}
+		a.handleFrame(buf[:n])
+	}
+}
+
+func (a *syslogAggregator) serveTCP() {
+	defer a.wg.Done()
+	for {
+		conn, err := a.tcpListener.Accept()
+		if err != nil {
+			a.fail(err)
+			return
+		}
+		a.wg.Add(1)
+		go a.serveConn(conn)
+	}
+}
+
+func (a *syslogAggregator) serveConn(conn net.Conn) {
+	defer a.wg.Done()
+	defer conn.Close()
+	stop := context.AfterFunc(a.ctx, func() { conn.Close() })
+	defer stop()
+	reader := bufio.NewReader(conn)
+	for {
+		if err := a.renewReadDeadline(conn); err != nil {
+			l.Printf("syslog connection error from %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		frame, err := readSyslogFrame(reader)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				l.Printf("closing idle syslog connection from %s", conn.RemoteAddr())
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				l.Printf("syslog connection error from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		a.handleFrame(frame)
+	}
+}
+
+func (a *syslogAggregator) handleFrame(frame []byte) {
+	if len(frame) == 0 {
+		return
+	}
+	message, err := parseSyslog(frame, time.Now())
+	if err != nil {
+		observeHandle("syslog", errDecode{err: err})
+		l.Printf("syslog parse error: %v, %q", err, frame)
+		return
+	}
+	a.handle(message)
+}
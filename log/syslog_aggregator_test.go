@@ -0,0 +1,80 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogAggregator(t *testing.T) {
+	msg := make(chan *Message, 10)
+	aggregator := &syslogAggregator{
+		listenerAggregator: newListenerAggregator(&config{
+			SyslogUDPAddr:      "127.0.0.1:0",
+			SyslogTCPAddr:      "127.0.0.1:0",
+			StopTimeoutSeconds: 1,
+		}),
+		handle: func(message *Message) {
+			msg <- message
+		},
+	}
+	assert.NoError(t, aggregator.Listen())
+	stoppedCh := aggregator.Stopped()
+
+	udpConn, err := net.Dial("udp", aggregator.udpConn.LocalAddr().String())
+	assert.NoError(t, err)
+	defer udpConn.Close()
+	fmt.Fprint(udpConn, "<14>1 - host foo - - - over udp")
+
+	tcpConn, err := net.Dial("tcp", aggregator.tcpListener.Addr().String())
+	assert.NoError(t, err)
+	defer tcpConn.Close()
+	fmt.Fprint(tcpConn, "<14>Oct 18 20:29:38 host foo: newline framed\n")
+	fmt.Fprint(tcpConn, "28 <14>1 - host foo - - - octet")
+
+	expected := map[string]bool{"over udp": true, "newline framed": true, "octet": true}
+	for i := 0; i < len(expected); i++ {
+		select {
+		case message := <-msg:
+			assert.True(t, expected[message.Log], "unexpected message %q", message.Log)
+			assert.Equal(t, "foo", message.Kubernetes.Labels["app"])
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for syslog messages")
+		}
+	}
+
+	assert.NoError(t, aggregator.Stop())
+	assert.NoError(t, <-stoppedCh, "aggregator stopped with error")
+}
+
+func TestSyslogAggregatorIdleTimeout(t *testing.T) {
+	aggregator := &syslogAggregator{
+		listenerAggregator: newListenerAggregator(&config{
+			SyslogTCPAddr:         "127.0.0.1:0",
+			TCPIdleTimeoutSeconds: 1,
+			StopTimeoutSeconds:    1,
+		}),
+		handle: func(*Message) {},
+	}
+	assert.NoError(t, aggregator.Listen())
+	defer aggregator.Stop()
+
+	// a connection sending no frame within the idle timeout is closed
+	conn, err := net.Dial("tcp", aggregator.tcpListener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestSyslogBasedAggregator(t *testing.T) {
+	a, err := NewAggregator("syslog", &stubStorageAdapter{})
+	assert.NoError(t, err)
+	_, ok := a.(*syslogAggregator)
+	assert.True(t, ok, "expected a *log.syslogAggregator")
+}
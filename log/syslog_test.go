@@ -0,0 +1,77 @@
+package log
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRFC5424(t *testing.T) {
+	raw := `<11>1 2016-10-18T20:29:38.123Z host foo-web 1234 ID47 [k8s@32473 namespace_name="foo" pod_name="foo-web-845861952-nzf60" type="web" version="v2" note="a \"quoted\" \]"] ` + "\xef\xbb\xbf" + "an error occurred\n"
+	message, err := parseSyslog([]byte(raw), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "an error occurred", message.Log)
+	assert.Equal(t, "stderr", message.Stream)
+	assert.Equal(t, "ERROR", message.Level)
+	assert.Equal(t, time.Date(2016, 10, 18, 20, 29, 38, 123000000, time.UTC), message.Time.UTC())
+	assert.Equal(t, "host", message.Kubernetes.Host)
+	assert.Equal(t, "foo-web", message.Kubernetes.ContainerName)
+	assert.Equal(t, "1234", message.Kubernetes.PodID)
+	assert.Equal(t, "foo", message.Kubernetes.Namespace)
+	assert.Equal(t, "foo-web-845861952-nzf60", message.Kubernetes.PodName)
+	assert.Equal(t, map[string]string{"type": "web", "version": "v2", "note": `a "quoted" ]`, "app": "foo-web"}, message.Kubernetes.Labels)
+}
+
+func TestParseRFC5424NilValues(t *testing.T) {
+	now := time.Now()
+	message, err := parseSyslog([]byte("<14>1 - - - - - -"), now)
+	assert.NoError(t, err)
+	assert.Equal(t, "", message.Log)
+	assert.Equal(t, "stdout", message.Stream)
+	assert.Equal(t, "INFO", message.Level)
+	assert.Equal(t, now, message.Time)
+}
+
+func TestParseRFC3164(t *testing.T) {
+	now := time.Date(2016, 10, 18, 21, 0, 0, 0, time.UTC)
+	message, err := parseSyslog([]byte("<12>Oct 18 20:29:38 host legacy[42]: disk almost full"), now)
+	assert.NoError(t, err)
+	assert.Equal(t, "disk almost full", message.Log)
+	assert.Equal(t, "WARN", message.Level)
+	assert.Equal(t, time.Date(2016, 10, 18, 20, 29, 38, 0, time.UTC), message.Time)
+	assert.Equal(t, "host", message.Kubernetes.Host)
+	assert.Equal(t, "legacy", message.Kubernetes.ContainerName)
+	assert.Equal(t, "42", message.Kubernetes.PodID)
+	assert.Equal(t, "legacy", message.Kubernetes.Labels["app"])
+
+	// a message logged on new year's eve but received after midnight belongs to the previous year
+	now = time.Date(2017, 1, 1, 0, 0, 1, 0, time.UTC)
+	message, err = parseSyslog([]byte("<12>Dec 31 23:59:59 host legacy: bye"), now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2016, message.Time.Year())
+}
+
+func TestParseMalformedSyslog(t *testing.T) {
+	for _, raw := range []string{"", "hello", "<>1 -", "<999>1 - - - - - -", "<-1>msg", "<+1>msg", "<999>msg", "<11>1 - - -", "<11>1 - - - - - [id"} {
+		_, err := parseSyslog([]byte(raw), time.Now())
+		assert.Error(t, err, "expected an error parsing %q", raw)
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("11 <14>1 - - -\n<14>hello\r\n5 <14>x"))
+	frame, err := readSyslogFrame(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "<14>1 - - -", string(frame))
+	frame, err = readSyslogFrame(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "<14>hello", string(frame))
+	frame, err = readSyslogFrame(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "<14>x", string(frame))
+	_, err = readSyslogFrame(r)
+	assert.Error(t, err)
+}
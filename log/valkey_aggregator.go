@@ -2,7 +2,10 @@ package log
 
 import (
 	"context"
+	"fmt"
 	l "log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/drycc/logger/storage"
@@ -15,96 +18,303 @@ type valkeyAggregator struct {
 	listening bool
 	cfg       *config
 	ctx       context.Context
-	handle    func(map[string]interface{})
+	handle    func(map[string]interface{}) error
 	cancel    context.CancelFunc
+	consumer  string
+	state     atomic.Int32
+	done      chan struct{}
+	err       error
+	// syncCtx bounds storing and acknowledging the entries processed, which go on once the
+	// aggregator is stopping until cancelSync is called as the stop timed out
+	syncCtx    context.Context
+	cancelSync context.CancelFunc
+	// sync waits for the messages handled to be stored, before their entries are acknowledged
+	sync func(context.Context) error
+	// clientOption is parsed from the valkey URL once the aggregator listens
+	clientOption valkey.ClientOption
+	// adminClient serves the dead-letter requests, it is connected on the first one
+	adminMu     sync.Mutex
+	adminClient valkey.Client
 }
 
 func newValkeyAggregator(storageAdapter storage.Adapter) Aggregator {
-	context, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
 	return &valkeyAggregator{
-		handle: func(message map[string]interface{}) {
-			err := handle([]byte(message["data"].(string)), storageAdapter)
-			if err != nil {
-				l.Printf("handle message error: %v, %v", err, message)
+		handle: func(message map[string]interface{}) error {
+			data, ok := message["data"].(string)
+			if !ok {
+				return observeHandle("valkey", errMissingData)
 			}
+			return observeHandle("valkey", handle([]byte(data), storageAdapter))
 		},
-		ctx:    context,
+		sync: func(ctx context.Context) error {
+			return storage.Sync(ctx, storageAdapter)
+		},
+		ctx:    ctx,
 		cancel: cancel,
+		done:   make(chan struct{}),
 	}
 }
 
 func (a *valkeyAggregator) messageMainLoop() {
-	valkeyClient, _ := valkey.NewClient(valkey.MustParseURL(a.cfg.ValkeyURL))
-	valkeyCmdable := valkeycompat.NewAdapter(valkeyClient)
-	valkeyCmdable.XGroupCreateMkStream(a.ctx, a.cfg.ValkeyStream, a.cfg.ValkeyStreamGroup, "0")
+	defer close(a.done)
+	var valkeyClient valkey.Client
+	var valkeyCmdable valkeycompat.Cmdable
+	defer func() {
+		if valkeyClient != nil {
+			valkeyClient.Close()
+		}
+		a.closeAdminClient()
+	}()
+	// disconnect closes the client after an error and waits before reconnecting, it returns false
+	// once the aggregator is stopped or too many consecutive attempts failed
+	failures := 0
+	disconnect := func(err error) bool {
+		if a.ctx.Err() != nil {
+			return false
+		}
+		if valkeyClient != nil {
+			valkeyClient.Close()
+			valkeyClient = nil
+		}
+		failures++
+		a.setConnectionState(ConnectionStateDisconnected)
+		if a.cfg.ValkeyMaxFailures > 0 && failures >= a.cfg.ValkeyMaxFailures {
+			a.err = fmt.Errorf("valkey connection failed %d consecutive times: %w", failures, err)
+			a.cancel()
+			return false
+		}
+		backoff := storage.Backoff(failures, a.cfg.backoffBaseDuration(), a.cfg.backoffMaxDuration())
+		l.Printf("valkey connection error: %v, reconnecting in %s", err, backoff)
+		select {
+		case <-a.ctx.Done():
+			return false
+		case <-time.After(backoff):
+			return true
+		}
+	}
 
 	xReadGroupArgs := valkeycompat.XReadGroupArgs{
 		Group:    a.cfg.ValkeyStreamGroup,
-		Consumer: uuid.New().String(),
+		Consumer: a.consumer,
 		Streams:  []string{a.cfg.ValkeyStream, ">"},
 		Count:    30,
 		Block:    time.Duration(30) * time.Second,
 		NoAck:    false,
 	}
+	// entries delivered to a consumer that died before acknowledging them are reclaimed on start
+	// and periodically afterwards
+	var lastReclaim time.Time
 	for {
 		select {
 		case <-a.ctx.Done():
 			return
 		default:
-			entries, err := valkeyCmdable.XReadGroup(a.ctx, xReadGroupArgs).Result()
-			if err != nil {
-				valkeyClient.Close()
-				valkeyClient, _ = valkey.NewClient(valkey.MustParseURL(a.cfg.ValkeyURL))
+			if valkeyClient == nil {
+				a.setConnectionState(ConnectionStateConnecting)
+				var err error
+				if valkeyClient, err = valkey.NewClient(a.clientOption); err != nil {
+					if !disconnect(err) {
+						return
+					}
+					continue
+				}
 				valkeyCmdable = valkeycompat.NewAdapter(valkeyClient)
-			} else if len(entries) > 0 {
-				for i := 0; i < len(entries[0].Messages); i++ {
-					a.handle(entries[0].Messages[i].Values)
-					valkeyCmdable.XAck(a.ctx, a.cfg.ValkeyStream, a.cfg.ValkeyStreamGroup, entries[0].Messages[i].ID)
+				valkeyCmdable.XGroupCreateMkStream(a.ctx, a.cfg.ValkeyStream, a.cfg.ValkeyStreamGroup, "0")
+			}
+			if time.Since(lastReclaim) >= a.cfg.claimIntervalDuration() {
+				if err := a.reclaim(valkeyCmdable); err != nil {
+					valkeyErrorsTotal.WithLabelValues(operationReclaim).Inc()
+					l.Printf("reclaim pending entries error: %v", err)
+				}
+				if err := a.removeStaleConsumers(valkeyCmdable); err != nil {
+					l.Printf("remove stale consumers error: %v", err)
+				}
+				lastReclaim = time.Now()
+			}
+			entries, err := valkeyCmdable.XReadGroup(a.ctx, xReadGroupArgs).Result()
+			if err != nil && !valkey.IsValkeyNil(err) {
+				if !disconnect(err) {
+					return
 				}
-			} else {
-				l.Printf("no data was read from valkey xread group, %v, %v", err, entries)
-				time.Sleep(time.Duration(9) * time.Second)
+				continue
+			}
+			failures = 0
+			a.setConnectionState(ConnectionStateConnected)
+			if len(entries) > 0 {
+				a.process(valkeyCmdable, entries[0].Messages)
 			}
 		}
 	}
 }
 
+func (a *valkeyAggregator) setConnectionState(state ConnectionState) {
+	if old := ConnectionState(a.state.Swap(int32(state))); old != state {
+		l.Printf("valkey aggregator connection state changed from %s to %s", old, state)
+	}
+}
+
+// ConnectionState is the Connector interface implementation
+func (a *valkeyAggregator) ConnectionState() ConnectionState {
+	return ConnectionState(a.state.Load())
+}
+
+// process handles and acknowledges stream entries. Entries that fail to be handled are written to
+// the dead-letter stream, if that fails too they are left pending to be reclaimed later. The
+// entries handled are acknowledged once their messages are stored, or kept on disk to be stored,
+// else they are left pending too.
+func (a *valkeyAggregator) process(valkeyCmdable valkeycompat.Cmdable, messages []valkeycompat.XMessage) {
+	// the messages are stored and their entries dead-lettered and acked even once the aggregator is
+	// stopping, else the entries would be delivered again after a restart. Storing them may take
+	// as long as the storage pipeline retries, unless the stop times out, which leaves the entries
+	// pending to be reclaimed.
+	ctx, cancel := context.WithTimeout(a.syncCtx, a.cfg.syncTimeoutDuration())
+	defer cancel()
+	var handled, ids []string
+	for _, message := range messages {
+		// entries deleted from the stream while pending are claimed without values
+		if message.Values == nil {
+			ids = append(ids, message.ID)
+			continue
+		}
+		if err := a.handle(message.Values); err != nil {
+			l.Printf("handle message error: %v, %v", err, message.Values)
+			if a.cfg.ValkeyDeadLetterStream != "" {
+				if err := a.deadLetter(ctx, valkeyCmdable, message, err); err != nil {
+					valkeyErrorsTotal.WithLabelValues(operationDeadLetter).Inc()
+					l.Printf("dead-letter message %s error: %v", message.ID, err)
+					continue
+				}
+			}
+			ids = append(ids, message.ID)
+			continue
+		}
+		handled = append(handled, message.ID)
+	}
+	if len(handled) > 0 && a.sync != nil {
+		if err := a.sync(ctx); err != nil {
+			l.Printf("storage sync error, leaving %d entries pending: %v", len(handled), err)
+			handled = nil
+		}
+	}
+	if ids = append(ids, handled...); len(ids) > 0 {
+		if err := valkeyCmdable.XAck(ctx, a.cfg.ValkeyStream, a.cfg.ValkeyStreamGroup, ids...).Err(); err != nil {
+			valkeyErrorsTotal.WithLabelValues(operationAck).Inc()
+			l.Printf("ack %d entries error, leaving them pending: %v", len(ids), err)
+		}
+	}
+}
+
+// reclaim claims every entry of the group that has been pending for longer than the claim min-idle
+// time, whichever consumer it was delivered to, and processes it as this consumer.
+func (a *valkeyAggregator) reclaim(valkeyCmdable valkeycompat.Cmdable) error {
+	xAutoClaimArgs := valkeycompat.XAutoClaimArgs{
+		Stream:   a.cfg.ValkeyStream,
+		Group:    a.cfg.ValkeyStreamGroup,
+		Consumer: a.consumer,
+		MinIdle:  a.cfg.claimMinIdleDuration(),
+		Start:    "0-0",
+		Count:    30,
+	}
+	for a.ctx.Err() == nil {
+		messages, start, err := valkeyCmdable.XAutoClaim(a.ctx, xAutoClaimArgs).Result()
+		if err != nil {
+			return err
+		}
+		if len(messages) > 0 {
+			l.Printf("reclaimed %d pending entries from valkey stream %s", len(messages), a.cfg.ValkeyStream)
+			a.process(valkeyCmdable, messages)
+		}
+		// the scan of the pending entries list is complete once the cursor wraps around
+		if start == "0-0" || start == "" {
+			return nil
+		}
+		xAutoClaimArgs.Start = start
+	}
+	return nil
+}
+
+// removeStaleConsumers deletes the consumers of the group, other than this one, that have been
+// idle for longer than the consumer max-idle time. Consumers with pending entries are kept until
+// those entries are reclaimed, deleting them would drop the entries from the group.
+func (a *valkeyAggregator) removeStaleConsumers(valkeyCmdable valkeycompat.Cmdable) error {
+	consumers, err := valkeyCmdable.XInfoConsumers(a.ctx, a.cfg.ValkeyStream, a.cfg.ValkeyStreamGroup).Result()
+	if err != nil {
+		return err
+	}
+	for _, consumer := range consumers {
+		if consumer.Name == a.consumer || consumer.Pending > 0 || consumer.Idle < a.cfg.consumerMaxIdleDuration() {
+			continue
+		}
+		err := valkeyCmdable.XGroupDelConsumer(a.ctx, a.cfg.ValkeyStream, a.cfg.ValkeyStreamGroup, consumer.Name).Err()
+		if err != nil {
+			return err
+		}
+		l.Printf("removed stale consumer %s from valkey stream group %s", consumer.Name, a.cfg.ValkeyStreamGroup)
+	}
+	return nil
+}
+
 // Listen starts the aggregator. Invocations of this function are not concurrency safe and multiple
-// serialized invocations have no effect.
+// serialized invocations have no effect once one succeeded.
 func (a *valkeyAggregator) Listen() error {
 	// Should only ever be called once
-	if !a.listening {
-		a.listening = true
+	if a.listening {
+		return nil
+	}
+	if a.cfg == nil {
 		var err error
 		a.cfg, err = parseConfig(appName)
 		if err != nil {
 			l.Fatalf("config error: %s: ", err)
 		}
-		go a.messageMainLoop()
 	}
+	clientOption, err := valkey.ParseURL(a.cfg.ValkeyURL)
+	if err != nil {
+		return fmt.Errorf("invalid valkey URL: %v", err)
+	}
+	a.clientOption = clientOption
+	if a.consumer == "" {
+		a.consumer = uuid.New().String()
+	}
+	if a.done == nil {
+		a.done = make(chan struct{})
+	}
+	if a.syncCtx == nil {
+		a.syncCtx, a.cancelSync = context.WithCancel(context.WithoutCancel(a.ctx))
+	}
+	a.listening = true
+	go a.messageMainLoop()
 	return nil
 }
 
-// Stop is the Aggregator interface implementation
+// Stop is the Aggregator interface implementation. The entries being processed are stored and
+// acknowledged within the stop timeout, else their storage is cancelled and they are left pending
+// to be reclaimed.
 func (a *valkeyAggregator) Stop() error {
 	a.cancel()
+	if !a.listening {
+		return nil
+	}
 	timeout := a.cfg.stopTimeoutDuration()
 	tmr := time.NewTimer(timeout)
 	defer tmr.Stop()
 	select {
 	case <-tmr.C:
+		a.cancelSync()
 		return newErrStopTimedOut(timeout)
-	case <-a.ctx.Done():
+	case <-a.done:
 		return nil
 	}
 }
 
-// Stopped is the Aggregator interface implementation
+// Stopped is the Aggregator interface implementation. It receives a non-nil error when the
+// aggregator gave up reconnecting to Valkey.
 func (a *valkeyAggregator) Stopped() <-chan error {
-	retCh := make(chan error)
+	retCh := make(chan error, 1)
 	go func() {
-		<-a.ctx.Done()
-		retCh <- nil
+		<-a.done
+		retCh <- a.err
 	}()
 	return retCh
 }
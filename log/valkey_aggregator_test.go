@@ -2,7 +2,9 @@ package log
 
 import (
 	"context"
+	"errors"
 	l "log"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -13,7 +15,7 @@ import (
 )
 
 func TestAggregator(t *testing.T) {
-	storageAdapter, err := storage.NewAdapter("file", 100)
+	storageAdapter, err := storage.NewAdapter("memory", 100)
 	assert.NoError(t, err)
 	aggregator, err := NewAggregator("valkey", storageAdapter)
 	assert.NoError(t, err)
@@ -54,8 +56,9 @@ func TestAggregatorMessageMainLoop(t *testing.T) {
 	generateTestData(ctx, messageCount, message)
 	msg := make(chan map[string]interface{}, messageCount)
 	aggregator := valkeyAggregator{
-		handle: func(message map[string]interface{}) {
+		handle: func(message map[string]interface{}) error {
 			msg <- message
+			return nil
 		},
 		ctx:    ctx,
 		cancel: cancel,
@@ -72,3 +75,238 @@ func TestAggregatorMessageMainLoop(t *testing.T) {
 	err := aggregator.Stop()
 	assert.NoError(t, err)
 }
+
+func TestValkeyAggregatorReclaimPending(t *testing.T) {
+	cfg, err := parseConfig(appName)
+	assert.NoError(t, err)
+	cfg.ValkeyStream = "logs-reclaim-" + time.Now().Format("150405.000")
+	cfg.ValkeyClaimMinIdleSeconds = 0
+	cfg.ValkeyConsumerMaxIdleSeconds = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	valkeyClient, err := valkey.NewClient(valkey.MustParseURL(cfg.ValkeyURL))
+	assert.NoError(t, err)
+	defer valkeyClient.Close()
+	adapter := valkeycompat.NewAdapter(valkeyClient)
+	defer adapter.Del(context.Background(), cfg.ValkeyStream)
+	assert.NoError(t, adapter.XGroupCreateMkStream(ctx, cfg.ValkeyStream, cfg.ValkeyStreamGroup, "0").Err())
+
+	message := map[string]interface{}{"data": "Hello world"}
+	messageCount := 5
+	for i := 0; i < messageCount; i++ {
+		assert.NoError(t, adapter.XAdd(ctx, valkeycompat.XAddArgs{Stream: cfg.ValkeyStream, ID: "*", Values: message}).Err())
+	}
+	// a consumer that dies after reading without acknowledging
+	entries, err := adapter.XReadGroup(ctx, valkeycompat.XReadGroupArgs{
+		Group:    cfg.ValkeyStreamGroup,
+		Consumer: "dead",
+		Streams:  []string{cfg.ValkeyStream, ">"},
+		Count:    int64(messageCount),
+	}).Result()
+	assert.NoError(t, err)
+	assert.Len(t, entries[0].Messages, messageCount)
+
+	msg := make(chan map[string]interface{}, messageCount)
+	aggregator := valkeyAggregator{
+		cfg: cfg,
+		handle: func(message map[string]interface{}) error {
+			msg <- message
+			return nil
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	aggregator.Listen()
+	for i := 0; i < messageCount; i++ {
+		select {
+		case expect := <-msg:
+			assert.Equal(t, message, expect)
+		case <-time.After(time.Second * 10):
+			t.Fatal("reclaim timeout")
+		}
+	}
+	assert.Eventually(t, func() bool {
+		consumers, err := adapter.XInfoConsumers(context.Background(), cfg.ValkeyStream, cfg.ValkeyStreamGroup).Result()
+		if err != nil {
+			return false
+		}
+		for _, consumer := range consumers {
+			if consumer.Name == "dead" {
+				return false
+			}
+		}
+		return true
+	}, 10*time.Second, 100*time.Millisecond, "stale consumer was not removed")
+	assert.NoError(t, aggregator.Stop())
+}
+
+func TestValkeyAggregatorDeadLetter(t *testing.T) {
+	cfg, err := parseConfig(appName)
+	assert.NoError(t, err)
+	cfg.ValkeyStream = "logs-dead-letter-test-" + time.Now().Format("150405.000")
+	cfg.ValkeyDeadLetterStream = cfg.ValkeyStream + "-dead-letter"
+	ctx, cancel := context.WithCancel(context.Background())
+	valkeyClient, err := valkey.NewClient(valkey.MustParseURL(cfg.ValkeyURL))
+	assert.NoError(t, err)
+	defer valkeyClient.Close()
+	adapter := valkeycompat.NewAdapter(valkeyClient)
+	defer adapter.Del(context.Background(), cfg.ValkeyStream, cfg.ValkeyDeadLetterStream)
+
+	id, err := adapter.XAdd(ctx, valkeycompat.XAddArgs{
+		Stream: cfg.ValkeyStream,
+		ID:     "*",
+		Values: map[string]interface{}{"data": "not json"},
+	}).Result()
+	assert.NoError(t, err)
+
+	handled := make(chan map[string]interface{}, 1)
+	aggregator := valkeyAggregator{
+		cfg: cfg,
+		handle: func(message map[string]interface{}) error {
+			if message["retries"] == nil {
+				return errors.New("invalid message")
+			}
+			handled <- message
+			return nil
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	aggregator.Listen()
+	defer aggregator.Stop()
+
+	var deadLetters []DeadLetter
+	assert.Eventually(t, func() bool {
+		deadLetters, err = aggregator.DeadLetters(context.Background(), "-", 10)
+		return err == nil && len(deadLetters) == 1
+	}, 10*time.Second, 100*time.Millisecond, "message was not dead-lettered")
+	assert.Equal(t, id, deadLetters[0].OriginalID)
+	assert.Equal(t, "invalid message", deadLetters[0].Reason)
+	assert.Equal(t, "not json", deadLetters[0].Data)
+	assert.Equal(t, 0, deadLetters[0].Retries)
+
+	replayed, err := aggregator.Replay(context.Background(), deadLetters[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+	select {
+	case message := <-handled:
+		assert.Equal(t, map[string]interface{}{"data": "not json", "retries": "1"}, message)
+	case <-time.After(time.Second * 40):
+		t.Error("replayed message was not handled")
+	}
+	_, err = aggregator.Replay(context.Background(), deadLetters[0].ID)
+	assert.ErrorIs(t, err, ErrDeadLetterNotFound)
+}
+
+func TestValkeyAggregatorSyncBeforeAck(t *testing.T) {
+	cfg, err := parseConfig(appName)
+	assert.NoError(t, err)
+	cfg.ValkeyStream = "logs-sync-test-" + time.Now().Format("150405.000")
+	cfg.ValkeyClaimIntervalSeconds = 1
+	cfg.ValkeyClaimMinIdleSeconds = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	valkeyClient, err := valkey.NewClient(valkey.MustParseURL(cfg.ValkeyURL))
+	assert.NoError(t, err)
+	defer valkeyClient.Close()
+	adapter := valkeycompat.NewAdapter(valkeyClient)
+	defer adapter.Del(context.Background(), cfg.ValkeyStream)
+	assert.NoError(t, adapter.XGroupCreateMkStream(ctx, cfg.ValkeyStream, cfg.ValkeyStreamGroup, "0").Err())
+	assert.NoError(t, adapter.XAdd(ctx, valkeycompat.XAddArgs{
+		Stream: cfg.ValkeyStream,
+		ID:     "*",
+		Values: map[string]interface{}{"data": "Hello world"},
+	}).Err())
+
+	var synced atomic.Bool
+	aggregator := valkeyAggregator{
+		cfg:    cfg,
+		handle: func(map[string]interface{}) error { return nil },
+		sync: func(context.Context) error {
+			if !synced.Load() {
+				return errors.New("valkey unavailable")
+			}
+			return nil
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	aggregator.Listen()
+	defer aggregator.Stop()
+
+	pending := func() int64 {
+		consumers, err := adapter.XInfoConsumers(context.Background(), cfg.ValkeyStream, cfg.ValkeyStreamGroup).Result()
+		if err != nil {
+			return -1
+		}
+		var count int64
+		for _, consumer := range consumers {
+			count += consumer.Pending
+		}
+		return count
+	}
+	// the entry is left pending while its message is not stored
+	assert.Eventually(t, func() bool { return pending() == 1 }, 10*time.Second, 100*time.Millisecond)
+	time.Sleep(time.Second)
+	assert.Equal(t, int64(1), pending())
+	// and acknowledged once it is reclaimed and stored
+	synced.Store(true)
+	assert.Eventually(t, func() bool { return pending() == 0 }, 40*time.Second, 100*time.Millisecond)
+}
+
+func TestAggregatorStopsAfterMaxFailures(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	aggregator := valkeyAggregator{
+		cfg: &config{
+			// nothing listens on port 1
+			ValkeyURL:               "redis://127.0.0.1:1",
+			ValkeyBackoffBaseMillis: 1,
+			ValkeyBackoffMaxSeconds: 1,
+			ValkeyMaxFailures:       3,
+			StopTimeoutSeconds:      1,
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	assert.NoError(t, aggregator.Listen())
+	select {
+	case err := <-aggregator.Stopped():
+		assert.ErrorContains(t, err, "valkey connection failed 3 consecutive times")
+	case <-time.After(10 * time.Second):
+		t.Fatal("aggregator did not stop")
+	}
+	assert.Equal(t, ConnectionStateDisconnected, aggregator.ConnectionState())
+	assert.NoError(t, aggregator.Stop())
+}
+
+func TestAggregatorListenAfterError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	aggregator := valkeyAggregator{
+		cfg:    &config{ValkeyURL: "bogus://127.0.0.1:1", StopTimeoutSeconds: 1},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	assert.Error(t, aggregator.Listen())
+	assert.False(t, aggregator.listening)
+	// a failed Listen is retried
+	aggregator.cfg.ValkeyURL = "redis://127.0.0.1:1"
+	aggregator.cfg.ValkeyBackoffBaseMillis = 1
+	aggregator.cfg.ValkeyBackoffMaxSeconds = 1
+	aggregator.cfg.ValkeyMaxFailures = 1
+	assert.NoError(t, aggregator.Listen())
+	assert.True(t, aggregator.listening)
+	assert.NoError(t, aggregator.Stop())
+}
+
+func TestAggregatorStopCancelsSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	aggregator := valkeyAggregator{
+		cfg:       &config{StopTimeoutSeconds: 1, SyncTimeoutSeconds: 60},
+		ctx:       ctx,
+		cancel:    cancel,
+		listening: true,
+		// entries still being processed
+		done: make(chan struct{}),
+	}
+	aggregator.syncCtx, aggregator.cancelSync = context.WithCancel(context.WithoutCancel(ctx))
+	assert.Error(t, aggregator.Stop())
+	assert.Error(t, aggregator.syncCtx.Err(), "the entries being stored are left pending once the stop times out")
+}
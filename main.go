@@ -1,8 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	l "log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	_ "net/http/pprof"
 
@@ -17,12 +24,26 @@ func main() {
 		l.Fatalf("config error: %s: ", err)
 	}
 
+	if cfg.RouterConfig != "" {
+		router, err := log.LoadRouter(cfg.RouterConfig)
+		if err != nil {
+			l.Fatal("Error loading router config: ", err)
+		}
+		log.SetRouter(router)
+	}
+	if cfg.LimitsConfig != "" {
+		limits, err := weblog.LoadLimits(cfg.LimitsConfig)
+		if err != nil {
+			l.Fatal("Error loading weblog limits: ", err)
+		}
+		weblog.SetLimits(limits)
+	}
+
 	storageAdapter, err := storage.NewAdapter(cfg.StorageType, cfg.NumLines)
 	if err != nil {
 		l.Fatal("Error creating storage adapter: ", err)
 	}
 	storageAdapter.Start()
-	defer storageAdapter.Stop()
 
 	aggregator, err := log.NewAggregator(cfg.AggregatorType, storageAdapter)
 	if err != nil {
@@ -32,12 +53,16 @@ func main() {
 	if err != nil {
 		l.Fatal("Error starting log aggregator: ", err)
 	}
-	defer aggregator.Stop()
 	l.Println("Log aggregator running")
 
-	weblogServer := weblog.NewServer(storageAdapter)
+	var authenticator weblog.Authenticator
+	if cfg.AuthConfig != "" {
+		if authenticator, err = weblog.LoadAuthenticator(cfg.AuthConfig); err != nil {
+			l.Fatal("Error loading weblog auth config: ", err)
+		}
+	}
+	weblogServer := weblog.NewServer(storageAdapter, aggregator, authenticator)
 	weblogServer.Start()
-	defer weblogServer.Close()
 	l.Printf("Weblog server serving at %s\n", weblogServer.URL)
 
 	// start a Go Profiler
@@ -45,11 +70,92 @@ func main() {
 		l.Println(http.ListenAndServe("0.0.0.0:8099", nil))
 	}()
 
-	stoppedCh := aggregator.Stopped()
-	stopErr := <-stoppedCh
-	if err != nil {
-		l.Fatal("Log aggregator has stopped: ", stopErr)
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			reload(cfg, storageAdapter, weblogServer)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	exitCode := 0
+	select {
+	case sig := <-signals:
+		l.Printf("Received %s, shutting down", sig)
+	case stopErr := <-aggregator.Stopped():
+		if stopErr != nil {
+			l.Println("Log aggregator has stopped: ", stopErr)
+		} else {
+			l.Println("Log aggregator has stopped with no error")
+		}
+		exitCode = 1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeoutSeconds)*time.Second)
+	if err := shutdown(ctx, weblogServer, aggregator, storageAdapter); err != nil {
+		l.Println("Error shutting down: ", err)
+		exitCode = 1
+	}
+	cancel()
+	os.Exit(exitCode)
+}
+
+// reload reopens the files of the storage adapter, for them to be rotated by an external tool
+// such as logrotate, and reloads the routing rules, weblog limits and weblog auth config. A config
+// failing to load is logged and the previous one is kept. The follows in flight are not affected.
+func reload(cfg *config, storageAdapter storage.Adapter, weblogServer *weblog.Server) {
+	l.Println("Received SIGHUP, reloading")
+	if err := storageAdapter.Reopen(); err != nil {
+		l.Println("Error reopening storage adapter: ", err)
+	}
+	if cfg.RouterConfig != "" {
+		if router, err := log.LoadRouter(cfg.RouterConfig); err != nil {
+			l.Println("Error reloading router config: ", err)
+		} else {
+			log.SetRouter(router)
+		}
+	}
+	if cfg.LimitsConfig != "" {
+		if limits, err := weblog.LoadLimits(cfg.LimitsConfig); err != nil {
+			l.Println("Error reloading weblog limits: ", err)
+		} else {
+			weblog.SetLimits(limits)
+		}
 	} else {
-		l.Fatal("Log aggregator has stopped with no error")
+		// the environment of a process is fixed once it started, so the limits it sets only change
+		// on restart
+		l.Println("WEBLOG_LIMITS_FILE is not set, keeping the weblog limits of the environment")
+	}
+	if cfg.AuthConfig != "" {
+		if authenticator, err := weblog.LoadAuthenticator(cfg.AuthConfig); err != nil {
+			l.Println("Error reloading weblog auth config: ", err)
+		} else {
+			weblogServer.SetAuthenticator(authenticator)
+		}
+	}
+}
+
+// shutdown stops serving requests, ending the follow streams, then drains the aggregator into the
+// storage adapter and flushes its pending writes, until ctx is done. Every step is taken even when
+// the previous one failed, so that as few logs as possible are lost.
+func shutdown(ctx context.Context, weblogServer *weblog.Server, aggregator log.Aggregator, storageAdapter storage.Adapter) error {
+	var errs []error
+	if err := weblogServer.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("weblog server: %w", err))
+	}
+	if err := aggregator.Stop(); err != nil {
+		errs = append(errs, fmt.Errorf("log aggregator: %w", err))
+	}
+	stopped := make(chan struct{})
+	go func() {
+		storageAdapter.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("storage adapter: %w", ctx.Err()))
 	}
+	return errors.Join(errs...)
 }
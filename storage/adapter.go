@@ -12,3 +12,18 @@ type Adapter interface {
 	Reopen() error
 	Stop()
 }
+
+// Syncer is implemented by the adapters which store the messages written asynchronously
+type Syncer interface {
+	// Sync waits for the messages written before to be stored, or kept on disk to be stored
+	Sync(context.Context) error
+}
+
+// Sync waits for the messages written to an adapter before to be stored, it returns right away
+// for the adapters which store messages as they are written
+func Sync(ctx context.Context, adapter Adapter) error {
+	if syncer, ok := adapter.(Syncer); ok {
+		return syncer.Sync(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff returns the delay before the nth attempt to retry or reconnect, counted from 1, doubling
+// from base up to max. Attempts before the first are delayed as the first. The delay is jittered
+// over its upper half so that replicas failing at the same time do not retry in lockstep.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	backoff := max
+	if attempt < 32 {
+		if d := base << (attempt - 1); d > 0 && d < max {
+			backoff = d
+		}
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	base, max := 100*time.Millisecond, 3*time.Second
+	for attempt, expected := range map[int]time.Duration{
+		1:  base,
+		2:  2 * base,
+		4:  8 * base,
+		6:  max,
+		64: max,
+	} {
+		for i := 0; i < 10; i++ {
+			backoff := Backoff(attempt, base, max)
+			assert.GreaterOrEqual(t, backoff, expected/2, "attempt %d", attempt)
+			assert.LessOrEqual(t, backoff, expected, "attempt %d", attempt)
+		}
+	}
+	assert.Zero(t, Backoff(1, 0, 0))
+	// attempts before the first are delayed as the first
+	for _, attempt := range []int{0, -1} {
+		backoff := Backoff(attempt, base, max)
+		assert.GreaterOrEqual(t, backoff, base/2, "attempt %d", attempt)
+		assert.LessOrEqual(t, backoff, base, "attempt %d", attempt)
+	}
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrInvalidCursor is returned for a cursor that was not returned by the adapter
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrCursorsUnsupported is returned when resuming from a cursor with an adapter that does not
+	// implement CursorAdapter
+	ErrCursorsUnsupported = errors.New("storage adapter does not support cursors")
+)
+
+// Entry is a log line along with the cursor of its position in the logs of an app. Cursors are
+// opaque and only meaningful to the adapter that returned them.
+type Entry struct {
+	Cursor   string
+	Line     string
+	Metadata Metadata
+}
+
+// CursorAdapter is implemented by adapters able to resume reading the logs of an app right after a
+// line they returned, so that followers reconnect without gaps or repeats. Lines trimmed by the
+// retention of the adapter are skipped.
+type CursorAdapter interface {
+	// ReadEntries returns the last lines entries of an app, oldest first.
+	ReadEntries(app string, lines int) ([]Entry, error)
+	// ReadSince returns the first lines entries of an app written after the entry at cursor.
+	ReadSince(app string, cursor string, lines int) ([]Entry, error)
+	// FollowSince sends the entries of an app written after the entry at cursor, or from now on when
+	// cursor is empty, to a channel that is closed once the context is done or the channel is full.
+	FollowSince(ctx context.Context, app string, cursor string, size int) (chan Entry, error)
+}
+
+// ReadEntries returns the last lines entries of an app from an adapter. The entries of adapters
+// that do not implement CursorAdapter have no cursor.
+func ReadEntries(a Adapter, app string, lines int) ([]Entry, error) {
+	if c, ok := a.(CursorAdapter); ok {
+		return c.ReadEntries(app, lines)
+	}
+	logs, err := a.Read(app, lines)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(logs))
+	for i, line := range logs {
+		entries[i] = newEntry("", line)
+	}
+	return entries, nil
+}
+
+// ReadSince returns the first lines entries of an app written after the entry at cursor from an
+// adapter, which must implement CursorAdapter.
+func ReadSince(a Adapter, app string, cursor string, lines int) ([]Entry, error) {
+	if c, ok := a.(CursorAdapter); ok {
+		return c.ReadSince(app, cursor, lines)
+	}
+	return nil, ErrCursorsUnsupported
+}
+
+// FollowSince sends the entries of an app written after the entry at cursor from an adapter. Only
+// adapters implementing CursorAdapter can follow from a cursor, the others are followed from now on
+// when cursor is empty.
+func FollowSince(ctx context.Context, a Adapter, app string, cursor string, size int) (chan Entry, error) {
+	if c, ok := a.(CursorAdapter); ok {
+		return c.FollowSince(ctx, app, cursor, size)
+	}
+	if cursor != "" {
+		return nil, ErrCursorsUnsupported
+	}
+	channel, err := a.Chan(ctx, app, size)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(chan Entry, size)
+	go func() {
+		defer close(entries)
+		for line := range channel {
+			select {
+			case entries <- newEntry("", line):
+			case <-ctx.Done():
+				return
+			}
+			if len(entries) == cap(entries) {
+				return
+			}
+		}
+	}()
+	return entries, nil
+}
+
+// linesOf returns the lines of entries
+func linesOf(entries []Entry) []string {
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = entry.Line
+	}
+	return lines
+}
+
+// entryLines returns a channel receiving the lines of the entries sent to a channel, for adapters
+// implementing Chan on top of FollowSince. Like the entries channel, it is closed once the context
+// is done or it is full.
+func entryLines(ctx context.Context, entries chan Entry) chan string {
+	lines := make(chan string, cap(entries))
+	go func() {
+		defer close(lines)
+		for entry := range entries {
+			select {
+			case lines <- entry.Line:
+			case <-ctx.Done():
+				return
+			}
+			if len(lines) == cap(lines) {
+				return
+			}
+		}
+	}()
+	return lines
+}
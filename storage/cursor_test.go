@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// linesAdapter hides the CursorAdapter implementation of an adapter
+type linesAdapter struct {
+	Adapter
+}
+
+func TestCursorFallback(t *testing.T) {
+	memory, err := NewMemoryAdapter(10)
+	assert.NoError(t, err)
+	a := linesAdapter{memory}
+	assert.NoError(t, a.Write(app, "message 0"))
+
+	entries, err := ReadEntries(a, app, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{{Line: "message 0"}}, entries)
+	_, err = ReadSince(a, app, "1", 10)
+	assert.True(t, errors.Is(err, ErrCursorsUnsupported))
+	_, err = FollowSince(context.Background(), a, app, "1", 10)
+	assert.True(t, errors.Is(err, ErrCursorsUnsupported))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	channel, err := FollowSince(ctx, a, app, "", 10)
+	assert.NoError(t, err)
+	assert.NoError(t, a.Write(app, "message 1"))
+	select {
+	case entry := <-channel:
+		assert.Equal(t, Entry{Line: "message 1"}, entry)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an entry")
+	}
+	cancel()
+	for range channel {
+	}
+}
@@ -20,14 +20,28 @@ func NewAdapter(adapterType string, numLines int) (Adapter, error) {
 		if err != nil {
 			return nil, err
 		}
-		return adapter, nil
+		return newInstrumentedAdapter(adapterType, adapter), nil
 	}
 	if adapterType == "valkey" {
 		adapter, err := NewValkeyStorageAdapter(numLines)
 		if err != nil {
 			return nil, err
 		}
-		return adapter, nil
+		return newInstrumentedAdapter(adapterType, adapter), nil
+	}
+	if adapterType == "valkey-stream" {
+		adapter, err := NewValkeyStreamStorageAdapter(numLines)
+		if err != nil {
+			return nil, err
+		}
+		return newInstrumentedAdapter(adapterType, adapter), nil
+	}
+	if adapterType == "memory" {
+		adapter, err := NewMemoryAdapter(numLines)
+		if err != nil {
+			return nil, err
+		}
+		return newInstrumentedAdapter(adapterType, adapter), nil
 	}
 	return nil, errUnrecognizedStorageAdapterType{adapterType: adapterType}
 }
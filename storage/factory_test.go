@@ -29,19 +29,41 @@ func TestFactoryGetFileBasedAdapter(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	retType, ok := a.(*fileAdapter)
+	retType, ok := a.(*instrumentedAdapter).Adapter.(*fileAdapter)
 	if !ok {
 		t.Fatalf("expected a *fileAdapter, got %s", reflect.TypeOf(retType).String())
 	}
 }
 
+func TestFactoryGetMemoryBasedAdapter(t *testing.T) {
+	a, err := NewAdapter("memory", 1)
+	if err != nil {
+		t.Error(err)
+	}
+	retType, ok := a.(*instrumentedAdapter).Adapter.(*memoryAdapter)
+	if !ok {
+		t.Fatalf("expected a *memoryAdapter, got %s", reflect.TypeOf(retType).String())
+	}
+}
+
 func TestGetValkeyBasedAdapter(t *testing.T) {
 	a, err := NewAdapter("valkey", 1)
 	if err != nil {
 		t.Error(err)
 	}
-	retType, ok := a.(*valkeyAdapter)
+	retType, ok := a.(*instrumentedAdapter).Adapter.(*valkeyAdapter)
 	if !ok {
 		t.Errorf("expected a valkeyAdapter, but got a %s", reflect.TypeOf(retType).String())
 	}
 }
+
+func TestGetValkeyStreamBasedAdapter(t *testing.T) {
+	a, err := NewAdapter("valkey-stream", 1)
+	if err != nil {
+		t.Error(err)
+	}
+	retType, ok := a.(*instrumentedAdapter).Adapter.(*valkeyStreamAdapter)
+	if !ok {
+		t.Errorf("expected a valkeyStreamAdapter, but got a %s", reflect.TypeOf(retType).String())
+	}
+}
@@ -1,135 +1,338 @@
 package storage
 
 import (
-	"bufio"
 	"context"
 	"fmt"
+	"io"
+	"log"
 	"os"
-	"os/exec"
 	"path"
-	"strconv"
-	"strings"
+	"slices"
 	"sync"
+	"time"
 )
 
 var LogRoot = "/data/logs"
 
+// logFile is the current segment of the logs of an app
+type logFile struct {
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
 type fileAdapter struct {
-	files map[string]*os.File
-	mutex sync.Mutex
+	files  map[string]*logFile
+	mutex  sync.RWMutex
+	config *fileConfig
+	// wg tracks the compression and retention of rotated segments running in the background
+	wg sync.WaitGroup
+	// retention serializes retention runs, which may delete the segments of every app, and the
+	// compression of rotated segments, so that a segment is not deleted while it is compressed
+	retention sync.Mutex
+	started   bool
+	stopCh    chan struct{}
 }
 
 // NewFileAdapter returns an Adapter that uses a file.
 func NewFileAdapter() (Adapter, error) {
-	return &fileAdapter{files: make(map[string]*os.File)}, nil
+	cfg, err := parseFileConfig(appName)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAdapter{files: make(map[string]*logFile), config: cfg}, nil
 }
 
-// Start the storage adapter-- in the case of this implementation, a no-op
+// Start the storage adapter, applying retention to every app periodically. Invocations of this
+// function are not concurrency safe and multiple serialized invocations have no effect.
 func (a *fileAdapter) Start() {
+	if a.started || a.config.RetentionInterval <= 0 {
+		return
+	}
+	a.started = true
+	a.stopCh = make(chan struct{})
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ticker := time.NewTicker(a.config.RetentionInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.stopCh:
+				return
+			case <-ticker.C:
+				if err := a.applyRetentionAll(); err != nil {
+					log.Printf("log retention error: %v", err)
+				}
+			}
+		}
+	}()
 }
 
-// Write adds a log message to to an app-specific log file
+// Write adds a log message to to an app-specific log file, rotating the file first when it reached
+// the configured size or age. The metadata of the message is stored at the end of its line, as by
+// the other adapters, so that lines can be filtered by it.
 func (a *fileAdapter) Write(app string, message string) error {
-	// Check first if we might actually have to add to the map of file pointers so we can avoid
-	// waiting for / obtaining a lock unnecessarily
-	f, ok := a.files[app]
-	if !ok {
-		// Ensure only one goroutine at a time can be adding a file pointer to the map of file
-		// pointers
-		a.mutex.Lock()
-		defer a.mutex.Unlock()
-		f, ok = a.files[app]
-		if !ok {
-			var err error
-			f, err = a.getFile(app)
-			if err != nil {
+	line := message + "\n"
+	for {
+		f, err := a.getLogFile(app)
+		if err != nil {
+			return err
+		}
+		f.mutex.Lock()
+		// closed by Destroy or Reopen since it was looked up
+		if f.file == nil {
+			f.mutex.Unlock()
+			continue
+		}
+		if a.shouldRotate(f, len(line)) {
+			if err := a.rotate(app, f); err != nil {
+				f.mutex.Unlock()
 				return err
 			}
-			a.files[app] = f
 		}
-	}
-	if _, err := f.WriteString(message + "\n"); err != nil {
+		n, err := f.file.WriteString(line)
+		f.size += int64(n)
+		f.mutex.Unlock()
 		return err
 	}
-	return nil
 }
 
 // Read retrieves a specified number of log lines from an app-specific log file
 func (a *fileAdapter) Read(app string, lines int) ([]string, error) {
+	entries, err := a.ReadEntries(app, lines)
+	if err != nil {
+		return nil, err
+	}
+	return linesOf(entries), nil
+}
+
+// ReadEntries is the CursorAdapter interface implementation, reading the current and rotated
+// segments of an app
+func (a *fileAdapter) ReadEntries(app string, lines int) ([]Entry, error) {
 	if lines <= 0 {
-		return []string{}, nil
+		return []Entry{}, nil
 	}
 	filePath := a.getFilePath(app)
 	exists, err := fileExists(filePath)
 	if err != nil {
 		return nil, err
 	}
-	if !exists {
+	segments, err := a.segments(app)
+	if err != nil {
+		return nil, err
+	}
+	if !exists && len(segments) == 0 {
 		return nil, fmt.Errorf("could not find logs for '%s'", app)
 	}
-	logBytes, err := exec.Command("tail", "-n", strconv.Itoa(lines), filePath).Output()
+	var entries []Entry
+	if exists {
+		if entries, err = readLastEntries(filePath, lines); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	// complete the current segment with the rotated ones, newest first
+	for _, segment := range segments {
+		if len(entries) >= lines {
+			break
+		}
+		segmentEntries, err := segment.readLastEntries(lines - len(entries))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(segmentEntries, entries...)
+	}
+	if entries == nil {
+		entries = []Entry{}
+	}
+	return entries, nil
+}
+
+// ReadSince is the CursorAdapter interface implementation. When the segment of the cursor was
+// deleted by retention, the entries are read from the oldest segment left.
+func (a *fileAdapter) ReadSince(app string, cursor string, lines int) ([]Entry, error) {
+	c, err := parseFileCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if lines <= 0 {
+		return []Entry{}, nil
+	}
+	segments, err := a.allSegments(app)
 	if err != nil {
 		return nil, err
 	}
-	logStrs := strings.Split(string(logBytes), "\n")
-	return logStrs[:len(logStrs)-1], nil
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("could not find logs for '%s'", app)
+	}
+	return readSegmentsSince(segments, c, lines)
+}
+
+// readSegmentsSince returns the first lines entries after a cursor from segments sorted oldest
+// first. The entries are read from the first segment when none is the one of the cursor.
+func readSegmentsSince(segments []segment, c fileCursor, lines int) ([]Entry, error) {
+	start, offset := 0, int64(0)
+	for i, segment := range segments {
+		if segment.inode == c.inode {
+			start, offset = i, c.offset
+			break
+		}
+	}
+	entries := []Entry{}
+	for _, segment := range segments[start:] {
+		if len(entries) >= lines {
+			break
+		}
+		// segments deleted by retention since they were listed are skipped
+		segmentEntries, err := segment.readFrom(offset, lines-len(entries))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		entries = append(entries, segmentEntries...)
+		offset = 0
+	}
+	return entries, nil
+}
+
+// Query is the QueryAdapter interface implementation. Rotated segments are skipped when rotated
+// before the start of the range, as their lines were all written before it.
+func (a *fileAdapter) Query(app string, query Query, lines int) ([]Entry, string, error) {
+	segments, err := a.allSegments(app)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(segments) == 0 {
+		return nil, "", fmt.Errorf("could not find logs for '%s'", app)
+	}
+	filter := newQueryFilter(query, lines)
+	for _, segment := range segments {
+		// the current segment has no rotation time
+		if segment.rotated != 0 && !query.Since.IsZero() && segment.rotated < query.Since.UnixNano() {
+			continue
+		}
+		done := false
+		err := segment.scan(func(entry Entry) bool {
+			done = !filter.add(entry)
+			return !done
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, "", err
+		}
+		if done {
+			break
+		}
+	}
+	return filter.entries(), filter.cursor, nil
+}
+
+// allSegments returns the rotated segments of an app, oldest first, followed by the current one
+func (a *fileAdapter) allSegments(app string) ([]segment, error) {
+	segments, err := a.segments(app)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(segments)
+	info, err := os.Stat(a.getFilePath(app))
+	if os.IsNotExist(err) {
+		return segments, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(segments, segment{path: a.getFilePath(app), inode: fileInode(info)}), nil
 }
 
 // Make Chan a pipeline to read logs all the time
 func (a *fileAdapter) Chan(ctx context.Context, app string, size int) (chan string, error) {
-	filePath := a.getFilePath(app)
-	exists, err := fileExists(filePath)
+	entries, err := a.FollowSince(ctx, app, "", size)
 	if err != nil {
 		return nil, err
 	}
-	if !exists {
+	return entryLines(ctx, entries), nil
+}
+
+// FollowSince is the CursorAdapter interface implementation. The current segment is followed from
+// the cursor, or from its beginning after sending the entries of the rotated segments when the
+// cursor is in a rotated segment.
+func (a *fileAdapter) FollowSince(ctx context.Context, app string, cursor string, size int) (chan Entry, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid channel size: %d", size)
+	}
+	var c fileCursor
+	if cursor != "" {
+		var err error
+		if c, err = parseFileCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+	filePath := a.getFilePath(app)
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
 		return nil, fmt.Errorf("could not find logs for '%s'", app)
 	}
-
-	channel := make(chan string, size)
-	go func() {
-		defer close(channel)
-		cmd := exec.Command("tail", "-n", "0", "-f", filePath)
-		out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	follower, err := newFileFollower(filePath, file, 0)
+	if err != nil {
+		return nil, err
+	}
+	channel := make(chan Entry, size)
+	switch {
+	case cursor == "":
+		if follower.offset, err = file.Seek(0, io.SeekEnd); err != nil {
+			file.Close()
+			return nil, err
+		}
+	case c.inode == follower.inode:
+		follower.offset = c.offset
+	default:
+		segments, err := a.segments(app)
 		if err != nil {
-			panic(err)
-		}
-		defer out.Close()
-		cmd.Start()
-		go func() {
-			<-ctx.Done()
-			cmd.Process.Kill()
-		}()
-		scanner := bufio.NewScanner(out)
-		scanner.Split(bufio.ScanLines)
-		for len(channel) != size && scanner.Scan() {
-			line := scanner.Text()
-			channel <- line
+			file.Close()
+			return nil, err
 		}
-	}()
+		slices.Reverse(segments)
+		backlog, err := readSegmentsSince(segments, c, size)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		for _, entry := range backlog {
+			channel <- entry
+		}
+		if len(channel) == cap(channel) {
+			file.Close()
+			close(channel)
+			return channel, nil
+		}
+	}
+	go follower.follow(ctx, channel)
 	return channel, nil
 }
 
-// Destroy deletes stored logs for the specified application
+// Destroy deletes stored logs, including rotated segments, for the specified application
 func (a *fileAdapter) Destroy(app string) error {
-	// Check first if the map of file pointers even contains the file pointer we want so we can avoid
-	// waiting for / obtaining a lock unnecessarily
-	f, ok := a.files[app]
-	if ok {
-		// Ensure no other goroutine is trying to modify the file pointer map while we're trying to
-		// clean up
-		a.mutex.Lock()
-		defer a.mutex.Unlock()
-		exists, err := fileExists(f.Name())
-		if err != nil {
+	// Ensure no other goroutine is trying to modify the file pointer map while we're trying to
+	// clean up
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if f, ok := a.files[app]; ok {
+		f.close()
+		delete(a.files, app)
+	}
+	if err := os.Remove(a.getFilePath(app)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	segments, err := a.segments(app)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if err := os.Remove(segment.path); err != nil && !os.IsNotExist(err) {
 			return err
 		}
-		if exists {
-			if err := os.Remove(f.Name()); err != nil {
-				return err
-			}
-		}
-		delete(a.files, app)
 	}
 	return nil
 }
@@ -140,12 +343,59 @@ func (a *fileAdapter) Reopen() error {
 	// we're trying to clear it out
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	a.files = make(map[string]*os.File)
+	for _, f := range a.files {
+		f.close()
+	}
+	a.files = make(map[string]*logFile)
 	return nil
 }
 
-// Stop the storage adapter-- in the case of this implementation, a no-op
+// Stop the storage adapter, waiting for rotated segments to be compressed
 func (a *fileAdapter) Stop() {
+	if a.started {
+		close(a.stopCh)
+		a.started = false
+	}
+	a.wg.Wait()
+}
+
+// getLogFile returns the current segment of an app, opening it on first use
+func (a *fileAdapter) getLogFile(app string) (*logFile, error) {
+	// Check first if we might actually have to add to the map of file pointers so we can avoid
+	// waiting for / obtaining the write lock unnecessarily
+	a.mutex.RLock()
+	f, ok := a.files[app]
+	a.mutex.RUnlock()
+	if ok {
+		return f, nil
+	}
+	// Ensure only one goroutine at a time can be adding a file pointer to the map of file pointers
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if f, ok = a.files[app]; ok {
+		return f, nil
+	}
+	file, err := a.getFile(app)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f = &logFile{file: file, size: info.Size(), opened: time.Now()}
+	a.files[app] = f
+	return f, nil
+}
+
+func (f *logFile) close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
 }
 
 func (a *fileAdapter) getFile(app string) (*os.File, error) {
@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+type fileConfig struct {
+	MaxSizeBytes     int64 `envconfig:"STORAGE_FILE_MAX_SIZE_BYTES" default:"104857600"`
+	MaxAgeSeconds    int   `envconfig:"STORAGE_FILE_MAX_AGE_SEC" default:"86400"`
+	Compress         bool  `envconfig:"STORAGE_FILE_COMPRESS" default:"true"`
+	RetainCount      int   `envconfig:"STORAGE_FILE_RETAIN_COUNT" default:"5"`
+	RetainAgeSeconds int   `envconfig:"STORAGE_FILE_RETAIN_AGE_SEC" default:"604800"`
+	RetainTotalBytes int64 `envconfig:"STORAGE_FILE_RETAIN_TOTAL_BYTES" default:"0"`
+	// RetentionIntervalSeconds is how often retention is applied to every app, including the apps
+	// which stopped logging and so are not rotated anymore
+	RetentionIntervalSeconds int `envconfig:"STORAGE_FILE_RETENTION_INTERVAL_SEC" default:"3600"`
+	MaxAge                   time.Duration
+	RetainAge                time.Duration
+	RetentionInterval        time.Duration
+}
+
+func parseFileConfig(appName string) (*fileConfig, error) {
+	ret := new(fileConfig)
+	if err := envconfig.Process(appName, ret); err != nil {
+		return nil, err
+	}
+	ret.MaxAge = time.Duration(ret.MaxAgeSeconds) * time.Second
+	ret.RetainAge = time.Duration(ret.RetainAgeSeconds) * time.Second
+	ret.RetentionInterval = time.Duration(ret.RetentionIntervalSeconds) * time.Second
+	return ret, nil
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// fileCursor is the position right after a line in the logs of an app. The file holding the line
+// is identified by its inode, which is kept in the name of the segment once rotated, so that the
+// cursor remains valid across rotation and compression.
+type fileCursor struct {
+	inode  uint64
+	offset int64
+}
+
+func (c fileCursor) String() string {
+	return strconv.FormatUint(c.inode, 10) + ":" + strconv.FormatInt(c.offset, 10)
+}
+
+func parseFileCursor(cursor string) (fileCursor, error) {
+	inode, offset, ok := strings.Cut(cursor, ":")
+	if ok {
+		c := fileCursor{}
+		var err1, err2 error
+		c.inode, err1 = strconv.ParseUint(inode, 10, 64)
+		c.offset, err2 = strconv.ParseInt(offset, 10, 64)
+		if err1 == nil && err2 == nil && c.offset >= 0 {
+			return c, nil
+		}
+	}
+	return fileCursor{}, fmt.Errorf("%w: %s", ErrInvalidCursor, cursor)
+}
+
+// fileInode returns the inode of a file, or 0 on platforms without inodes
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFileCursor(t *testing.T) {
+	c, err := parseFileCursor("1234:56")
+	assert.NoError(t, err)
+	assert.Equal(t, fileCursor{inode: 1234, offset: 56}, c)
+	assert.Equal(t, "1234:56", c.String())
+	for _, cursor := range []string{"", "1234", "a:1", "1:b", "1:-1", "1-2"} {
+		_, err := parseFileCursor(cursor)
+		assert.True(t, errors.Is(err, ErrInvalidCursor), "cursor %q", cursor)
+	}
+}
+
+func TestFileReadSinceAcrossSegments(t *testing.T) {
+	// "message 000\n" is 12 bytes, 10 lines per segment
+	a := newTestFileAdapter(t, &fileConfig{MaxSizeBytes: 120, Compress: true})
+	writeTestLines(t, a, app, 25)
+	a.Stop()
+
+	entries, err := a.ReadEntries(app, 25)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 25)
+	// resuming from any entry, compressed or not, returns the following ones
+	for _, i := range []int{0, 9, 10, 18, 24} {
+		since, err := a.ReadSince(app, entries[i].Cursor, 7)
+		assert.NoError(t, err)
+		expected := entries[i+1:]
+		if len(expected) > 7 {
+			expected = expected[:7]
+		}
+		assert.Equal(t, expected, since, "reading since entry %d", i)
+	}
+
+	// a cursor whose segment was deleted resumes from the oldest segment left
+	since, err := a.ReadSince(app, "0:12", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, entries[:2], since)
+
+	_, err = a.ReadSince(app, "bogus", 1)
+	assert.True(t, errors.Is(err, ErrInvalidCursor))
+	_, err = a.ReadSince("missing-app", entries[0].Cursor, 1)
+	assert.Error(t, err)
+}
+
+func TestFileFollowSince(t *testing.T) {
+	a := newTestFileAdapter(t, &fileConfig{MaxSizeBytes: 120})
+	writeTestLines(t, a, app, 15)
+	entries, err := a.ReadEntries(app, 15)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// from a cursor in the current segment
+	channel, err := a.FollowSince(ctx, app, entries[12].Cursor, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, entries[13:], receiveEntries(t, channel, 2))
+
+	// from a cursor in a rotated segment, the rest of it is sent before the current segment
+	channel, err = a.FollowSince(ctx, app, entries[7].Cursor, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, entries[8:], receiveEntries(t, channel, 7))
+
+	// new lines follow, with cursors to resume from
+	assert.NoError(t, a.Write(app, "message new"))
+	entry := receiveEntries(t, channel, 1)[0]
+	assert.Equal(t, "message new", entry.Line)
+	since, err := a.ReadSince(app, entries[14].Cursor, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{entry}, since)
+	a.Stop()
+}
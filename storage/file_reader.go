@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// readChunkSize is the size of the blocks read backwards from the end of a file, and of those
+	// read by followers
+	readChunkSize = 64 * 1024
+	// pollInterval is how often a followed file is checked when no file system events arrive, either
+	// because inotify is unavailable or as a safety net against missed events
+	pollInterval = time.Second
+	// fallbackPollInterval is used instead of pollInterval when inotify is unavailable
+	fallbackPollInterval = 250 * time.Millisecond
+)
+
+// readLastEntries returns the last n lines of a file with their cursors, oldest first, reading it
+// backwards from the end in chunks so that only the requested lines are read. A trailing newline
+// does not start a line.
+func readLastEntries(path string, n int) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size()
+	var data []byte
+	for offset > 0 {
+		size := int64(readChunkSize)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+		chunk := make([]byte, size)
+		if _, err := f.ReadAt(chunk, offset); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if offset+size == info.Size() {
+			chunk = bytes.TrimSuffix(chunk, []byte("\n"))
+		}
+		data = append(chunk, data...)
+		// n newlines mean the last n lines are complete
+		if bytes.Count(data, []byte("\n")) >= n {
+			break
+		}
+	}
+	if len(data) == 0 {
+		return []Entry{}, nil
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	// the cursor of a line is the offset right after it, counted back from the end of the file
+	entries := make([]Entry, len(lines))
+	cursor := fileCursor{inode: fileInode(info), offset: info.Size()}
+	for i := len(lines) - 1; i >= 0; i-- {
+		entries[i] = newEntry(cursor.String(), string(lines[i]))
+		cursor.offset -= int64(len(lines[i])) + 1
+	}
+	return entries, nil
+}
+
+// readEntriesFrom returns at most n complete lines read from an offset of a file, with their
+// cursors.
+func readEntriesFrom(r io.Reader, inode uint64, offset int64, n int) ([]Entry, error) {
+	entries := []Entry{}
+	if n <= 0 {
+		return entries, nil
+	}
+	err := scanEntries(r, inode, offset, func(entry Entry) bool {
+		entries = append(entries, entry)
+		return len(entries) < n
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// scanEntries calls fn with the complete lines read from an offset of a file and their cursors,
+// until it returns false. Lines are read from the decompressed content of gzipped files, whose
+// inode is the one the file had before it was compressed.
+func scanEntries(r io.Reader, inode uint64, offset int64, fn func(Entry) bool) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			// an incomplete line is being written
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		offset += int64(len(line))
+		entry := newEntry(fileCursor{inode: inode, offset: offset}.String(), strings.TrimSuffix(line, "\n"))
+		if !fn(entry) {
+			return nil
+		}
+	}
+}
+
+// fileFollower sends the lines appended to a file to a channel, like tail -F. It follows the path
+// rather than the open file, so that a file replaced by rotation is picked up once the rest of the
+// old file is read, and starts over from the beginning of a truncated file.
+type fileFollower struct {
+	path    string
+	file    *os.File
+	inode   uint64
+	offset  int64
+	partial []byte
+	// buf holds the chunk read
+	buf []byte
+}
+
+// newFileFollower returns a follower of the file open at path from an offset, taking ownership of
+// the file.
+func newFileFollower(path string, file *os.File, offset int64) (*fileFollower, error) {
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileFollower{path: path, file: file, inode: fileInode(info), offset: offset}, nil
+}
+
+// follow sends the lines appended to the file to the channel until the context is done or the
+// channel is full, then closes the channel. File system events wake the follower up when inotify
+// is available, otherwise the file is polled.
+func (f *fileFollower) follow(ctx context.Context, channel chan Entry) {
+	defer close(channel)
+	defer func() { f.file.Close() }()
+
+	var events chan fsnotify.Event
+	var errs chan error
+	interval := fallbackPollInterval
+	// the directory is watched rather than the file, whose watch would be lost on rotation
+	if watcher, err := fsnotify.NewWatcher(); err == nil {
+		defer watcher.Close()
+		if err := watcher.Add(filepath.Dir(f.path)); err == nil {
+			events, errs = watcher.Events, watcher.Errors
+			interval = pollInterval
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for f.readLines(ctx, channel) {
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				// the directory holds the files of other apps too
+				if filepath.Clean(event.Name) == filepath.Clean(f.path) {
+					break wait
+				}
+			case <-errs:
+			case <-ticker.C:
+				break wait
+			}
+		}
+	}
+}
+
+// readLines sends the complete lines appended since the last call, handling truncation and
+// rotation. It returns false once the follower should stop.
+func (f *fileFollower) readLines(ctx context.Context, channel chan Entry) bool {
+	for {
+		info, err := f.file.Stat()
+		if err != nil {
+			return false
+		}
+		if info.Size() < f.offset {
+			// truncated, start over
+			f.offset = 0
+			f.partial = nil
+		}
+		// read in chunks, so that a follower behind by a large part of the file does not hold it all
+		for f.offset < info.Size() {
+			if f.buf == nil {
+				f.buf = make([]byte, readChunkSize)
+			}
+			data := f.buf[:min(info.Size()-f.offset, int64(len(f.buf)))]
+			n, err := f.file.ReadAt(data, f.offset)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return false
+			}
+			if n == 0 {
+				break
+			}
+			cursor := fileCursor{inode: f.inode, offset: f.offset - int64(len(f.partial))}
+			f.offset += int64(n)
+			data = append(f.partial, data[:n]...)
+			lines := bytes.Split(data, []byte("\n"))
+			// the last element is an incomplete line, or empty if the data ends with a newline
+			f.partial = append([]byte(nil), lines[len(lines)-1]...)
+			for _, line := range lines[:len(lines)-1] {
+				cursor.offset += int64(len(line)) + 1
+				select {
+				case channel <- newEntry(cursor.String(), string(line)):
+				case <-ctx.Done():
+					return false
+				}
+				if len(channel) == cap(channel) {
+					return false
+				}
+			}
+		}
+		// once the old file is read to its end, switch to the file now at the path, if replaced
+		current, err := os.Stat(f.path)
+		if err != nil || os.SameFile(info, current) {
+			return true
+		}
+		file, err := os.Open(f.path)
+		if err != nil {
+			return true
+		}
+		opened, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return true
+		}
+		f.file.Close()
+		f.file, f.inode, f.offset, f.partial = file, fileInode(opened), 0, nil
+	}
+}
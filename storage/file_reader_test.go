@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadLastEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(path, nil, 0644))
+	entries, err := readLastEntries(path, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// lines spanning several chunks
+	var expected []string
+	var content strings.Builder
+	for i := 0; i < 3*readChunkSize/100; i++ {
+		line := fmt.Sprintf("%04d %s", i, strings.Repeat("x", 95))
+		expected = append(expected, line)
+		content.WriteString(line + "\n")
+	}
+	assert.NoError(t, os.WriteFile(path, []byte(content.String()), 0644))
+	for _, n := range []int{1, 5, readChunkSize / 100, len(expected), len(expected) + 10} {
+		entries, err := readLastEntries(path, n)
+		assert.NoError(t, err)
+		if n > len(expected) {
+			n = len(expected)
+		}
+		assert.Equal(t, expected[len(expected)-n:], linesOf(entries), "reading %d lines", n)
+	}
+
+	// the cursor of a line is the offset right after it
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	entries, err = readLastEntries(path, 2)
+	assert.NoError(t, err)
+	last := fileCursor{inode: fileInode(info), offset: info.Size()}
+	assert.Equal(t, last.String(), entries[1].Cursor)
+	last.offset -= 101
+	assert.Equal(t, last.String(), entries[0].Cursor)
+
+	// an unterminated last line is still a line
+	assert.NoError(t, os.WriteFile(path, []byte("a\n\nb"), 0644))
+	entries, err = readLastEntries(path, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "", "b"}, linesOf(entries))
+
+	_, err = readLastEntries(filepath.Join(t.TempDir(), "missing.log"), 1)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func receiveEntries(t *testing.T, channel chan Entry, n int) []Entry {
+	var entries []Entry
+	for i := 0; i < n; i++ {
+		select {
+		case entry := <-channel:
+			entries = append(entries, entry)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for line %d, got %v", i, entries)
+		}
+	}
+	return entries
+}
+
+func receiveLines(t *testing.T, channel chan Entry, n int) []string {
+	return linesOf(receiveEntries(t, channel, n))
+}
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	assert.NoError(t, err)
+	defer f.Close()
+	for _, line := range lines {
+		_, err := f.WriteString(line + "\n")
+		assert.NoError(t, err)
+	}
+}
+
+func TestFileFollower(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendLines(t, path, "before follow")
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	follower, err := newFileFollower(path, file, int64(len("before follow\n")))
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	channel := make(chan Entry, 100)
+	go follower.follow(ctx, channel)
+
+	appendLines(t, path, "line 1", "line 2")
+	assert.Equal(t, []string{"line 1", "line 2"}, receiveLines(t, channel, 2))
+
+	// a line written in two parts is sent once complete
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	f.WriteString("partial ")
+	time.Sleep(100 * time.Millisecond)
+	f.WriteString("line\n")
+	f.Close()
+	assert.Equal(t, []string{"partial line"}, receiveLines(t, channel, 1))
+
+	// truncation starts over from the beginning
+	assert.NoError(t, os.Truncate(path, 0))
+	time.Sleep(100 * time.Millisecond)
+	appendLines(t, path, "after truncate")
+	assert.Equal(t, []string{"after truncate"}, receiveLines(t, channel, 1))
+
+	// rotation switches to the new file once the old one is read
+	assert.NoError(t, os.Rename(path, path+".1"))
+	appendLines(t, path+".1", "old file")
+	appendLines(t, path, "new file")
+	assert.Equal(t, []string{"old file", "new file"}, receiveLines(t, channel, 2))
+
+	cancel()
+	select {
+	case _, ok := <-channel:
+		assert.False(t, ok, "expected the channel to be closed")
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel was not closed when the context was done")
+	}
+}
+
+func TestFileFollowerChunks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendLines(t, path, "before follow")
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	follower, err := newFileFollower(path, file, 0)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	channel := make(chan Entry, 1000)
+
+	// lines spanning several chunks are read whole, without reading the file in one go
+	long := strings.Repeat("x", readChunkSize+10)
+	appendLines(t, path, long, "after long")
+	go follower.follow(ctx, channel)
+	assert.Equal(t, []string{"before follow", long, "after long"}, receiveLines(t, channel, 3))
+	assert.Len(t, follower.buf, readChunkSize)
+}
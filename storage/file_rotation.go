@@ -0,0 +1,365 @@
+package storage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const gzipSuffix = ".gz"
+
+// segment is a log file of an app. Rotated segments are named after the current file of the app
+// with the time of their rotation in nanoseconds and their inode appended, e.g.
+// foo.log.1476822578000000000-1234, and gzipped if compression is enabled, e.g.
+// foo.log.1476822578000000000-1234.gz. The inode is the one of the file before compression, which
+// file cursors refer to.
+type segment struct {
+	path       string
+	rotated    int64
+	inode      uint64
+	compressed bool
+	size       int64
+	modTime    time.Time
+}
+
+// shouldRotate reports whether the current segment must be rotated before writing n bytes to it.
+// The age of a segment is counted from the time it was opened by this process.
+func (a *fileAdapter) shouldRotate(f *logFile, n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if a.config.MaxSizeBytes > 0 && f.size+int64(n) > a.config.MaxSizeBytes {
+		return true
+	}
+	return a.config.MaxAge > 0 && time.Since(f.opened) >= a.config.MaxAge
+}
+
+// rotate renames the current segment of an app and opens a new one. The rotated segment is
+// compressed and retention is applied in the background. The logFile mutex must be held.
+func (a *fileAdapter) rotate(app string, f *logFile) error {
+	filePath := a.getFilePath(app)
+	info, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	rotated := filePath + "." + strconv.FormatInt(time.Now().UnixNano(), 10) + "-" +
+		strconv.FormatUint(fileInode(info), 10)
+	// the open file is renamed, so that it stays usable if opening the new one fails
+	if err := os.Rename(filePath, rotated); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	f.file.Close()
+	f.file, f.size, f.opened = file, 0, time.Now()
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.retention.Lock()
+		defer a.retention.Unlock()
+		if a.config.Compress {
+			// the segment may already be deleted by the retention of a later rotation
+			if err := compressSegment(rotated); err != nil && !os.IsNotExist(err) {
+				log.Printf("compress log segment %s error: %v", rotated, err)
+			}
+		}
+		if err := a.applyRetention(app); err != nil {
+			log.Printf("log retention for %s error: %v", app, err)
+		}
+	}()
+	return nil
+}
+
+// compressSegment gzips a rotated segment and removes the uncompressed one. The compressed segment
+// is written under a temporary name so that it is never read partially written.
+func compressSegment(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + gzipSuffix + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+gzipSuffix); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// segments returns the rotated segments of an app, newest first. A segment being compressed is
+// listed once.
+func (a *fileAdapter) segments(app string) ([]segment, error) {
+	matches, err := filepath.Glob(a.getFilePath(app) + ".*")
+	if err != nil {
+		return nil, err
+	}
+	return listSegments(matches), nil
+}
+
+func listSegments(paths []string) []segment {
+	seen := make(map[string]bool)
+	var segments []segment
+	for _, path := range paths {
+		name := strings.TrimSuffix(path, gzipSuffix)
+		rotatedStr, inodeStr, _ := strings.Cut(strings.TrimPrefix(filepath.Ext(name), "."), "-")
+		rotated, err := strconv.ParseInt(rotatedStr, 10, 64)
+		if err != nil || seen[name] {
+			continue
+		}
+		inode, err := strconv.ParseUint(inodeStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		seen[name] = true
+		segments = append(segments, segment{
+			path:       path,
+			rotated:    rotated,
+			inode:      inode,
+			compressed: strings.HasSuffix(path, gzipSuffix),
+			size:       info.Size(),
+			modTime:    info.ModTime(),
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].rotated > segments[j].rotated
+	})
+	return segments
+}
+
+// listLogRoot returns the rotated segments of every app, newest first, and the total size of the
+// current segments
+func listLogRoot() ([]segment, int64, error) {
+	entries, err := os.ReadDir(LogRoot)
+	if err != nil {
+		return nil, 0, err
+	}
+	var total int64
+	var paths []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(LogRoot, entry.Name())
+		if strings.HasSuffix(entry.Name(), ".log") {
+			if info, err := entry.Info(); err == nil {
+				total += info.Size()
+			}
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return listSegments(paths), total, nil
+}
+
+// currentPath returns the path of the current segment of the app of a rotated segment
+func (s segment) currentPath() string {
+	name := strings.TrimSuffix(s.path, gzipSuffix)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// open opens a segment for reading, decompressing gzipped segments. A segment compressed since it
+// was listed is opened compressed.
+func (s segment) open() (io.ReadCloser, error) {
+	if !s.compressed {
+		f, err := os.Open(s.path)
+		if !os.IsNotExist(err) {
+			return f, err
+		}
+		s.path = s.path + gzipSuffix
+	}
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return gzipReadCloser{Reader: gz, file: f}, nil
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r gzipReadCloser) Close() error {
+	r.Reader.Close()
+	return r.file.Close()
+}
+
+// readLastEntries returns the last n lines of a segment with their cursors, oldest first
+func (s segment) readLastEntries(n int) ([]Entry, error) {
+	if !s.compressed {
+		entries, err := readLastEntries(s.path, n)
+		if !os.IsNotExist(err) {
+			return entries, err
+		}
+	}
+	r, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	// gzip streams cannot be read backwards, keep the last n lines in a ring
+	ring := make([]Entry, n)
+	count := 0
+	reader := bufio.NewReader(r)
+	var offset int64
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			offset += int64(len(line))
+			ring[count%n] = newEntry(fileCursor{inode: s.inode, offset: offset}.String(), strings.TrimSuffix(line, "\n"))
+			count++
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if count < n {
+		return ring[:count], nil
+	}
+	start := count % n
+	return append(ring[start:], ring[:start]...), nil
+}
+
+// scan calls fn with the complete lines of a segment and their cursors, until it returns false
+func (s segment) scan(fn func(Entry) bool) error {
+	r, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return scanEntries(r, s.inode, 0, fn)
+}
+
+// readFrom returns at most n complete lines of a segment from an offset, with their cursors
+func (s segment) readFrom(offset int64, n int) ([]Entry, error) {
+	r, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if f, ok := r.(*os.File); ok {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	} else if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			return []Entry{}, nil
+		}
+		return nil, err
+	}
+	return readEntriesFrom(r, s.inode, offset, n)
+}
+
+// applyRetention deletes the rotated segments of an app beyond the retained count or age, then the
+// oldest segments of every app while the logs exceed the disk budget. The retention mutex must be
+// held.
+func (a *fileAdapter) applyRetention(app string) error {
+	segments, err := a.segments(app)
+	if err != nil {
+		return err
+	}
+	if err := a.retainSegments(segments); err != nil {
+		return err
+	}
+	if a.config.RetainTotalBytes > 0 {
+		return a.enforceDiskBudget()
+	}
+	return nil
+}
+
+// applyRetentionAll applies retention to the rotated segments of every app, so that the segments of
+// the apps which are not rotated anymore are deleted once too old as well
+func (a *fileAdapter) applyRetentionAll() error {
+	a.retention.Lock()
+	defer a.retention.Unlock()
+	segments, _, err := listLogRoot()
+	if err != nil {
+		return err
+	}
+	apps := make(map[string][]segment)
+	for _, segment := range segments {
+		apps[segment.currentPath()] = append(apps[segment.currentPath()], segment)
+	}
+	for _, segments := range apps {
+		if err := a.retainSegments(segments); err != nil {
+			return err
+		}
+	}
+	if a.config.RetainTotalBytes > 0 {
+		return a.enforceDiskBudget()
+	}
+	return nil
+}
+
+// retainSegments deletes the rotated segments of an app, newest first, beyond the retained count or
+// age
+func (a *fileAdapter) retainSegments(segments []segment) error {
+	for i, segment := range segments {
+		if (a.config.RetainCount > 0 && i >= a.config.RetainCount) ||
+			(a.config.RetainAge > 0 && time.Since(segment.modTime) > a.config.RetainAge) {
+			if err := os.Remove(segment.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// enforceDiskBudget deletes the oldest rotated segments, whichever app they belong to, until the
+// current and rotated segments of every app fit in the disk budget. Current segments are never
+// deleted.
+func (a *fileAdapter) enforceDiskBudget() error {
+	segments, total, err := listLogRoot()
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		total += segment.size
+	}
+	// oldest first
+	for i := len(segments) - 1; i >= 0 && total > a.config.RetainTotalBytes; i-- {
+		if err := os.Remove(segments[i].path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= segments[i].size
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestFileAdapter(t *testing.T, cfg *fileConfig) *fileAdapter {
+	LogRoot = t.TempDir()
+	return &fileAdapter{files: make(map[string]*logFile), config: cfg}
+}
+
+func writeTestLines(t *testing.T, a *fileAdapter, app string, count int) {
+	for i := 0; i < count; i++ {
+		assert.NoError(t, a.Write(app, fmt.Sprintf("message %03d", i)))
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	// "message 000\n" is 12 bytes, 10 lines per segment
+	a := newTestFileAdapter(t, &fileConfig{MaxSizeBytes: 120, Compress: true})
+	writeTestLines(t, a, app, 35)
+	a.Stop()
+
+	segments, err := a.segments(app)
+	assert.NoError(t, err)
+	assert.Len(t, segments, 3)
+	for _, segment := range segments {
+		assert.True(t, segment.compressed, "segment %s is not compressed", segment.path)
+	}
+	info, err := os.Stat(a.getFilePath(app))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(60), info.Size())
+
+	// reads span the current and rotated segments
+	logs, err := a.Read(app, 17)
+	assert.NoError(t, err)
+	assert.Len(t, logs, 17)
+	for i, line := range logs {
+		assert.Equal(t, fmt.Sprintf("message %03d", i+18), line)
+	}
+	logs, err = a.Read(app, 100)
+	assert.NoError(t, err)
+	assert.Len(t, logs, 35)
+	assert.Equal(t, "message 000", logs[0])
+}
+
+func TestRotateByAge(t *testing.T) {
+	a := newTestFileAdapter(t, &fileConfig{MaxAge: time.Nanosecond})
+	writeTestLines(t, a, app, 3)
+	a.Stop()
+	segments, err := a.segments(app)
+	assert.NoError(t, err)
+	assert.Len(t, segments, 2)
+	assert.False(t, segments[0].compressed)
+	logs, err := a.Read(app, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"message 000", "message 001", "message 002"}, logs)
+}
+
+func TestRetainCount(t *testing.T) {
+	a := newTestFileAdapter(t, &fileConfig{MaxSizeBytes: 12, RetainCount: 2, Compress: true})
+	writeTestLines(t, a, app, 6)
+	a.Stop()
+	segments, err := a.segments(app)
+	assert.NoError(t, err)
+	assert.Len(t, segments, 2)
+	logs, err := a.Read(app, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"message 003", "message 004", "message 005"}, logs)
+}
+
+func TestRetainTotalBytes(t *testing.T) {
+	// segments are kept uncompressed, 12 bytes each, so that sizes are predictable
+	a := newTestFileAdapter(t, &fileConfig{MaxSizeBytes: 12, RetainTotalBytes: 60})
+	writeTestLines(t, a, app, 4)
+	writeTestLines(t, a, "other-app", 4)
+	a.Stop()
+	var total int64
+	matches, err := filepath.Glob(filepath.Join(LogRoot, "*"))
+	assert.NoError(t, err)
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		assert.NoError(t, err)
+		total += info.Size()
+	}
+	assert.LessOrEqual(t, total, int64(60))
+	// the oldest segments, those of the first app, are deleted first
+	segments, err := a.segments(app)
+	assert.NoError(t, err)
+	assert.Len(t, segments, 0)
+	segments, err = a.segments("other-app")
+	assert.NoError(t, err)
+	assert.Len(t, segments, 3)
+}
+
+func TestDestroyRotated(t *testing.T) {
+	a := newTestFileAdapter(t, &fileConfig{MaxSizeBytes: 12, Compress: true})
+	writeTestLines(t, a, app, 3)
+	a.Stop()
+	assert.NoError(t, a.Destroy(app))
+	matches, err := filepath.Glob(filepath.Join(LogRoot, "*"))
+	assert.NoError(t, err)
+	assert.Empty(t, matches)
+	_, err = a.Read(app, 1)
+	assert.Error(t, err)
+}
+
+func TestRetainAgePeriodically(t *testing.T) {
+	a := newTestFileAdapter(t, &fileConfig{MaxSizeBytes: 12, RetainAge: time.Hour, RetentionInterval: 10 * time.Millisecond})
+	writeTestLines(t, a, app, 3)
+	a.Stop()
+	segments, err := a.segments(app)
+	assert.NoError(t, err)
+	assert.Len(t, segments, 2)
+
+	// the segments of an app which stopped logging are deleted once too old, without a rotation
+	old := time.Now().Add(-2 * time.Hour)
+	for _, segment := range segments {
+		assert.NoError(t, os.Chtimes(segment.path, old, old))
+	}
+	a.Start()
+	defer a.Stop()
+	assert.Eventually(t, func() bool {
+		segments, err := a.segments(app)
+		return err == nil && len(segments) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	logs, err := a.Read(app, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"message 002"}, logs)
+}
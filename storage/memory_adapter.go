@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+// memoryShards is the number of shards apps are spread over, so that writes to different apps
+// rarely contend for the same lock
+const memoryShards = 32
+
+// ringBuffer holds the last lines written to it, up to its capacity. Lines are numbered in the
+// order they are written, their number is their cursor.
+type ringBuffer struct {
+	lines []string
+	start int
+	count int
+	// seq is the number of the last line written
+	seq int64
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{lines: make([]string, capacity)}
+}
+
+func (r *ringBuffer) add(line string) Entry {
+	r.lines[(r.start+r.count)%len(r.lines)] = line
+	if r.count < len(r.lines) {
+		r.count++
+	} else {
+		r.start = (r.start + 1) % len(r.lines)
+	}
+	r.seq++
+	return newEntry(strconv.FormatInt(r.seq, 10), line)
+}
+
+// last returns a copy of the n most recent entries, oldest first
+func (r *ringBuffer) last(n int) []Entry {
+	if n > r.count {
+		n = r.count
+	}
+	return r.since(r.seq-int64(n), n)
+}
+
+// since returns a copy of at most n entries written after the line numbered seq, oldest first.
+// Lines no longer held are skipped, as are all lines if seq is ahead of the buffer, which happens
+// once the logs of an app are destroyed and written again.
+func (r *ringBuffer) since(seq int64, n int) []Entry {
+	oldest := r.seq - int64(r.count)
+	if seq < oldest || seq > r.seq {
+		seq = oldest
+	}
+	if available := int(r.seq - seq); n > available {
+		n = available
+	}
+	entries := make([]Entry, n)
+	for i := 0; i < n; i++ {
+		offset := int(seq-oldest) + i
+		entries[i] = newEntry(strconv.FormatInt(seq+int64(i)+1, 10), r.lines[(r.start+offset)%len(r.lines)])
+	}
+	return entries
+}
+
+// parseSeqCursor parses the cursors of the adapters numbering the lines of an app in order, the
+// memory and valkey adapters
+func parseSeqCursor(cursor string) (int64, error) {
+	seq, err := strconv.ParseInt(cursor, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidCursor, cursor)
+	}
+	return seq, nil
+}
+
+// memoryShard holds the buffers and subscribers of the apps hashed to it. Every subscriber channel
+// maps to a channel closed once it is unsubscribed.
+type memoryShard struct {
+	mutex       sync.RWMutex
+	buffers     map[string]*ringBuffer
+	subscribers map[string]map[chan Entry]chan struct{}
+}
+
+type memoryAdapter struct {
+	bufferSize int
+	shards     [memoryShards]*memoryShard
+}
+
+// NewMemoryAdapter returns an Adapter that keeps the last bufferSize lines of every app in memory.
+func NewMemoryAdapter(bufferSize int) (Adapter, error) {
+	if bufferSize <= 0 {
+		return nil, fmt.Errorf("invalid buffer size: %d", bufferSize)
+	}
+	a := &memoryAdapter{bufferSize: bufferSize}
+	for i := range a.shards {
+		a.shards[i] = &memoryShard{
+			buffers:     make(map[string]*ringBuffer),
+			subscribers: make(map[string]map[chan Entry]chan struct{}),
+		}
+	}
+	return a, nil
+}
+
+func (a *memoryAdapter) shard(app string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(app))
+	return a.shards[h.Sum32()%memoryShards]
+}
+
+// Start the storage adapter-- in the case of this implementation, a no-op
+func (a *memoryAdapter) Start() {
+}
+
+// Write adds a log message to an app-specific ring buffer and sends it to the app's subscribers
+func (a *memoryAdapter) Write(app string, message string) error {
+	s := a.shard(app)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	buffer, ok := s.buffers[app]
+	if !ok {
+		buffer = newRingBuffer(a.bufferSize)
+		s.buffers[app] = buffer
+	}
+	entry := buffer.add(message)
+	for channel := range s.subscribers[app] {
+		// a subscriber falling behind is dropped, as it would be by the other adapters once its
+		// channel is full
+		select {
+		case channel <- entry:
+		default:
+		}
+		if len(channel) == cap(channel) {
+			s.unsubscribe(app, channel)
+		}
+	}
+	return nil
+}
+
+// Read retrieves a specified number of log lines from an app-specific ring buffer
+func (a *memoryAdapter) Read(app string, lines int) ([]string, error) {
+	entries, err := a.ReadEntries(app, lines)
+	if err != nil {
+		return nil, err
+	}
+	return linesOf(entries), nil
+}
+
+// ReadEntries is the CursorAdapter interface implementation
+func (a *memoryAdapter) ReadEntries(app string, lines int) ([]Entry, error) {
+	if lines <= 0 {
+		return []Entry{}, nil
+	}
+	s := a.shard(app)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	buffer, ok := s.buffers[app]
+	if !ok {
+		return nil, fmt.Errorf("could not find logs for '%s'", app)
+	}
+	return buffer.last(lines), nil
+}
+
+// ReadSince is the CursorAdapter interface implementation
+func (a *memoryAdapter) ReadSince(app string, cursor string, lines int) ([]Entry, error) {
+	seq, err := parseSeqCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if lines <= 0 {
+		return []Entry{}, nil
+	}
+	s := a.shard(app)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	buffer, ok := s.buffers[app]
+	if !ok {
+		return nil, fmt.Errorf("could not find logs for '%s'", app)
+	}
+	return buffer.since(seq, lines), nil
+}
+
+// Query is the QueryAdapter interface implementation
+func (a *memoryAdapter) Query(app string, query Query, lines int) ([]Entry, string, error) {
+	s := a.shard(app)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	buffer, ok := s.buffers[app]
+	if !ok {
+		return nil, "", fmt.Errorf("could not find logs for '%s'", app)
+	}
+	filter := newQueryFilter(query, lines)
+	for _, entry := range buffer.last(buffer.count) {
+		if !filter.add(entry) {
+			break
+		}
+	}
+	return filter.entries(), filter.cursor, nil
+}
+
+// Chan subscribes to the log messages written for an app from now on. The channel is closed once
+// the context is done or the channel is full.
+func (a *memoryAdapter) Chan(ctx context.Context, app string, size int) (chan string, error) {
+	entries, err := a.FollowSince(ctx, app, "", size)
+	if err != nil {
+		return nil, err
+	}
+	return entryLines(ctx, entries), nil
+}
+
+// FollowSince is the CursorAdapter interface implementation. The entries held after the cursor
+// are sent before subscribing, under the same lock as writes, so that none is missed.
+func (a *memoryAdapter) FollowSince(ctx context.Context, app string, cursor string, size int) (chan Entry, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid channel size: %d", size)
+	}
+	var seq int64
+	if cursor != "" {
+		var err error
+		if seq, err = parseSeqCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+	channel := make(chan Entry, size)
+	s := a.shard(app)
+	s.mutex.Lock()
+	if buffer, ok := s.buffers[app]; ok && cursor != "" {
+		for _, entry := range buffer.since(seq, size) {
+			channel <- entry
+		}
+	}
+	if len(channel) == cap(channel) {
+		s.mutex.Unlock()
+		close(channel)
+		return channel, nil
+	}
+	if s.subscribers[app] == nil {
+		s.subscribers[app] = make(map[chan Entry]chan struct{})
+	}
+	unsubscribed := make(chan struct{})
+	s.subscribers[app][channel] = unsubscribed
+	s.mutex.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-unsubscribed:
+			return
+		}
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.unsubscribe(app, channel)
+	}()
+	return channel, nil
+}
+
+// unsubscribe removes and closes a subscriber channel unless it is already removed, and signals
+// the goroutine waiting for its context to be done. The shard mutex must be held.
+func (s *memoryShard) unsubscribe(app string, channel chan Entry) {
+	unsubscribed, ok := s.subscribers[app][channel]
+	if !ok {
+		return
+	}
+	delete(s.subscribers[app], channel)
+	if len(s.subscribers[app]) == 0 {
+		delete(s.subscribers, app)
+	}
+	close(channel)
+	close(unsubscribed)
+}
+
+// Destroy deletes stored logs for the specified application
+func (a *memoryAdapter) Destroy(app string) error {
+	s := a.shard(app)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.buffers, app)
+	return nil
+}
+
+// Reopen the storage adapter-- in the case of this implementation, a no-op
+func (a *memoryAdapter) Reopen() error {
+	return nil
+}
+
+// Stop the storage adapter, closing the channels of every subscriber
+func (a *memoryAdapter) Stop() {
+	for _, s := range a.shards {
+		s.mutex.Lock()
+		for app, channels := range s.subscribers {
+			for channel := range channels {
+				s.unsubscribe(app, channel)
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
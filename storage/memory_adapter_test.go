@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMemoryReadFromNonExistingApp(t *testing.T) {
+	a, err := NewMemoryAdapter(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := a.Read(app, 10)
+	if messages != nil {
+		t.Error("expected no messages, but got some")
+	}
+	if err == nil || err.Error() != fmt.Sprintf("could not find logs for '%s'", app) {
+		t.Error("did not receive expected error message")
+	}
+}
+
+func TestMemoryWithBadBufferSizes(t *testing.T) {
+	for _, size := range []int{-1, 0} {
+		if _, err := NewMemoryAdapter(size); err == nil {
+			t.Errorf("expected an error for buffer size %d", size)
+		}
+	}
+}
+
+func TestMemoryLogs(t *testing.T) {
+	a, err := NewMemoryAdapter(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write more logs than the buffer holds
+	for i := 0; i < 15; i++ {
+		if err := a.Write(app, fmt.Sprintf("message %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	// Read more logs than there are, only the buffer size is kept
+	messages, err := a.Read(app, 20)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(messages) != 10 {
+		t.Errorf("expected 10 log messages, got %d", len(messages))
+	}
+	// Read fewer logs than there are, getting the MOST RECENT ones
+	messages, err = a.Read(app, 3)
+	if err != nil {
+		t.Error(err)
+	}
+	for i := 0; i < 3; i++ {
+		expectedMessage := fmt.Sprintf("message %d", i+12)
+		if messages[i] != expectedMessage {
+			t.Errorf("expected: \"%s\", got \"%s\"", expectedMessage, messages[i])
+		}
+	}
+	if err := a.Destroy(app); err != nil {
+		t.Error(err)
+	}
+	if _, err := a.Read(app, 1); err == nil {
+		t.Error("expected an error reading destroyed logs")
+	}
+}
+
+func TestMemoryChan(t *testing.T) {
+	a, err := NewMemoryAdapter(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	channels := make([]chan string, 2)
+	for i := range channels {
+		if channels[i], err = a.Chan(ctx, app, 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if err := a.Write(app, fmt.Sprintf("Hello, log %d !", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	// every subscriber receives every message
+	for _, channel := range channels {
+		for i := 0; i < 10; i++ {
+			expected := fmt.Sprintf("Hello, log %d !", i)
+			if line := <-channel; line != expected {
+				t.Errorf("expected: %s\nactual: %s\n", expected, line)
+			}
+		}
+	}
+	cancel()
+	for _, channel := range channels {
+		select {
+		case _, ok := <-channel:
+			if ok {
+				t.Error("expected the channel to be closed")
+			}
+		case <-time.After(time.Second):
+			t.Error("channel was not closed when the context was done")
+		}
+	}
+}
+
+func TestMemoryChanFull(t *testing.T) {
+	a, err := NewMemoryAdapter(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel, err := a.Chan(context.Background(), app, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := a.Write(app, fmt.Sprintf("message %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	lines := []string{}
+	for line := range channel {
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Errorf("expected the channel to be closed once full, got %v", lines)
+	}
+}
+
+func TestMemoryFollowDropped(t *testing.T) {
+	a, err := NewMemoryAdapter(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goroutines := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	channel, err := a.(CursorAdapter).FollowSince(ctx, app, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := a.Write(app, fmt.Sprintf("message %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	for range channel {
+	}
+	// the goroutine waiting for the context exits once the subscriber is dropped
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > goroutines; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d goroutines once the subscriber is dropped, got %d", goroutines, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMemoryCursors(t *testing.T) {
+	a, err := NewMemoryAdapter(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := a.(CursorAdapter)
+	for i := 0; i < 15; i++ {
+		if err := a.Write(app, fmt.Sprintf("message %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	entries, err := c.ReadEntries(app, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].Line != "message 12" || entries[0].Cursor != "13" {
+		t.Errorf("unexpected first entry %v", entries[0])
+	}
+	since, err := c.ReadSince(app, entries[0].Cursor, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(since) != 2 || since[0] != entries[1] || since[1] != entries[2] {
+		t.Errorf("expected the entries after %s, got %v", entries[0].Cursor, since)
+	}
+	// a cursor of lines no longer held resumes from the oldest line held
+	since, err = c.ReadSince(app, "1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(since) != 1 || since[0].Line != "message 5" {
+		t.Errorf("expected the oldest entry held, got %v", since)
+	}
+	if _, err := c.ReadSince(app, "bogus", 1); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected an invalid cursor error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	channel, err := c.FollowSince(ctx, app, entries[1].Cursor, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Write(app, "message 15"); err != nil {
+		t.Error(err)
+	}
+	for _, expected := range []string{"message 14", "message 15"} {
+		if entry := <-channel; entry.Line != expected {
+			t.Errorf("expected: %s\nactual: %s\n", expected, entry.Line)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// metadataSeparator separates a stored log line from its metadata. The ASCII unit separator is
+// not expected in log lines, unlike any printable character.
+const metadataSeparator = "\x1f"
+
+// Metadata describes the message a log line was rendered from, so that lines can be filtered by
+// more than their text and returned as structured records.
+type Metadata struct {
+	// Time is when the message was emitted
+	Time time.Time `json:"time,omitzero"`
+	// Process is the type of the process that emitted the message, e.g. web or worker
+	Process string `json:"process,omitempty"`
+	// Pod is the name of the pod that emitted the message
+	Pod string `json:"pod,omitempty"`
+	// Container is the name of the container that emitted the message
+	Container string `json:"container,omitempty"`
+	// Stream is the stream the message was written to, stdout or stderr
+	Stream string `json:"stream,omitempty"`
+	// Level is the severity of the message, as parsed from it or set by the log shipper
+	Level string `json:"level,omitempty"`
+}
+
+// EncodeLine returns a log line along with its metadata, to be written to an adapter. Adapters
+// store it as is and return the line and its metadata apart, in Entry, and the line alone from
+// Read and Chan. The message is not stored, as it is part of the line.
+func EncodeLine(line string, metadata Metadata) string {
+	if metadata == (Metadata{}) {
+		return line
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return line
+	}
+	return line + metadataSeparator + string(data)
+}
+
+// decodeLine returns the line and metadata of a stored line. Lines stored without metadata, such
+// as those written before metadata was stored, have none.
+func decodeLine(stored string) (string, Metadata) {
+	var metadata Metadata
+	i := strings.LastIndex(stored, metadataSeparator)
+	if i < 0 {
+		return stored, metadata
+	}
+	if err := json.Unmarshal([]byte(stored[i+len(metadataSeparator):]), &metadata); err != nil {
+		return stored, Metadata{}
+	}
+	return stored[:i], metadata
+}
+
+// newEntry returns the entry of a stored line
+func newEntry(cursor string, stored string) Entry {
+	line, metadata := decodeLine(stored)
+	return Entry{Cursor: cursor, Line: line, Metadata: metadata}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeLine(t *testing.T) {
+	metadata := Metadata{Pod: "web-1", Stream: "stderr", Level: "error"}
+	stored := EncodeLine("drycc[web.v1]: message", metadata)
+	assert.Equal(t, "drycc[web.v1]: message\x1f{\"pod\":\"web-1\",\"stream\":\"stderr\",\"level\":\"error\"}", stored)
+	assert.Equal(t, Entry{Cursor: "1", Line: "drycc[web.v1]: message", Metadata: metadata}, newEntry("1", stored))
+
+	// lines without metadata are stored as is
+	assert.Equal(t, "message", EncodeLine("message", Metadata{}))
+	assert.Equal(t, Entry{Line: "message"}, newEntry("", "message"))
+	// a separator not followed by metadata is part of the line
+	assert.Equal(t, Entry{Line: "a\x1fb"}, newEntry("", "a\x1fb"))
+}
+
+func testMetadata(t *testing.T, a Adapter, follow func(ctx context.Context) (chan Entry, error)) {
+	metadata := Metadata{Pod: "web-1", Stream: "stdout"}
+	assert.NoError(t, a.Write(app, EncodeLine("message 0", metadata)))
+	assert.NoError(t, a.Write(app, "message 1"))
+
+	// Read returns the lines alone
+	lines, err := a.Read(app, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"message 0", "message 1"}, lines)
+	entries, err := ReadEntries(a, app, 2)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, metadata, entries[0].Metadata)
+	assert.Equal(t, Metadata{}, entries[1].Metadata)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	channel, err := follow(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, a.Write(app, EncodeLine("message 2", metadata)))
+	entry := receiveEntries(t, channel, 1)[0]
+	assert.Equal(t, "message 2", entry.Line)
+	assert.Equal(t, metadata, entry.Metadata)
+}
+
+func TestMemoryMetadata(t *testing.T) {
+	a, err := NewMemoryAdapter(10)
+	assert.NoError(t, err)
+	testMetadata(t, a, func(ctx context.Context) (chan Entry, error) {
+		return a.(CursorAdapter).FollowSince(ctx, app, "", 10)
+	})
+}
+
+func TestFileMetadata(t *testing.T) {
+	a := newTestFileAdapter(t, &fileConfig{MaxSizeBytes: 120, Compress: true})
+	defer a.Stop()
+	testMetadata(t, a, func(ctx context.Context) (chan Entry, error) {
+		return a.FollowSince(ctx, app, "", 10)
+	})
+	// the metadata is kept in rotated segments too
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, a.Write(app, EncodeLine("rotated", Metadata{Stream: "stderr"})))
+	}
+	a.Stop()
+	entries, _, err := a.Query(app, Query{Match: func(entry Entry) bool { return entry.Metadata.Stream == "stderr" }}, 10)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 5)
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	operationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logger",
+		Subsystem: "storage",
+		Name:      "operations_total",
+		Help:      "Number of storage operations, by adapter, operation and result.",
+	}, []string{"adapter", "operation", "result"})
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "logger",
+		Subsystem: "storage",
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of storage operations, by adapter and operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"adapter", "operation"})
+	pipelineBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "logger",
+		Subsystem: "storage",
+		Name:      "valkey_pipeline_batch_size",
+		Help:      "Number of messages flushed to valkey per pipeline.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+	pipelineFlushDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "logger",
+		Subsystem: "storage",
+		Name:      "valkey_pipeline_flush_duration_seconds",
+		Help:      "Duration of valkey pipeline flushes.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 4, 10),
+	})
+	droppedLines = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logger",
+		Subsystem: "storage",
+		Name:      "dropped_lines_total",
+		Help:      "Number of log lines dropped, by adapter and reason: the overflow policy, wal-full, write-error or rejected.",
+	}, []string{"adapter", "reason"})
+	valkeyCommandErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logger",
+		Subsystem: "storage",
+		Name:      "valkey_command_errors_total",
+		Help:      "Number of log lines whose valkey commands failed, by adapter and kind: transient or permanent.",
+	}, []string{"adapter", "kind"})
+	pipelineRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logger",
+		Subsystem: "storage",
+		Name:      "valkey_pipeline_retries_total",
+		Help:      "Number of times the log lines failing for a transient reason were written to valkey again, by adapter.",
+	}, []string{"adapter"})
+)
+
+// instrumentedAdapter records the count, result and latency of the operations of an Adapter
+type instrumentedAdapter struct {
+	Adapter
+	name string
+}
+
+func newInstrumentedAdapter(name string, adapter Adapter) Adapter {
+	return &instrumentedAdapter{Adapter: adapter, name: name}
+}
+
+func (a *instrumentedAdapter) observe(operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	operationsTotal.WithLabelValues(a.name, operation, result).Inc()
+	operationDuration.WithLabelValues(a.name, operation).Observe(time.Since(start).Seconds())
+}
+
+// Write is the Adapter interface implementation
+func (a *instrumentedAdapter) Write(app string, message string) error {
+	start := time.Now()
+	err := a.Adapter.Write(app, message)
+	a.observe("write", start, err)
+	return err
+}
+
+// Read is the Adapter interface implementation
+func (a *instrumentedAdapter) Read(app string, lines int) ([]string, error) {
+	start := time.Now()
+	logs, err := a.Adapter.Read(app, lines)
+	a.observe("read", start, err)
+	return logs, err
+}
+
+// Chan is the Adapter interface implementation
+func (a *instrumentedAdapter) Chan(ctx context.Context, app string, size int) (chan string, error) {
+	start := time.Now()
+	channel, err := a.Adapter.Chan(ctx, app, size)
+	a.observe("chan", start, err)
+	return channel, err
+}
+
+// ReadEntries is the CursorAdapter interface implementation
+func (a *instrumentedAdapter) ReadEntries(app string, lines int) ([]Entry, error) {
+	start := time.Now()
+	entries, err := ReadEntries(a.Adapter, app, lines)
+	a.observe("read", start, err)
+	return entries, err
+}
+
+// ReadSince is the CursorAdapter interface implementation
+func (a *instrumentedAdapter) ReadSince(app string, cursor string, lines int) ([]Entry, error) {
+	start := time.Now()
+	entries, err := ReadSince(a.Adapter, app, cursor, lines)
+	a.observe("read", start, err)
+	return entries, err
+}
+
+// Query is the QueryAdapter interface implementation
+func (a *instrumentedAdapter) Query(app string, query Query, lines int) ([]Entry, string, error) {
+	start := time.Now()
+	entries, cursor, err := ReadQuery(a.Adapter, app, query, lines)
+	a.observe("read", start, err)
+	return entries, cursor, err
+}
+
+// FollowSince is the CursorAdapter interface implementation
+func (a *instrumentedAdapter) FollowSince(ctx context.Context, app string, cursor string, size int) (chan Entry, error) {
+	start := time.Now()
+	channel, err := FollowSince(ctx, a.Adapter, app, cursor, size)
+	a.observe("chan", start, err)
+	return channel, err
+}
+
+// Sync is the Syncer interface implementation
+func (a *instrumentedAdapter) Sync(ctx context.Context) error {
+	start := time.Now()
+	err := Sync(ctx, a.Adapter)
+	a.observe("sync", start, err)
+	return err
+}
+
+// Destroy is the Adapter interface implementation
+func (a *instrumentedAdapter) Destroy(app string) error {
+	start := time.Now()
+	err := a.Adapter.Destroy(app)
+	a.observe("destroy", start, err)
+	return err
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubAdapter struct {
+	Adapter
+	err error
+}
+
+func (a *stubAdapter) Write(string, string) error {
+	return a.err
+}
+
+func (a *stubAdapter) Read(string, int) ([]string, error) {
+	return []string{"Hello, log!"}, a.err
+}
+
+func TestInstrumentedAdapter(t *testing.T) {
+	stub := &stubAdapter{}
+	a := newInstrumentedAdapter("stub", stub)
+
+	assert.NoError(t, a.Write(app, "Hello, log!"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(operationsTotal.WithLabelValues("stub", "write", "success")))
+
+	stub.err = errors.New("write failed")
+	assert.Error(t, a.Write(app, "Hello, log!"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(operationsTotal.WithLabelValues("stub", "write", "error")))
+
+	logs, err := a.Read(app, 1)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"Hello, log!"}, logs)
+	assert.Equal(t, 1.0, testutil.ToFloat64(operationsTotal.WithLabelValues("stub", "read", "error")))
+}
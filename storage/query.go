@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// queryScanLines is the number of lines scanned for a query by adapters that do not implement
+	// QueryAdapter
+	queryScanLines = 1 << 20
+	// queryPageLines is the number of lines adapters read at once while scanning for a query
+	queryPageLines = 1000
+	// rangeSlack is how much later than the time in a line the line may be stored, due to buffering
+	// by fluent-bit and the adapters. Adapters skipping stored lines by the time they were stored
+	// widen the range by it.
+	rangeSlack = time.Minute
+)
+
+// Query selects the entries of an app within a time range that match a predicate
+type Query struct {
+	// Since and Until are the time range, both included. A zero time leaves the range open on that
+	// side.
+	Since, Until time.Time
+	// Match reports whether an entry is selected, every entry is when nil
+	Match func(Entry) bool
+}
+
+// QueryAdapter is implemented by adapters able to query the lines of an app without reading every
+// line they hold.
+type QueryAdapter interface {
+	// Query returns the last lines entries of an app selected by query, oldest first, and the
+	// cursor of the last entry scanned, selected or not.
+	Query(app string, query Query, lines int) ([]Entry, string, error)
+}
+
+// ReadQuery returns the last lines entries of an app selected by query from an adapter and the
+// cursor of the last entry scanned, from which the lines written since are read. The entries of
+// adapters that do not implement QueryAdapter are filtered from their last lines.
+func ReadQuery(a Adapter, app string, query Query, lines int) ([]Entry, string, error) {
+	if q, ok := a.(QueryAdapter); ok {
+		return q.Query(app, query, lines)
+	}
+	entries, err := ReadEntries(a, app, queryScanLines)
+	if err != nil {
+		return nil, "", err
+	}
+	filter := newQueryFilter(query, lines)
+	for _, entry := range entries {
+		filter.add(entry)
+	}
+	return filter.entries(), filter.cursor, nil
+}
+
+// TimeFormat is the layout of the time log lines start with, e.g. "2006-01-02T15:04:05-07:00" in
+// "2006-01-02T15:04:05-07:00 drycc[web.v2]: message", the offset of UTC times included.
+const TimeFormat = "2006-01-02T15:04:05-07:00"
+
+// LineTime returns the time a log line starts with, as written by the formatters of the log
+// package, e.g. "2006-01-02T15:04:05-07:00 drycc[web.v2]: message".
+func LineTime(line string) (time.Time, bool) {
+	timestamp, _, _ := strings.Cut(line, " ")
+	t, err := time.Parse(time.RFC3339, timestamp)
+	return t, err == nil
+}
+
+// queryFilter keeps the last entries selected by a query out of the entries added to it in the
+// order they were written. A line not starting with a time, such as the continuation of a
+// multi-line message, has the time of the line before it.
+type queryFilter struct {
+	query Query
+	last  time.Time
+	ring  []Entry
+	count int
+	// cursor is the cursor of the last entry added
+	cursor string
+}
+
+func newQueryFilter(query Query, lines int) *queryFilter {
+	if lines < 0 {
+		lines = 0
+	}
+	return &queryFilter{query: query, ring: make([]Entry, lines)}
+}
+
+// add adds an entry, returning false once the entries added are past the end of the range,
+// allowing for the lines stored out of order within rangeSlack.
+func (f *queryFilter) add(entry Entry) bool {
+	if t, ok := LineTime(entry.Line); ok {
+		f.last = t
+	}
+	since, until := f.query.Since, f.query.Until
+	if !until.IsZero() && f.last.After(until.Add(rangeSlack)) {
+		return false
+	}
+	f.cursor = entry.Cursor
+	if len(f.ring) == 0 || f.last.Before(since) || (!until.IsZero() && f.last.After(until)) {
+		return true
+	}
+	if f.query.Match != nil && !f.query.Match(entry) {
+		return true
+	}
+	f.ring[f.count%len(f.ring)] = entry
+	f.count++
+	return true
+}
+
+// entries returns the entries kept, oldest first
+func (f *queryFilter) entries() []Entry {
+	if f.count <= len(f.ring) {
+		return append([]Entry{}, f.ring[:f.count]...)
+	}
+	start := f.count % len(f.ring)
+	return append(append([]Entry{}, f.ring[start:]...), f.ring[:start]...)
+}
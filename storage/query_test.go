@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var rangeStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// writeRangeLines writes a line per minute from rangeStart, each followed by a continuation line
+func writeRangeLines(t *testing.T, a Adapter, count int) {
+	for i := 0; i < count; i++ {
+		timestamp := rangeStart.Add(time.Duration(i) * time.Minute).Format("2006-01-02T15:04:05-07:00")
+		assert.NoError(t, a.Write(app, fmt.Sprintf("%s drycc[web.v1]: message %d", timestamp, i)))
+		assert.NoError(t, a.Write(app, fmt.Sprintf("  continuation %d", i)))
+	}
+}
+
+func TestLineTime(t *testing.T) {
+	tm, ok := LineTime("2024-01-01T01:02:03+02:00 drycc[web.v1]: message")
+	assert.True(t, ok)
+	assert.True(t, tm.Equal(time.Date(2024, 1, 1, 1, 2, 3, 0, time.FixedZone("", 2*3600))))
+	_, ok = LineTime("  at main.go:12")
+	assert.False(t, ok)
+}
+
+func testReadQuery(t *testing.T, a Adapter) {
+	writeRangeLines(t, a, 10)
+	// minutes 3 to 5, with their continuation lines
+	entries, _, err := ReadQuery(a, app, Query{Since: rangeStart.Add(3 * time.Minute), Until: rangeStart.Add(5 * time.Minute)}, 100)
+	assert.NoError(t, err)
+	lines := linesOf(entries)
+	assert.Len(t, lines, 6)
+	assert.Contains(t, lines[0], "message 3")
+	assert.Equal(t, "  continuation 5", lines[5])
+
+	// the last lines of the range
+	entries, _, err = ReadQuery(a, app, Query{Since: rangeStart.Add(3 * time.Minute)}, 3)
+	assert.NoError(t, err)
+	lines = linesOf(entries)
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "  continuation 8", lines[0])
+	assert.Equal(t, "  continuation 9", lines[2])
+
+	entries, _, err = ReadQuery(a, app, Query{Until: rangeStart.Add(-time.Minute)}, 100)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// the last matching lines of the range
+	odd := func(entry Entry) bool { return strings.ContainsAny(entry.Line[len(entry.Line)-1:], "13579") }
+	entries, _, err = ReadQuery(a, app, Query{Until: rangeStart.Add(5 * time.Minute), Match: odd}, 3)
+	assert.NoError(t, err)
+	lines = linesOf(entries)
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "  continuation 3", lines[0])
+	assert.Contains(t, lines[1], "message 5")
+	assert.Equal(t, "  continuation 5", lines[2])
+
+	// the cursor is the one of the last line scanned, even when no line matched
+	last, err := ReadEntries(a, app, 1)
+	assert.NoError(t, err)
+	none := func(Entry) bool { return false }
+	entries, cursor, err := ReadQuery(a, app, Query{Since: rangeStart.Add(3 * time.Minute), Match: none}, 3)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	assert.Equal(t, last[0].Cursor, cursor)
+
+	_, _, err = ReadQuery(a, "missing-app", Query{}, 100)
+	assert.Error(t, err)
+}
+
+func TestMemoryQuery(t *testing.T) {
+	a, err := NewMemoryAdapter(100)
+	assert.NoError(t, err)
+	testReadQuery(t, a)
+}
+
+func TestQueryFallback(t *testing.T) {
+	a, err := NewMemoryAdapter(100)
+	assert.NoError(t, err)
+	testReadQuery(t, linesAdapter{a})
+}
+
+func TestFileQuery(t *testing.T) {
+	// rotated every 4 lines
+	a := newTestFileAdapter(t, &fileConfig{MaxSizeBytes: 180, Compress: true})
+	testReadQuery(t, a)
+	a.Stop()
+	segments, err := a.segments(app)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, segments)
+}
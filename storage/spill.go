@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// errSpillFull is returned when spilling a message would take the spill file past its size limit
+var errSpillFull = errors.New("spill file full")
+
+// messageRecord is a message as written to disk, to a spill file or a write-ahead log
+type messageRecord struct {
+	App     string `json:"app"`
+	Message string `json:"message"`
+}
+
+// spillFile is a first in, first out queue of messages on disk, which holds the messages written
+// while the queue of an adapter is full. The messages not read yet are kept across restarts. It is
+// not safe for concurrent use.
+type spillFile struct {
+	path     string
+	maxBytes int64
+	file     *os.File
+	writer   *bufio.Writer
+	// size is the number of bytes written to the file, and offset the number of bytes read
+	size   int64
+	offset int64
+}
+
+// openSpillFile opens the spill file at path, creating it if needed. The messages it holds are read
+// first.
+func openSpillFile(path string, maxBytes int64) (*spillFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	s := &spillFile{path: path, maxBytes: maxBytes, file: file, size: info.Size()}
+	s.writer = bufio.NewWriter(io.NewOffsetWriter(file, s.size))
+	return s, nil
+}
+
+func (s *spillFile) resetWriter() {
+	s.writer.Reset(io.NewOffsetWriter(s.file, s.size))
+}
+
+// pending reports whether the spill file holds messages not read yet
+func (s *spillFile) pending() bool {
+	return s.offset < s.size
+}
+
+// write appends a message to the spill file
+func (s *spillFile) write(m *message) error {
+	data, err := json.Marshal(messageRecord{App: m.app, Message: m.messageBody})
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if s.maxBytes > 0 && s.size-s.offset+int64(len(data)) > s.maxBytes {
+		return errSpillFull
+	}
+	n, err := s.writer.Write(data)
+	s.size += int64(n)
+	return err
+}
+
+// read returns up to n of the messages written first, the file is truncated once they are all read
+func (s *spillFile) read(n int) ([]*message, error) {
+	if err := s.writer.Flush(); err != nil {
+		return nil, err
+	}
+	// the reader is not kept, as it would not read past the end of the file it once reached
+	reader := bufio.NewReader(io.NewSectionReader(s.file, s.offset, s.size-s.offset))
+	var messages []*message
+	for len(messages) < n && s.pending() {
+		data, err := reader.ReadBytes('\n')
+		if err != nil {
+			// a record cut short by a crash is skipped
+			s.offset = s.size
+			break
+		}
+		s.offset += int64(len(data))
+		record := new(messageRecord)
+		if err := json.Unmarshal(data, record); err != nil {
+			continue
+		}
+		messages = append(messages, &message{app: record.App, messageBody: record.Message})
+	}
+	if !s.pending() {
+		if err := s.file.Truncate(0); err != nil {
+			return messages, err
+		}
+		s.size, s.offset = 0, 0
+		s.resetWriter()
+	}
+	return messages, nil
+}
+
+// sync writes the messages buffered to the spill file and syncs it to disk
+func (s *spillFile) sync() error {
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// close closes the spill file, keeping the messages not read yet for them to be read first once
+// it is opened again
+func (s *spillFile) close() error {
+	if err := s.writer.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	if s.offset > 0 {
+		if err := s.compact(); err != nil {
+			s.file.Close()
+			return err
+		}
+	}
+	return s.file.Close()
+}
+
+// compact rewrites the spill file without the messages read
+func (s *spillFile) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, io.NewSectionReader(s.file, s.offset, s.size-s.offset)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -2,27 +2,76 @@ package storage
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	l "log"
+	"strconv"
+	"strings"
 	"time"
 
-	"container/list"
-
 	"github.com/valkey-io/valkey-go"
 	"github.com/valkey-io/valkey-go/valkeycompat"
 )
 
+const (
+	// listSeqKeyPrefix is prepended to the app name to get the key of the number of lines written
+	// to its list, the sequence number of its last line
+	listSeqKeyPrefix = "seq:"
+	// listSeqChannelPrefix is prepended to the app name to get the channel its lines are published
+	// on along with their sequence number
+	listSeqChannelPrefix = "numbered:"
+)
+
+// listWriteScriptBody trims the list of an app to ARGV[2] lines and appends the line ARGV[1] with
+// the next sequence number. The line is published as is on the channel named after the app, as
+// subscribers of earlier releases expect, and prefixed with its number on the channel ARGV[3]. The
+// lines of a list written before lines were numbered are numbered from 1 on.
+const listWriteScriptBody = `
+if redis.call('EXISTS', KEYS[2]) == 0 then
+  redis.call('SET', KEYS[2], redis.call('LLEN', KEYS[1]))
+end
+local seq = redis.call('INCR', KEYS[2])
+redis.call('LTRIM', KEYS[1], -tonumber(ARGV[2]), -1)
+redis.call('RPUSH', KEYS[1], ARGV[1])
+redis.call('PUBLISH', KEYS[1], ARGV[1])
+redis.call('PUBLISH', ARGV[3], string.format('%d', seq) .. ' ' .. ARGV[1])
+return seq
+`
+
+var (
+	listWriteScript     = valkey.NewLuaScript(listWriteScriptBody)
+	listWriteScriptSHA1 = scriptSHA1(listWriteScriptBody)
+)
+
+// listReadScript returns the sequence number of the line before the lines read, the length of
+// the list of an app and up to ARGV[2] lines written after the line numbered ARGV[1], or the last
+// lines when ARGV[1] is empty. Lines no longer held are skipped, as are all lines if ARGV[1] is
+// ahead of the list, which happens once it is destroyed.
+var listReadScript = valkey.NewLuaScript(`
+local length = redis.call('LLEN', KEYS[1])
+local oldest = (tonumber(redis.call('GET', KEYS[2])) or length) - length
+local count = tonumber(ARGV[2])
+local since = tonumber(ARGV[1])
+if since == nil then
+  since = math.max(oldest, oldest + length - count)
+elseif since < oldest or since > oldest + length then
+  since = oldest
+end
+return {since, length, redis.call('LRANGE', KEYS[1], since - oldest, since - oldest + count - 1)}
+`)
+
 type message struct {
 	app         string
 	messageBody string
 }
 
 type valkeyAdapter struct {
-	started        bool
-	bufferSize     int
-	valkeyClient   valkeycompat.Cmdable
-	messageChannel chan *message
-	stopCh         chan struct{}
-	config         *valkeyConfig
+	bufferSize   int
+	client       valkey.Client
+	valkeyClient valkeycompat.Cmdable
+	pipeline     *valkeyPipeline
+	config       *valkeyConfig
 }
 
 // NewValkeyStorageAdapter returns a pointer to a new instance of a valkey-based storage.Adapter.
@@ -35,116 +84,310 @@ func NewValkeyStorageAdapter(bufferSize int) (Adapter, error) {
 		return nil, err
 	}
 
+	pipeline, err := newValkeyPipeline("valkey", bufferSize, cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	client, err := valkey.NewClient(valkey.MustParseURL(cfg.URL))
 
 	if err != nil {
 		return nil, err
 	}
 	rsa := &valkeyAdapter{
-		bufferSize:     bufferSize,
-		valkeyClient:   valkeycompat.NewAdapter(client),
-		messageChannel: make(chan *message, bufferSize),
-		stopCh:         make(chan struct{}),
-		config:         cfg,
+		bufferSize:   bufferSize,
+		client:       client,
+		valkeyClient: valkeycompat.NewAdapter(client),
+		pipeline:     pipeline,
+		config:       cfg,
 	}
+	pipeline.exec = rsa.execPublish
 	return rsa, nil
 }
 
 // Start the storage adapter. Invocations of this function are not concurrency safe and multiple
 // serialized invocations have no effect.
 func (a *valkeyAdapter) Start() {
-	if !a.started {
-		a.started = true
-		ticker := time.NewTicker(a.config.PipelineTimeout)
-		go func() {
-			messages := list.New()
-			for {
-				select {
-				case <-a.stopCh:
-					a.execPublish(messages)
-					return
-				case message := <-a.messageChannel:
-					messages.PushBack(message)
-					if messages.Len() >= a.config.PipelineLength {
-						a.execPublish(messages)
-					}
-				case <-ticker.C:
-					a.execPublish(messages)
-				}
-			}
-		}()
-	}
+	a.pipeline.start()
+}
+
+// listSeqKey returns the key of the sequence number of the list of an app. The app is its hash
+// tag, so that the key hashes to the slot of the list, keyed by the app alone, and the scripts
+// using both keys run on Valkey clusters.
+func listSeqKey(app string) string {
+	return listSeqKeyPrefix + "{" + app + "}"
+}
+
+// listSeqChannel returns the channel the lines of an app are published on along with their
+// sequence number
+func listSeqChannel(app string) string {
+	return listSeqChannelPrefix + app
 }
 
-func (a *valkeyAdapter) execPublish(messages *list.List) {
+// execPublish appends every message to the list of its app and publishes it, in a pipeline of one
+// script per message
+func (a *valkeyAdapter) execPublish(messages []*message) []error {
+	if len(messages) > 0 {
+		pipelineBatchSize.Observe(float64(len(messages)))
+		defer func(start time.Time) {
+			pipelineFlushDuration.Observe(time.Since(start).Seconds())
+		}(time.Now())
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), a.config.PipelineTimeout)
 	defer cancel()
 
-	a.valkeyClient.Pipelined(ctx, func(p valkeycompat.Pipeliner) error {
-		for element := messages.Front(); element != nil; element = element.Next() {
-			if message, ok := element.Value.(*message); ok {
-				p.LTrim(ctx, message.app, int64(-1*a.bufferSize), -1)
-				p.RPush(ctx, message.app, message.messageBody)
-				p.Publish(ctx, message.app, message.messageBody)
-			}
+	execs := make([]valkey.LuaExec, len(messages))
+	for i, message := range messages {
+		execs[i] = valkey.LuaExec{
+			Keys: []string{message.app, listSeqKey(message.app)},
+			Args: []string{message.messageBody, strconv.Itoa(a.bufferSize), listSeqChannel(message.app)},
 		}
-		return nil
-	})
+	}
+	return messageErrors(messages, resultErrors(a.execScriptMulti(ctx, execs)), 1, nil)
+}
 
-	messages.Init()
+// execScriptMulti runs listWriteScript once per exec, in a pipeline of EVALSHA commands. The
+// script is loaded, on every node, only when valkey does not hold it, e.g. after a restart, and
+// the execs that failed for that reason are run again.
+func (a *valkeyAdapter) execScriptMulti(ctx context.Context, execs []valkey.LuaExec) []valkey.ValkeyResult {
+	cmds := make(valkey.Commands, len(execs))
+	for i, exec := range execs {
+		cmds[i] = a.client.B().Evalsha().Sha1(listWriteScriptSHA1).Numkeys(int64(len(exec.Keys))).Key(exec.Keys...).Arg(exec.Args...).Build()
+	}
+	results := a.client.DoMulti(ctx, cmds...)
+	var retries []int
+	for i, result := range results {
+		if err, ok := valkey.IsValkeyErr(result.Error()); ok && err.IsNoScript() {
+			retries = append(retries, i)
+		}
+	}
+	if len(retries) == 0 {
+		return results
+	}
+	retryExecs := make([]valkey.LuaExec, len(retries))
+	for i, j := range retries {
+		retryExecs[i] = execs[j]
+	}
+	for i, result := range listWriteScript.ExecMulti(ctx, a.client, retryExecs...) {
+		results[retries[i]] = result
+	}
+	return results
+}
+
+// scriptSHA1 returns the SHA1 digest scripts are run by with EVALSHA
+func scriptSHA1(script string) string {
+	sum := sha1.Sum([]byte(script))
+	return hex.EncodeToString(sum[:])
 }
 
 // Write adds a log message to to an app-specific list in valkey using ring-buffer-like semantics
 func (a *valkeyAdapter) Write(app string, messageBody string) error {
-	a.messageChannel <- &message{
+	return a.pipeline.queue.write(&message{
 		app:         app,
 		messageBody: messageBody,
-	}
-	return nil
+	})
 }
 
 // Read retrieves a specified number of log lines from an app-specific list in valkey
 func (a *valkeyAdapter) Read(app string, lines int) ([]string, error) {
+	entries, err := a.ReadEntries(app, lines)
+	if err != nil {
+		return nil, err
+	}
+	return linesOf(entries), nil
+}
+
+// ReadEntries is the CursorAdapter interface implementation, the cursor of an entry is its
+// sequence number in the logs of the app
+func (a *valkeyAdapter) ReadEntries(app string, lines int) ([]Entry, error) {
+	return a.readList(app, "", lines)
+}
+
+// ReadSince is the CursorAdapter interface implementation
+func (a *valkeyAdapter) ReadSince(app string, cursor string, lines int) ([]Entry, error) {
+	if _, err := parseSeqCursor(cursor); err != nil {
+		return nil, err
+	}
+	return a.readList(app, cursor, lines)
+}
+
+// readList returns up to lines entries of an app written after the line numbered since, or the
+// last ones when since is empty
+func (a *valkeyAdapter) readList(app string, since string, lines int) ([]Entry, error) {
+	if lines <= 0 {
+		return []Entry{}, nil
+	}
+	entries, length, err := a.readRange(app, since, lines)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return nil, fmt.Errorf("could not find logs for '%s'", app)
+	}
+	return entries, nil
+}
+
+// readRange returns up to lines entries of an app written after the line numbered since, or the
+// last ones when since is empty, and the length of its list
+func (a *valkeyAdapter) readRange(app string, since string, lines int) ([]Entry, int64, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), a.config.PipelineTimeout)
 	defer cancel()
-	stringSliceCmd := a.valkeyClient.LRange(ctx, app, int64(-1*lines), -1)
-	result, err := stringSliceCmd.Result()
+	result, err := listReadScript.Exec(ctx, a.client, []string{app, listSeqKey(app)}, []string{since, strconv.Itoa(lines)}).ToArray()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	if len(result) > 0 {
-		return result, nil
+	if len(result) != 3 {
+		return nil, 0, fmt.Errorf("unexpected reply reading valkey list %s: %d elements", app, len(result))
 	}
-	return nil, fmt.Errorf("could not find logs for '%s'", app)
+	seq, _ := result[0].AsInt64()
+	length, _ := result[1].AsInt64()
+	stored, _ := result[2].ToArray()
+	entries := make([]Entry, len(stored))
+	for i, line := range stored {
+		line, _ := line.ToString()
+		entries[i] = newEntry(strconv.FormatInt(seq+int64(i)+1, 10), line)
+	}
+	return entries, length, nil
 }
 
-// Make Chan a pipeline to read logs all the time
-func (a *valkeyAdapter) Chan(ctx context.Context, app string, size int) (chan string, error) {
-	channel := make(chan string, size)
-
-	go func() {
-		defer close(channel)
-		pubsub := a.valkeyClient.Subscribe(ctx, app)
-		defer pubsub.Close()
-		messages := pubsub.Channel()
-		for len(channel) != size {
-			select {
-			case message := <-messages:
-				channel <- message.Payload
-			case <-ctx.Done():
-				return
+// Query is the QueryAdapter interface implementation. The list is read in pages from its oldest
+// line, so that no more than a page of lines is held besides those selected.
+func (a *valkeyAdapter) Query(app string, query Query, lines int) ([]Entry, string, error) {
+	filter := newQueryFilter(query, lines)
+	since := "0"
+	for {
+		entries, length, err := a.readRange(app, since, queryPageLines)
+		if err != nil {
+			return nil, "", err
+		}
+		if length == 0 {
+			return nil, "", fmt.Errorf("could not find logs for '%s'", app)
+		}
+		for _, entry := range entries {
+			if !filter.add(entry) {
+				return filter.entries(), filter.cursor, nil
 			}
 		}
-	}()
+		if len(entries) < queryPageLines {
+			return filter.entries(), filter.cursor, nil
+		}
+		since = entries[len(entries)-1].Cursor
+	}
+}
+
+// Chan sends the log messages written for an app from now on to a channel, which is closed once
+// the context is done or the channel is full.
+func (a *valkeyAdapter) Chan(ctx context.Context, app string, size int) (chan string, error) {
+	entries, err := a.FollowSince(ctx, app, "", size)
+	if err != nil {
+		return nil, err
+	}
+	return entryLines(ctx, entries), nil
+}
 
+// FollowSince is the CursorAdapter interface implementation. The lines are followed on the channel
+// they are published on along with their sequence number, so the lines written after cursor are read from the list before those
+// published are sent, and the lines missed while the subscription is interrupted are read again.
+func (a *valkeyAdapter) FollowSince(ctx context.Context, app string, cursor string, size int) (chan Entry, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid channel size: %d", size)
+	}
+	var last int64
+	if cursor != "" {
+		var err error
+		if last, err = parseSeqCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+	channel := make(chan Entry, size)
+	go a.follow(ctx, app, last, channel)
 	return channel, nil
 }
 
+// follow sends the entries of an app written after the line numbered last, or from now on when
+// last is 0, to the channel until the context is done or the channel is full, then closes the
+// channel.
+func (a *valkeyAdapter) follow(ctx context.Context, app string, last int64, channel chan Entry) {
+	defer close(channel)
+	pubsub := a.valkeyClient.Subscribe(ctx, listSeqChannel(app))
+	defer pubsub.Close()
+	messages := pubsub.Channel()
+	// send returns false once the channel is closed
+	send := func(entry Entry) bool {
+		select {
+		case channel <- entry:
+		case <-ctx.Done():
+			return false
+		}
+		return len(channel) != cap(channel)
+	}
+	// catchUp sends the entries written after the line numbered last, it returns false once the
+	// channel is closed
+	catchUp := func() bool {
+		for {
+			entries, _, err := a.readRange(app, strconv.FormatInt(last, 10), cap(channel))
+			if err != nil {
+				if ctx.Err() != nil {
+					return false
+				}
+				// the lines missed are read again once the next line is published
+				l.Printf("follow valkey list %s error: %v", app, err)
+				return true
+			}
+			if len(entries) == 0 {
+				return true
+			}
+			for _, entry := range entries {
+				if last, _ = parseSeqCursor(entry.Cursor); !send(entry) {
+					return false
+				}
+			}
+		}
+	}
+	if last > 0 && !catchUp() {
+		return
+	}
+	for {
+		var payload string
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			payload = message.Payload
+		case <-ctx.Done():
+			return
+		}
+		number, stored, ok := strings.Cut(payload, " ")
+		seq, err := strconv.ParseInt(number, 10, 64)
+		if !ok || err != nil {
+			l.Printf("follow valkey list %s error: invalid payload %q", app, payload)
+			continue
+		}
+		switch {
+		case seq <= last:
+			// read from the list already
+			continue
+		case last > 0 && seq > last+1:
+			if !catchUp() {
+				return
+			}
+			if seq <= last {
+				continue
+			}
+		}
+		last = seq
+		if !send(newEntry(number, stored)) {
+			return
+		}
+	}
+}
+
 // Destroy deletes an app-specific list from valkey
 func (a *valkeyAdapter) Destroy(app string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), a.config.PipelineTimeout)
 	defer cancel()
-	return a.valkeyClient.Del(ctx, app).Err()
+	return a.valkeyClient.Del(ctx, app, listSeqKey(app)).Err()
 }
 
 // Reopen the storage adapter-- in the case of this implementation, a no-op
@@ -152,7 +395,13 @@ func (a *valkeyAdapter) Reopen() error {
 	return nil
 }
 
-// Stop the storage adapter. Additional writes may not be performed after stopping.
+// Stop the storage adapter, blocking until the messages written before are flushed, which takes
+// up to the pipeline timeout. Additional writes may not be performed after stopping.
 func (a *valkeyAdapter) Stop() {
-	close(a.stopCh)
+	a.pipeline.stop()
+}
+
+// Sync is the Syncer interface implementation
+func (a *valkeyAdapter) Sync(ctx context.Context) error {
+	return a.pipeline.Sync(ctx)
 }
@@ -3,6 +3,8 @@ package storage
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -177,3 +179,91 @@ func TestValkeyChan(t *testing.T) {
 		t.Error("expected timeout returned null, but found: ", line)
 	}
 }
+
+func TestValkeyCursors(t *testing.T) {
+	a, err := NewValkeyStorageAdapter(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start()
+	defer a.Stop()
+	defer a.Destroy(app)
+	for i := 0; i < 5; i++ {
+		if err := a.Write(app, fmt.Sprintf("message %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	time.Sleep(time.Second * 2)
+	c := a.(CursorAdapter)
+	entries, err := c.ReadEntries(app, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	since, err := c.ReadSince(app, entries[2].Cursor, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(since) != 2 || since[0] != entries[3] || since[1] != entries[4] {
+		t.Errorf("expected the entries after %s, got %v", entries[2].Cursor, since)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	channel, err := c.FollowSince(ctx, app, entries[3].Cursor, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := <-channel; entry != entries[4] {
+		t.Errorf("expected: %v\nactual: %v\n", entries[4], entry)
+	}
+	// the lines published after the catch-up follow it without repeats
+	if err := a.Write(app, "message 5"); err != nil {
+		t.Error(err)
+	}
+	if entry := <-channel; entry.Line != "message 5" || entry.Cursor == entries[4].Cursor {
+		t.Errorf("expected the next line, got %v", entry)
+	}
+}
+
+func TestValkeyQuery(t *testing.T) {
+	a, err := NewValkeyStorageAdapter(2 * queryPageLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start()
+	defer a.Stop()
+	defer a.Destroy(app)
+	// more lines than a page, so that the list is read in pages
+	for i := 0; i < queryPageLines+5; i++ {
+		if err := a.Write(app, fmt.Sprintf("message %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	time.Sleep(time.Second * 2)
+	odd := func(entry Entry) bool { return strings.ContainsAny(entry.Line[len(entry.Line)-1:], "13579") }
+	entries, cursor, err := a.(QueryAdapter).Query(app, Query{Match: odd}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := linesOf(entries)
+	if len(lines) != 2 || lines[0] != fmt.Sprintf("message %d", queryPageLines+1) || lines[1] != fmt.Sprintf("message %d", queryPageLines+3) {
+		t.Errorf("expected the last odd lines, got %v", lines)
+	}
+	// the last line scanned does not match
+	if cursor != strconv.Itoa(queryPageLines+5) {
+		t.Errorf("expected cursor %d, got %s", queryPageLines+5, cursor)
+	}
+}
+
+func TestListSeqKey(t *testing.T) {
+	// the list of an app is keyed by the app, the hash tag of its sequence number key
+	if key := listSeqKey(app); key != "seq:{"+app+"}" {
+		t.Errorf("expected: \"seq:{%s}\", got \"%s\"", app, key)
+	}
+}
+
+func TestScriptSHA1(t *testing.T) {
+	// the digest valkey returns from SCRIPT LOAD "return 1"
+	if sha1 := scriptSHA1("return 1"); sha1 != "e0e1f9fabfc9d4800c877a703b823ac0578ff8db" {
+		t.Errorf("expected: \"e0e1f9fabfc9d4800c877a703b823ac0578ff8db\", got \"%s\"", sha1)
+	}
+}
@@ -15,6 +15,19 @@ type valkeyConfig struct {
 	PipelineLength         int    `envconfig:"DRYCC_VALKEY_PIPELINE_LENGTH" default:"50"`
 	PipelineTimeoutSeconds int    `envconfig:"DRYCC_VALKEY_PIPELINE_TIMEOUT_SECONDS" default:"30"`
 	PipelineTimeout        time.Duration
+	// OverflowPolicy is applied to the messages written while the write queue is full
+	OverflowPolicy string `envconfig:"DRYCC_VALKEY_OVERFLOW_POLICY" default:"block"`
+	SpillDir       string `envconfig:"DRYCC_VALKEY_SPILL_DIR" default:"/data/spill"`
+	SpillMaxBytes  int64  `envconfig:"DRYCC_VALKEY_SPILL_MAX_BYTES" default:"104857600"`
+	// WALDir holds the write-ahead log of the batches not written to valkey yet, none if empty
+	WALDir      string `envconfig:"DRYCC_VALKEY_WAL_DIR" default:""`
+	WALMaxBytes int64  `envconfig:"DRYCC_VALKEY_WAL_MAX_BYTES" default:"1073741824"`
+	// RetryAttempts is the number of times the messages failing for a transient reason are written
+	RetryAttempts         int `envconfig:"DRYCC_VALKEY_RETRY_ATTEMPTS" default:"3"`
+	RetryBackoffMillis    int `envconfig:"DRYCC_VALKEY_RETRY_BACKOFF_MS" default:"100"`
+	RetryBackoffMaxMillis int `envconfig:"DRYCC_VALKEY_RETRY_BACKOFF_MAX_MS" default:"2000"`
+	RetryBackoff          time.Duration
+	RetryBackoffMax       time.Duration
 }
 
 func parseConfig(appName string) (*valkeyConfig, error) {
@@ -23,5 +36,7 @@ func parseConfig(appName string) (*valkeyConfig, error) {
 		return nil, err
 	}
 	ret.PipelineTimeout = time.Duration(ret.PipelineTimeoutSeconds) * time.Second
+	ret.RetryBackoff = time.Duration(ret.RetryBackoffMillis) * time.Millisecond
+	ret.RetryBackoffMax = time.Duration(ret.RetryBackoffMaxMillis) * time.Millisecond
 	return ret, nil
 }
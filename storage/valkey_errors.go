@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/valkey-io/valkey-go"
+	"github.com/valkey-io/valkey-go/valkeycompat"
+)
+
+// permanentReplies are the prefixes of the error replies of valkey which retrying will not fix,
+// as the command itself is invalid: a key holding a value of another type, a script valkey does
+// not hold even once loaded, a command the user may not run, or a malformed command. Other replies,
+// including those not known here, are retried up to RetryAttempts times.
+var permanentReplies = []string{
+	"WRONGTYPE",
+	"NOSCRIPT",
+	"NOPERM",
+	"ERR syntax error",
+	"ERR unknown command",
+	"ERR wrong number of arguments",
+	"ERR value is not",
+}
+
+// permanentError is the error of a message whose commands were rejected by valkey for a reason
+// retrying will not fix, such as a key holding a value of another type
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// permanentReply reports whether an error reply of valkey is not worth retrying
+func permanentReply(reply string) bool {
+	return slices.ContainsFunc(permanentReplies, func(prefix string) bool {
+		return strings.HasPrefix(reply, prefix)
+	})
+}
+
+// cmderErrors returns the errors of the commands of a pipeline
+func cmderErrors(cmds []valkeycompat.Cmder) []error {
+	errs := make([]error, len(cmds))
+	for i, cmd := range cmds {
+		errs[i] = cmd.Err()
+	}
+	return errs
+}
+
+// resultErrors returns the errors of the results of a pipeline
+func resultErrors(results []valkey.ValkeyResult) []error {
+	errs := make([]error, len(results))
+	for i, result := range results {
+		errs[i] = result.Error()
+	}
+	return errs
+}
+
+// messageErrors returns the error of every message of a pipeline, given the errors of its commands,
+// perMessage commands per message in order, or nil when they all succeeded. The messages without
+// commands get the error of the pipeline, and those rejected for a reason retrying will not fix a
+// permanentError.
+func messageErrors(messages []*message, cmdErrs []error, perMessage int, err error) []error {
+	errs := make([]error, len(messages))
+	failed := false
+	for i := range messages {
+		errs[i] = err
+		if (i+1)*perMessage <= len(cmdErrs) {
+			errs[i] = nil
+			for _, cmdErr := range cmdErrs[i*perMessage : (i+1)*perMessage] {
+				if cmdErr != nil && !valkey.IsValkeyNil(cmdErr) {
+					errs[i] = cmdErr
+					break
+				}
+			}
+		}
+		if errs[i] == nil {
+			continue
+		}
+		failed = true
+		if reply, ok := valkey.IsValkeyErr(errs[i]); ok && permanentReply(reply.Error()) {
+			errs[i] = &permanentError{err: errs[i]}
+		}
+	}
+	if !failed {
+		return nil
+	}
+	return errs
+}
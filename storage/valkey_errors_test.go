@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valkey-io/valkey-go/valkeycompat"
+)
+
+func TestPermanentReply(t *testing.T) {
+	assert.True(t, permanentReply("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	assert.True(t, permanentReply("ERR syntax error"))
+	assert.False(t, permanentReply("LOADING Valkey is loading the dataset in memory"))
+	assert.False(t, permanentReply("READONLY You can't write against a read only replica."))
+	assert.False(t, permanentReply("OOM command not allowed when used memory > 'maxmemory'."))
+	assert.True(t, permanentReply("NOSCRIPT No matching script. Please use EVAL."))
+	assert.True(t, permanentReply("ERR wrong number of arguments for 'rpush' command"))
+	// unknown replies are retried
+	assert.False(t, permanentReply("ERR max number of clients reached"))
+	assert.False(t, permanentReply("BUSY Valkey is busy running a script."))
+	assert.False(t, permanentReply("UNKNOWN reply"))
+}
+
+func TestMessageErrors(t *testing.T) {
+	messages := testBatch("foo", 0, 3)
+	cmd := func(err error) valkeycompat.Cmder {
+		c := &valkeycompat.IntCmd{}
+		c.SetErr(err)
+		return c
+	}
+	timeout := errors.New("i/o timeout")
+	assert.Nil(t, messageErrors(messages, []error{nil, nil, nil, nil, nil, nil}, 2, nil))
+	// the commands are matched to their message
+	errs := messageErrors(messages, cmderErrors([]valkeycompat.Cmder{cmd(nil), cmd(nil), cmd(nil), cmd(timeout), cmd(nil), cmd(nil)}), 2, timeout)
+	assert.Equal(t, []error{nil, timeout, nil}, errs)
+	// the messages without commands get the error of the pipeline
+	errs = messageErrors(messages, []error{nil, nil}, 2, timeout)
+	assert.Equal(t, []error{nil, timeout, timeout}, errs)
+}
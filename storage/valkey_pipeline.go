@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	l "log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The reasons messages are dropped for, other than the overflow policies
+const (
+	// dropWALFull is the reason batches are dropped for when the write-ahead log is full
+	dropWALFull = "wal-full"
+	// dropWriteError is the reason batches are dropped for when writing them to valkey failed
+	dropWriteError = "write-error"
+	// dropRejected is the reason messages are dropped for when valkey rejected their commands
+	dropRejected = "rejected"
+)
+
+// valkeyPipeline writes the messages queued for a valkey adapter to valkey, PipelineLength
+// messages per pipeline. With a write-ahead log, the batches that could not be written, such as
+// while valkey is down, are appended to the log and written again in order, each time the queue is
+// flushed, until they are. The batches following them are appended to the log too, to keep their
+// order.
+type valkeyPipeline struct {
+	started bool
+	queue   *writeQueue
+	wal     *wal
+	config  *valkeyConfig
+	// exec writes a batch of messages to valkey, returning the error of every message if any failed
+	exec   func([]*message) []error
+	stopCh chan struct{}
+	done   chan struct{}
+	syncs  chan chan error
+	// err is the first error messages were dropped for since the last sync
+	err error
+}
+
+// newValkeyPipeline returns the pipeline of an adapter, its write-ahead log is opened in a
+// directory of WALDir named after the adapter
+func newValkeyPipeline(adapter string, bufferSize int, cfg *valkeyConfig) (*valkeyPipeline, error) {
+	queue, err := newWriteQueue(adapter, bufferSize, cfg.PipelineLength, cfg)
+	if err != nil {
+		return nil, err
+	}
+	p := &valkeyPipeline{
+		queue:  queue,
+		config: cfg,
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+		syncs:  make(chan chan error),
+	}
+	if cfg.WALDir != "" {
+		w, err := openWAL(filepath.Join(cfg.WALDir, adapter), cfg.WALMaxBytes)
+		if err != nil {
+			queue.close()
+			return nil, fmt.Errorf("error opening write-ahead log: %v", err)
+		}
+		p.wal = w
+	}
+	return p, nil
+}
+
+func (p *valkeyPipeline) start() {
+	if p.started {
+		return
+	}
+	p.started = true
+	ticker := time.NewTicker(p.config.PipelineTimeout)
+	go func() {
+		defer close(p.done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stopCh:
+				p.queue.close()
+				p.flush(p.queue.len())
+				p.closeWAL()
+				return
+			case <-p.queue.ready:
+				p.flush(p.queue.len())
+			case <-ticker.C:
+				p.flush(p.queue.len())
+			case result := <-p.syncs:
+				p.flush(p.queue.len())
+				result <- p.sync()
+			}
+		}
+	}()
+}
+
+// flush writes up to n queued messages to valkey, after the batches of the write-ahead log. Once
+// writing a batch of the log fails, the others are left in the log for the next flush.
+func (p *valkeyPipeline) flush(n int) {
+	replay := p.wal != nil
+	for n > 0 {
+		messages := p.queue.take(min(n, p.config.PipelineLength))
+		if len(messages) == 0 {
+			break
+		}
+		p.writeBatch(messages)
+		if replay {
+			replay = p.replay()
+		}
+		n -= len(messages)
+	}
+	if replay {
+		p.replay()
+	}
+}
+
+// writeBatch writes a batch to valkey, or appends it to the write-ahead log while the log holds
+// batches not written. The messages which could not be written are appended to the log, or dropped
+// without one. A batch which does not fit in the log is dropped.
+func (p *valkeyPipeline) writeBatch(messages []*message) {
+	if p.wal == nil || !p.wal.pending() {
+		failed, err := p.send(messages)
+		if err == nil {
+			return
+		}
+		if p.wal == nil {
+			p.drop(failed, dropWriteError, fmt.Errorf("error writing to valkey: %v", err))
+			return
+		}
+		messages = failed
+	}
+	if err := p.wal.append(messages); err != nil {
+		if !errors.Is(err, errWALFull) {
+			l.Printf("error appending to write-ahead log: %v", err)
+		}
+		p.drop(messages, dropWALFull, err)
+	}
+}
+
+// replay writes the batches of the write-ahead log to valkey in order, it returns false once one
+// could not be written
+func (p *valkeyPipeline) replay() bool {
+	for p.wal.pending() {
+		messages, err := p.wal.peek()
+		if err != nil {
+			l.Printf("error reading write-ahead log: %v", err)
+			return false
+		}
+		if messages == nil {
+			break
+		}
+		if failed, err := p.send(messages); err != nil {
+			l.Printf("error writing %d lines of %s to valkey, keeping %d bytes in the write-ahead log: %v",
+				len(failed), appsOf(failed), p.wal.size(), err)
+			return false
+		}
+		if err := p.wal.commit(); err != nil {
+			l.Printf("error committing write-ahead log: %v", err)
+			return false
+		}
+	}
+	return true
+}
+
+// send writes a batch to valkey, writing the messages which failed for a transient reason again
+// after a backoff, up to RetryAttempts times in all. The messages rejected for a permanent reason
+// are dropped, as writing them again would fail as well. It returns the messages still failing
+// and their last error.
+func (p *valkeyPipeline) send(messages []*message) ([]*message, error) {
+	for attempt := 1; ; attempt++ {
+		errs := p.exec(messages)
+		if errs == nil {
+			return nil, nil
+		}
+		var failed, rejected []*message
+		var failedErr, rejectedErr error
+		for i, err := range errs {
+			switch {
+			case err == nil:
+			case errors.As(err, new(*permanentError)):
+				rejected, rejectedErr = append(rejected, messages[i]), err
+			default:
+				failed, failedErr = append(failed, messages[i]), err
+			}
+		}
+		if len(rejected) > 0 {
+			p.countErrors(rejected, "permanent")
+			l.Printf("valkey rejected %d lines of %s: %v", len(rejected), appsOf(rejected), rejectedErr)
+			droppedLines.WithLabelValues(p.queue.adapter, dropRejected).Add(float64(len(rejected)))
+		}
+		if len(failed) == 0 {
+			return nil, nil
+		}
+		p.countErrors(failed, "transient")
+		if attempt >= p.config.RetryAttempts {
+			return failed, failedErr
+		}
+		pipelineRetries.WithLabelValues(p.queue.adapter).Inc()
+		time.Sleep(Backoff(attempt, p.config.RetryBackoff, p.config.RetryBackoffMax))
+		messages = failed
+	}
+}
+
+// countErrors counts the messages whose commands failed, their apps are logged rather than
+// labelled, as apps are not bounded
+func (p *valkeyPipeline) countErrors(messages []*message, kind string) {
+	valkeyCommandErrors.WithLabelValues(p.queue.adapter, kind).Add(float64(len(messages)))
+}
+
+// appsOf lists the apps of messages with their number of messages, for logs
+func appsOf(messages []*message) string {
+	counts := make(map[string]int)
+	for _, m := range messages {
+		counts[m.app]++
+	}
+	apps := make([]string, 0, len(counts))
+	for app, count := range counts {
+		apps = append(apps, fmt.Sprintf("%s (%d)", app, count))
+	}
+	slices.Sort(apps)
+	return strings.Join(apps, ", ")
+}
+
+// drop counts a batch as dropped, the error is returned by the next sync
+func (p *valkeyPipeline) drop(messages []*message, reason string, err error) {
+	l.Printf("dropped %d lines of %s: %v", len(messages), appsOf(messages), err)
+	droppedLines.WithLabelValues(p.queue.adapter, reason).Add(float64(len(messages)))
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// sync returns the error messages were dropped for since the last sync, once the spill file is
+// synced to disk
+func (p *valkeyPipeline) sync() error {
+	err := p.err
+	p.err = nil
+	if spillErr := p.queue.sync(); spillErr != nil && err == nil {
+		err = fmt.Errorf("error syncing spill file: %v", spillErr)
+	}
+	return err
+}
+
+// Sync is the Syncer interface implementation. It flushes the queue, the messages written before
+// are stored in valkey, in the write-ahead log or in the spill file once it returns nil.
+func (p *valkeyPipeline) Sync(ctx context.Context) error {
+	result := make(chan error, 1)
+	select {
+	case p.syncs <- result:
+	case <-p.done:
+		return errQueueClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *valkeyPipeline) closeWAL() {
+	if p.wal != nil {
+		if err := p.wal.close(); err != nil {
+			l.Printf("error closing write-ahead log: %v", err)
+		}
+	}
+}
+
+// stop blocks until the messages written before are flushed, which takes up to the pipeline
+// timeout
+func (p *valkeyPipeline) stop() {
+	close(p.stopCh)
+	if p.started {
+		<-p.done
+	} else {
+		p.queue.close()
+		p.closeWAL()
+	}
+}
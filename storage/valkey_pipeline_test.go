@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeValkey records the messages written, failing while it is down. The messages of the apps
+// rejected fail for a permanent reason, and those of the apps flaky for a transient one, as many
+// times as set.
+type fakeValkey struct {
+	mu       sync.Mutex
+	down     bool
+	rejected map[string]bool
+	flaky    map[string]int
+	written  []string
+}
+
+func (f *fakeValkey) exec(messages []*message) []error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	errs := make([]error, len(messages))
+	failed := false
+	for i, m := range messages {
+		switch {
+		case f.down:
+			errs[i] = errors.New("connection refused")
+		case f.rejected[m.app]:
+			errs[i] = &permanentError{err: errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")}
+		case f.flaky[m.app] > 0:
+			f.flaky[m.app]--
+			errs[i] = errors.New("LOADING Valkey is loading the dataset in memory")
+		default:
+			f.written = append(f.written, messageBodies([]*message{m})...)
+			continue
+		}
+		failed = true
+	}
+	if !failed {
+		return nil
+	}
+	return errs
+}
+
+func (f *fakeValkey) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *fakeValkey) lines() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.written...)
+}
+
+func newTestPipeline(t *testing.T, valkey *fakeValkey, walDir string, walMaxBytes int64) *valkeyPipeline {
+	p, err := newValkeyPipeline("test", 10, &valkeyConfig{
+		PipelineLength:  2,
+		PipelineTimeout: time.Hour,
+		RetryAttempts:   3,
+		RetryBackoff:    time.Millisecond,
+		RetryBackoffMax: time.Millisecond,
+		OverflowPolicy:  OverflowBlock,
+		WALDir:          walDir,
+		WALMaxBytes:     walMaxBytes,
+	})
+	assert.NoError(t, err)
+	p.exec = valkey.exec
+	p.start()
+	return p
+}
+
+func TestValkeyPipelineWAL(t *testing.T) {
+	dir := t.TempDir()
+	valkey := &fakeValkey{down: true}
+	p := newTestPipeline(t, valkey, dir, 0)
+	writeTestMessages(t, p.queue, "foo", 3)
+	// the batches not written are kept in the write-ahead log
+	assert.NoError(t, p.Sync(context.Background()))
+	assert.Empty(t, valkey.lines())
+	p.stop()
+
+	// and written in order once valkey is back, after a restart
+	p = newTestPipeline(t, valkey, dir, 0)
+	defer p.stop()
+	writeTestMessages(t, p.queue, "bar", 1)
+	assert.NoError(t, p.Sync(context.Background()))
+	assert.Empty(t, valkey.lines())
+	valkey.setDown(false)
+	assert.NoError(t, p.Sync(context.Background()))
+	assert.Equal(t, []string{"foo 0", "foo 1", "foo 2", "bar 0"}, valkey.lines())
+}
+
+func TestValkeyPipelineWALUnused(t *testing.T) {
+	dir := t.TempDir()
+	valkey := &fakeValkey{}
+	p := newTestPipeline(t, valkey, dir, 0)
+	defer p.stop()
+	writeTestMessages(t, p.queue, "foo", 3)
+	assert.NoError(t, p.Sync(context.Background()))
+	assert.Equal(t, []string{"foo 0", "foo 1", "foo 2"}, valkey.lines())
+	// the batches written to valkey are not appended to the write-ahead log
+	assert.False(t, p.wal.pending())
+	info, err := os.Stat(filepath.Join(dir, "test", fmt.Sprintf("%020d.wal", 0)))
+	assert.NoError(t, err)
+	assert.Zero(t, info.Size())
+}
+
+func TestValkeyPipelineWALFull(t *testing.T) {
+	valkey := &fakeValkey{down: true}
+	p := newTestPipeline(t, valkey, t.TempDir(), 100)
+	defer p.stop()
+	writeTestMessages(t, p.queue, "foo", 1)
+	assert.NoError(t, p.Sync(context.Background()))
+	// the batches which do not fit in the log are dropped
+	writeTestMessages(t, p.queue, "foo", 2)
+	assert.ErrorIs(t, p.Sync(context.Background()), errWALFull)
+	assert.NoError(t, p.Sync(context.Background()))
+	valkey.setDown(false)
+	assert.NoError(t, p.Sync(context.Background()))
+	assert.Equal(t, []string{"foo 0"}, valkey.lines())
+}
+
+func TestValkeyPipelineWithoutWAL(t *testing.T) {
+	valkey := &fakeValkey{down: true}
+	p := newTestPipeline(t, valkey, "", 0)
+	writeTestMessages(t, p.queue, "foo", 1)
+	assert.EqualError(t, p.Sync(context.Background()), "error writing to valkey: connection refused")
+	valkey.setDown(false)
+	writeTestMessages(t, p.queue, "foo", 1)
+	assert.NoError(t, p.Sync(context.Background()))
+	assert.Equal(t, []string{"foo 0"}, valkey.lines())
+	p.stop()
+	assert.ErrorIs(t, p.Sync(context.Background()), errQueueClosed)
+}
+
+func TestValkeyPipelineRetry(t *testing.T) {
+	valkey := &fakeValkey{rejected: map[string]bool{"bar": true}, flaky: map[string]int{"foo": 2}}
+	transient := testutil.ToFloat64(valkeyCommandErrors.WithLabelValues("test", "transient"))
+	permanent := testutil.ToFloat64(valkeyCommandErrors.WithLabelValues("test", "permanent"))
+	p := newTestPipeline(t, valkey, "", 0)
+	defer p.stop()
+	writeTestMessages(t, p.queue, "foo", 1)
+	writeTestMessages(t, p.queue, "bar", 1)
+	writeTestMessages(t, p.queue, "baz", 1)
+	// the lines failing for a transient reason are written again, those rejected are dropped
+	// without failing the sync, as writing them again would not help
+	assert.NoError(t, p.Sync(context.Background()))
+	assert.ElementsMatch(t, []string{"baz 0", "foo 0"}, valkey.lines())
+	assert.Equal(t, transient+2, testutil.ToFloat64(valkeyCommandErrors.WithLabelValues("test", "transient")))
+	assert.Equal(t, permanent+1, testutil.ToFloat64(valkeyCommandErrors.WithLabelValues("test", "permanent")))
+
+	// once the attempts are exhausted, the lines are dropped
+	valkey.flaky["foo"] = 3
+	writeTestMessages(t, p.queue, "foo", 1)
+	assert.EqualError(t, p.Sync(context.Background()), "error writing to valkey: LOADING Valkey is loading the dataset in memory")
+	assert.ElementsMatch(t, []string{"baz 0", "foo 0"}, valkey.lines())
+}
@@ -0,0 +1,320 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	l "log"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/valkey-io/valkey-go"
+	"github.com/valkey-io/valkey-go/valkeycompat"
+)
+
+const (
+	// streamKeyPrefix is prepended to the app name to get the key of its stream, so that the
+	// streams do not collide with the lists of the valkey adapter
+	streamKeyPrefix = "logs:"
+	// streamMessageField is the field of the stream entries holding the log line
+	streamMessageField = "message"
+	// streamBlockTimeout bounds a blocking read, so that a follower notices its context is done
+	streamBlockTimeout = 5 * time.Second
+	// streamRetryInterval is how long a follower waits before reading again after an error
+	streamRetryInterval = time.Second
+)
+
+// streamIDPattern matches the IDs of stream entries, which are the cursors of the adapter
+var streamIDPattern = regexp.MustCompile(`^\d+-\d+$`)
+
+type valkeyStreamAdapter struct {
+	bufferSize   int
+	valkeyClient valkeycompat.Cmdable
+	pipeline     *valkeyPipeline
+	config       *valkeyConfig
+}
+
+// NewValkeyStreamStorageAdapter returns a pointer to a new instance of a storage.Adapter keeping
+// the logs of every app in a valkey stream trimmed to about bufferSize entries.
+func NewValkeyStreamStorageAdapter(bufferSize int) (Adapter, error) {
+	if bufferSize <= 0 {
+		return nil, fmt.Errorf("invalid buffer size: %d", bufferSize)
+	}
+	cfg, err := parseConfig(appName)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline, err := newValkeyPipeline("valkey-stream", bufferSize, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := valkey.NewClient(valkey.MustParseURL(cfg.URL))
+
+	if err != nil {
+		return nil, err
+	}
+	a := &valkeyStreamAdapter{
+		bufferSize:   bufferSize,
+		valkeyClient: valkeycompat.NewAdapter(client),
+		pipeline:     pipeline,
+		config:       cfg,
+	}
+	pipeline.exec = a.execAdd
+	return a, nil
+}
+
+func streamKey(app string) string {
+	return streamKeyPrefix + app
+}
+
+// Start the storage adapter. Invocations of this function are not concurrency safe and multiple
+// serialized invocations have no effect.
+func (a *valkeyStreamAdapter) Start() {
+	a.pipeline.start()
+}
+
+// execAdd adds every message to the stream of its app, in a pipeline of one command per message
+func (a *valkeyStreamAdapter) execAdd(messages []*message) []error {
+	if len(messages) > 0 {
+		pipelineBatchSize.Observe(float64(len(messages)))
+		defer func(start time.Time) {
+			pipelineFlushDuration.Observe(time.Since(start).Seconds())
+		}(time.Now())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.PipelineTimeout)
+	defer cancel()
+
+	cmds, err := a.valkeyClient.Pipelined(ctx, func(p valkeycompat.Pipeliner) error {
+		for _, message := range messages {
+			p.XAdd(ctx, valkeycompat.XAddArgs{
+				Stream: streamKey(message.app),
+				MaxLen: int64(a.bufferSize),
+				Approx: true,
+				ID:     "*",
+				Values: map[string]interface{}{streamMessageField: message.messageBody},
+			})
+		}
+		return nil
+	})
+	return messageErrors(messages, cmderErrors(cmds), 1, err)
+}
+
+// Write adds a log message to an app-specific stream in valkey
+func (a *valkeyStreamAdapter) Write(app string, messageBody string) error {
+	return a.pipeline.queue.write(&message{
+		app:         app,
+		messageBody: messageBody,
+	})
+}
+
+// Read retrieves a specified number of log lines from an app-specific stream in valkey
+func (a *valkeyStreamAdapter) Read(app string, lines int) ([]string, error) {
+	entries, err := a.ReadEntries(app, lines)
+	if err != nil {
+		return nil, err
+	}
+	return linesOf(entries), nil
+}
+
+// ReadEntries is the CursorAdapter interface implementation, the cursor of an entry is its ID in
+// the stream
+func (a *valkeyStreamAdapter) ReadEntries(app string, lines int) ([]Entry, error) {
+	if lines <= 0 {
+		return []Entry{}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.PipelineTimeout)
+	defer cancel()
+	messages, err := a.valkeyClient.XRevRangeN(ctx, streamKey(app), "+", "-", int64(lines)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("could not find logs for '%s'", app)
+	}
+	// the most recent entries come first
+	entries := make([]Entry, len(messages))
+	for i, message := range messages {
+		entries[len(messages)-1-i] = streamEntry(message)
+	}
+	return entries, nil
+}
+
+// ReadSince is the CursorAdapter interface implementation
+func (a *valkeyStreamAdapter) ReadSince(app string, cursor string, lines int) ([]Entry, error) {
+	if !streamIDPattern.MatchString(cursor) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, cursor)
+	}
+	if lines <= 0 {
+		return []Entry{}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.PipelineTimeout)
+	defer cancel()
+	exists, err := a.valkeyClient.Exists(ctx, streamKey(app)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, fmt.Errorf("could not find logs for '%s'", app)
+	}
+	// ( makes the start of the range exclusive
+	messages, err := a.valkeyClient.XRangeN(ctx, streamKey(app), "("+cursor, "+", int64(lines)).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(messages))
+	for i, message := range messages {
+		entries[i] = streamEntry(message)
+	}
+	return entries, nil
+}
+
+// Query is the QueryAdapter interface implementation. The IDs of stream entries are the time they
+// were added, which is at most rangeSlack later than the time in their line, so the entries are
+// read from the start of the range until rangeSlack after its end, in pages, so that no more than
+// a page of entries is held besides those selected.
+func (a *valkeyStreamAdapter) Query(app string, query Query, lines int) ([]Entry, string, error) {
+	start, end := "-", "+"
+	if !query.Since.IsZero() {
+		start = strconv.FormatInt(query.Since.UnixMilli(), 10)
+	}
+	if !query.Until.IsZero() {
+		end = strconv.FormatInt(query.Until.Add(rangeSlack).UnixMilli(), 10)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.PipelineTimeout)
+	defer cancel()
+	exists, err := a.valkeyClient.Exists(ctx, streamKey(app)).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	if exists == 0 {
+		return nil, "", fmt.Errorf("could not find logs for '%s'", app)
+	}
+	filter := newQueryFilter(query, lines)
+	for {
+		messages, err := a.valkeyClient.XRangeN(ctx, streamKey(app), start, end, queryPageLines).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		for _, message := range messages {
+			if !filter.add(streamEntry(message)) {
+				return filter.entries(), filter.cursor, nil
+			}
+		}
+		if len(messages) < queryPageLines {
+			return filter.entries(), filter.cursor, nil
+		}
+		// ( makes the start of the range exclusive
+		start = "(" + messages[len(messages)-1].ID
+	}
+}
+
+func streamEntry(message valkeycompat.XMessage) Entry {
+	line, _ := message.Values[streamMessageField].(string)
+	return newEntry(message.ID, line)
+}
+
+// Chan sends the log messages written for an app from now on to a channel, which is closed once
+// the context is done or the channel is full.
+func (a *valkeyStreamAdapter) Chan(ctx context.Context, app string, size int) (chan string, error) {
+	entries, err := a.FollowSince(ctx, app, "", size)
+	if err != nil {
+		return nil, err
+	}
+	return entryLines(ctx, entries), nil
+}
+
+// FollowSince is the CursorAdapter interface implementation. The stream is read from the last
+// entry sent, so no entry is missed when the connection to valkey is interrupted.
+func (a *valkeyStreamAdapter) FollowSince(ctx context.Context, app string, cursor string, size int) (chan Entry, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid channel size: %d", size)
+	}
+	if cursor == "" {
+		var err error
+		if cursor, err = a.lastID(ctx, app); err != nil {
+			return nil, err
+		}
+	} else if !streamIDPattern.MatchString(cursor) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, cursor)
+	}
+	channel := make(chan Entry, size)
+	go a.follow(ctx, app, cursor, channel)
+	return channel, nil
+}
+
+// lastID returns the ID of the most recent entry of an app stream, or 0-0 if there is none. It is
+// resolved up front rather than reading from $, which would skip the entries added between reads.
+func (a *valkeyStreamAdapter) lastID(ctx context.Context, app string) (string, error) {
+	messages, err := a.valkeyClient.XRevRangeN(ctx, streamKey(app), "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(messages) == 0 {
+		return "0-0", nil
+	}
+	return messages[0].ID, nil
+}
+
+// follow sends the entries of an app stream added after lastID to the channel until the context
+// is done or the channel is full, then closes the channel.
+func (a *valkeyStreamAdapter) follow(ctx context.Context, app, lastID string, channel chan Entry) {
+	defer close(channel)
+	xReadArgs := valkeycompat.XReadArgs{
+		Streams: []string{streamKey(app), lastID},
+		Count:   int64(cap(channel)),
+		Block:   streamBlockTimeout,
+	}
+	for ctx.Err() == nil {
+		streams, err := a.valkeyClient.XRead(ctx, xReadArgs).Result()
+		if err != nil && !valkey.IsValkeyNil(err) {
+			if ctx.Err() != nil {
+				return
+			}
+			l.Printf("follow valkey stream %s error: %v", streamKey(app), err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(streamRetryInterval):
+			}
+			continue
+		}
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				select {
+				case channel <- streamEntry(message):
+				case <-ctx.Done():
+					return
+				}
+				xReadArgs.Streams[1] = message.ID
+				if len(channel) == cap(channel) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Destroy deletes an app-specific stream from valkey
+func (a *valkeyStreamAdapter) Destroy(app string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.PipelineTimeout)
+	defer cancel()
+	return a.valkeyClient.Del(ctx, streamKey(app)).Err()
+}
+
+// Reopen the storage adapter-- in the case of this implementation, a no-op
+func (a *valkeyStreamAdapter) Reopen() error {
+	return nil
+}
+
+// Stop the storage adapter, blocking until the messages written before are flushed, which takes
+// up to the pipeline timeout. Additional writes may not be performed after stopping.
+func (a *valkeyStreamAdapter) Stop() {
+	a.pipeline.stop()
+}
+
+// Sync is the Syncer interface implementation
+func (a *valkeyStreamAdapter) Sync(ctx context.Context) error {
+	return a.pipeline.Sync(ctx)
+}
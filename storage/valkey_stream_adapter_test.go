@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valkey-io/valkey-go/valkeycompat"
+)
+
+func TestValkeyStreamReadFromNonExistingApp(t *testing.T) {
+	a, err := NewValkeyStreamStorageAdapter(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// No logs have been written; there should be no valkey stream for app
+	messages, err := a.Read(app, 10)
+	if messages != nil {
+		t.Error("expected no messages, but got some")
+	}
+	if err == nil || err.Error() != fmt.Sprintf("could not find logs for '%s'", app) {
+		t.Error("did not receive expected error message")
+	}
+}
+
+func TestValkeyStreamWithBadBufferSizes(t *testing.T) {
+	for _, size := range []int{-1, 0} {
+		a, err := NewValkeyStreamStorageAdapter(size)
+		if a != nil {
+			t.Error("expected no storage adapter, but got one")
+		}
+		if err == nil || err.Error() != fmt.Sprintf("invalid buffer size: %d", size) {
+			t.Error("did not receive expected error message")
+		}
+	}
+}
+
+func TestValkeyStreamLogs(t *testing.T) {
+	a, err := NewValkeyStreamStorageAdapter(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start()
+	defer a.Stop()
+	defer a.Destroy(app)
+	for i := 0; i < 5; i++ {
+		if err := a.Write(app, fmt.Sprintf("message %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	// Sleep for a bit because the adapter queues logs internally and writes them to Valkey only when
+	// there are 50 queued up OR a 1 second timeout has been reached.
+	time.Sleep(time.Second * 2)
+	// Read more logs than there are
+	messages, err := a.Read(app, 8)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(messages) != 5 {
+		t.Errorf("only expected 5 log messages, got %d", len(messages))
+	}
+	// Read fewer logs than there are, they should be the 3 MOST RECENT, oldest first
+	messages, err = a.Read(app, 3)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("only expected 3 log messages, got %d", len(messages))
+	}
+	for i := 0; i < 3; i++ {
+		expectedMessage := fmt.Sprintf("message %d", i+2)
+		if messages[i] != expectedMessage {
+			t.Errorf("expected: \"%s\", got \"%s\"", expectedMessage, messages[i])
+		}
+	}
+}
+
+func TestValkeyStreamDestroy(t *testing.T) {
+	a, err := NewValkeyStreamStorageAdapter(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start()
+	defer a.Stop()
+	if err := a.Write(app, "Hello, log!"); err != nil {
+		t.Error(err)
+	}
+	time.Sleep(time.Second * 2)
+	ctx := context.Background()
+	client := a.(*valkeyStreamAdapter).valkeyClient
+	exists, err := client.Exists(ctx, streamKey(app)).Result()
+	if err != nil {
+		t.Error(err)
+	}
+	if exists != 1 {
+		t.Error("Log valkey stream was expected to exist, but doesn't.")
+	}
+	if err := a.Destroy(app); err != nil {
+		t.Error(err)
+	}
+	exists, err = client.Exists(ctx, streamKey(app)).Result()
+	if err != nil {
+		t.Error(err)
+	}
+	if exists == 1 {
+		t.Error("Log valkey stream still exist, but was expected not to.")
+	}
+}
+
+func TestValkeyStreamChan(t *testing.T) {
+	a, err := NewValkeyStreamStorageAdapter(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start()
+	defer a.Stop()
+	defer a.Destroy(app)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	// entries written before the follow starts are not sent
+	client := a.(*valkeyStreamAdapter).valkeyClient
+	client.XAdd(ctx, valkeycompat.XAddArgs{
+		Stream: streamKey(app),
+		ID:     "*",
+		Values: map[string]interface{}{streamMessageField: "before"},
+	})
+	channel, err := a.Chan(ctx, app, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// entries added between the reads of the follower are not missed, as they would be from $
+	for i := 0; i < 10; i++ {
+		client.XAdd(ctx, valkeycompat.XAddArgs{
+			Stream: streamKey(app),
+			ID:     "*",
+			Values: map[string]interface{}{streamMessageField: fmt.Sprintf("Hello, log %d !", i)},
+		})
+	}
+	for i := 0; i < 10; i++ {
+		line := <-channel
+		expected := fmt.Sprintf("Hello, log %d !", i)
+		if line != expected {
+			t.Error("the log content does not match the expectation.", expected, line)
+		}
+	}
+	cancel()
+	if line := <-channel; line != "" {
+		t.Error("expected the channel to be closed, but found: ", line)
+	}
+}
+
+func TestValkeyStreamCursors(t *testing.T) {
+	a, err := NewValkeyStreamStorageAdapter(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start()
+	defer a.Stop()
+	defer a.Destroy(app)
+	for i := 0; i < 5; i++ {
+		if err := a.Write(app, fmt.Sprintf("message %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	time.Sleep(time.Second * 2)
+	c := a.(CursorAdapter)
+	entries, err := c.ReadEntries(app, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	since, err := c.ReadSince(app, entries[2].Cursor, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(since) != 2 || since[0] != entries[3] || since[1] != entries[4] {
+		t.Errorf("expected the entries after %s, got %v", entries[2].Cursor, since)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	channel, err := c.FollowSince(ctx, app, entries[3].Cursor, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := <-channel; entry != entries[4] {
+		t.Errorf("expected: %v\nactual: %v\n", entries[4], entry)
+	}
+}
+
+func TestValkeyStreamQuery(t *testing.T) {
+	a, err := NewValkeyStreamStorageAdapter(2 * queryPageLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start()
+	defer a.Stop()
+	defer a.Destroy(app)
+	// more entries than a page, so that the stream is read in pages
+	for i := 0; i < queryPageLines+5; i++ {
+		if err := a.Write(app, fmt.Sprintf("message %d", i)); err != nil {
+			t.Error(err)
+		}
+	}
+	time.Sleep(time.Second * 2)
+	odd := func(entry Entry) bool { return strings.ContainsAny(entry.Line[len(entry.Line)-1:], "13579") }
+	entries, cursor, err := a.(QueryAdapter).Query(app, Query{Match: odd}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := linesOf(entries)
+	if len(lines) != 2 || lines[0] != fmt.Sprintf("message %d", queryPageLines+1) || lines[1] != fmt.Sprintf("message %d", queryPageLines+3) {
+		t.Errorf("expected the last odd lines, got %v", lines)
+	}
+	// the last entry scanned does not match
+	last, err := ReadEntries(a, app, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor != last[0].Cursor {
+		t.Errorf("expected cursor %s, got %s", last[0].Cursor, cursor)
+	}
+}
@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	l "log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	walSegmentSuffix  = ".wal"
+	walCheckpointFile = "checkpoint"
+	// walRecordHeader is the size of the header of a record, its length and checksum
+	walRecordHeader = 8
+)
+
+// walSegmentBytes is the size past which the segment written is rotated, a var for tests
+var walSegmentBytes int64 = 16 << 20
+
+// errWALFull is returned when appending a batch would take the log past its size limit
+var errWALFull = errors.New("write-ahead log full")
+
+// walPosition is the position of a record in the log
+type walPosition struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// wal is a write-ahead log of the batches written to valkey. A batch is appended, and synced to
+// disk, once writing it failed, or while the batches appended before are not written, and
+// committed once written. The batches not committed, such as those written while valkey is down,
+// are kept across restarts. The log is a sequence of segment
+// files, those whose batches are all committed are removed. It is not safe for concurrent use.
+type wal struct {
+	dir      string
+	maxBytes int64
+	// segments are the segment files in order, the last one is appended to
+	segments []uint64
+	sizes    map[uint64]int64
+	file     *os.File
+	// reader is the segment batches are read from, kept open until the log moves past it
+	reader        *os.File
+	readerSegment uint64
+	// head is the position of the first batch not committed, next the position after it
+	head walPosition
+	next walPosition
+}
+
+// openWAL opens the write-ahead log in dir, creating it if needed
+func openWAL(dir string, maxBytes int64) (*wal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	w := &wal{dir: dir, maxBytes: maxBytes, sizes: make(map[uint64]int64)}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), walSegmentSuffix)
+		if !ok {
+			continue
+		}
+		segment, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+		w.segments = append(w.segments, segment)
+		w.sizes[segment] = info.Size()
+	}
+	slices.Sort(w.segments)
+	if data, err := os.ReadFile(filepath.Join(dir, walCheckpointFile)); err == nil {
+		if err := json.Unmarshal(data, &w.head); err != nil {
+			return nil, fmt.Errorf("invalid write-ahead log checkpoint: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(w.segments) == 0 {
+		w.segments = []uint64{w.head.Segment}
+	} else if w.head.Segment < w.segments[0] {
+		w.head = walPosition{Segment: w.segments[0]}
+	}
+	if err := w.removeCommitted(); err != nil {
+		return nil, err
+	}
+	if err := w.truncateTail(); err != nil {
+		return nil, err
+	}
+	tail := w.segments[len(w.segments)-1]
+	if w.file, err = os.OpenFile(w.segmentPath(tail), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// truncateTail cuts a batch cut short or corrupted off the end of the segment appended to, as a
+// crash may leave it, else the batches appended after it would be skipped along with it
+func (w *wal) truncateTail() error {
+	tail := w.segments[len(w.segments)-1]
+	file, err := os.OpenFile(w.segmentPath(tail), os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var valid int64
+	for valid < w.sizes[tail] {
+		_, size, err := readRecord(io.NewSectionReader(file, valid, w.sizes[tail]-valid))
+		if err != nil {
+			break
+		}
+		valid += size
+	}
+	if valid == w.sizes[tail] {
+		return nil
+	}
+	l.Printf("truncating write-ahead log segment %d from %d to %d bytes, after its last whole batch",
+		tail, w.sizes[tail], valid)
+	if err := file.Truncate(valid); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	w.sizes[tail] = valid
+	if w.head.Segment == tail && w.head.Offset > valid {
+		w.head.Offset = valid
+	}
+	return nil
+}
+
+func (w *wal) segmentPath(segment uint64) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%020d%s", segment, walSegmentSuffix))
+}
+
+// size returns the number of bytes of the batches not committed
+func (w *wal) size() int64 {
+	var size int64
+	for _, segment := range w.segments {
+		size += w.sizes[segment]
+	}
+	return size - w.head.Offset
+}
+
+// pending reports whether the log holds batches not committed
+func (w *wal) pending() bool {
+	tail := w.segments[len(w.segments)-1]
+	return w.head.Segment != tail || w.head.Offset < w.sizes[tail]
+}
+
+// append appends a batch to the log and syncs it to disk
+func (w *wal) append(messages []*message) error {
+	records := make([]messageRecord, len(messages))
+	for i, m := range messages {
+		records[i] = messageRecord{App: m.app, Message: m.messageBody}
+	}
+	payload, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, walRecordHeader, walRecordHeader+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+	if w.maxBytes > 0 && w.size()+int64(len(record)) > w.maxBytes {
+		return errWALFull
+	}
+	tail := w.segments[len(w.segments)-1]
+	if w.sizes[tail] >= walSegmentBytes {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+		tail = w.segments[len(w.segments)-1]
+	}
+	n, err := w.file.Write(record)
+	w.sizes[tail] += int64(n)
+	if err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// rotate starts a new segment
+func (w *wal) rotate() error {
+	segment := w.segments[len(w.segments)-1] + 1
+	file, err := os.OpenFile(w.segmentPath(segment), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		l.Printf("error closing write-ahead log segment: %v", err)
+	}
+	w.file = file
+	w.segments = append(w.segments, segment)
+	w.sizes[segment] = 0
+	return nil
+}
+
+// peek returns the first batch not committed. A batch cut short or corrupted, as a crash may leave
+// the end of a segment before the one appended to, is skipped along with the rest of its segment.
+func (w *wal) peek() ([]*message, error) {
+	for w.pending() {
+		tail := w.segments[len(w.segments)-1]
+		if w.head.Offset >= w.sizes[w.head.Segment] && w.head.Segment != tail {
+			w.head = walPosition{Segment: w.nextSegment(w.head.Segment)}
+			continue
+		}
+		messages, size, err := w.readSegment(w.head)
+		if err == nil {
+			w.next = walPosition{Segment: w.head.Segment, Offset: w.head.Offset + size}
+			return messages, nil
+		}
+		l.Printf("skipping corrupted write-ahead log segment %d at %d: %v", w.head.Segment, w.head.Offset, err)
+		if w.head.Segment == tail {
+			if err := w.rotate(); err != nil {
+				return nil, err
+			}
+		}
+		w.head = walPosition{Segment: w.nextSegment(w.head.Segment)}
+	}
+	return nil, nil
+}
+
+// nextSegment returns the segment following a segment of the log
+func (w *wal) nextSegment(segment uint64) uint64 {
+	i, _ := slices.BinarySearch(w.segments, segment)
+	return w.segments[i+1]
+}
+
+// readSegment returns the batch of the record at a position, and the size of the record
+func (w *wal) readSegment(position walPosition) ([]*message, int64, error) {
+	if w.reader == nil || w.readerSegment != position.Segment {
+		w.closeReader()
+		file, err := os.Open(w.segmentPath(position.Segment))
+		if err != nil {
+			return nil, 0, err
+		}
+		w.reader, w.readerSegment = file, position.Segment
+	}
+	return readRecord(io.NewSectionReader(w.reader, position.Offset, w.sizes[position.Segment]-position.Offset))
+}
+
+// closeReader closes the segment read from
+func (w *wal) closeReader() {
+	if w.reader != nil {
+		w.reader.Close()
+		w.reader = nil
+	}
+}
+
+// readRecord returns the batch of the record at the start of a section of a segment, and the size
+// of the record
+func readRecord(section *io.SectionReader) ([]*message, int64, error) {
+	header := make([]byte, walRecordHeader)
+	if _, err := io.ReadFull(section, header); err != nil {
+		return nil, 0, err
+	}
+	length := int64(binary.BigEndian.Uint32(header))
+	if length > section.Size()-walRecordHeader {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(section, payload); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+	var records []messageRecord
+	if err := json.Unmarshal(payload, &records); err != nil {
+		return nil, 0, err
+	}
+	messages := make([]*message, len(records))
+	for i, record := range records {
+		messages[i] = &message{app: record.App, messageBody: record.Message}
+	}
+	return messages, walRecordHeader + length, nil
+}
+
+// commit commits the batch returned by peek, removing the segments it was the last batch of. The
+// checkpoint is synced to disk before it replaces the previous one, and the directory after, so
+// that a crash leaves either checkpoint whole.
+func (w *wal) commit() error {
+	w.head = w.next
+	data, err := json.Marshal(w.head)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(w.dir, walCheckpointFile+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(w.dir, walCheckpointFile)); err != nil {
+		return err
+	}
+	if err := syncDir(w.dir); err != nil {
+		return err
+	}
+	return w.removeCommitted()
+}
+
+// writeFileSync writes a file and syncs it to disk
+func writeFileSync(name string, data []byte) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir syncs a directory to disk, so that the files created, renamed or removed in it are
+// found as they are after a crash
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// removeCommitted removes the segments before the segment of the first batch not committed
+func (w *wal) removeCommitted() error {
+	for len(w.segments) > 1 && w.segments[0] < w.head.Segment {
+		if w.reader != nil && w.readerSegment == w.segments[0] {
+			w.closeReader()
+		}
+		if err := os.Remove(w.segmentPath(w.segments[0])); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(w.sizes, w.segments[0])
+		w.segments = w.segments[1:]
+	}
+	return nil
+}
+
+// close closes the segments appended to and read from
+func (w *wal) close() error {
+	w.closeReader()
+	return w.file.Close()
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testBatch(app string, start, count int) []*message {
+	var messages []*message
+	for i := start; i < start+count; i++ {
+		messages = append(messages, &message{app: app, messageBody: fmt.Sprintf("%s %d", app, i)})
+	}
+	return messages
+}
+
+// commitNext returns the bodies of the first batch not committed, and commits it
+func commitNext(t *testing.T, w *wal) []string {
+	messages, err := w.peek()
+	assert.NoError(t, err)
+	if messages == nil {
+		return nil
+	}
+	assert.NoError(t, w.commit())
+	return messageBodies(messages)
+}
+
+func TestWAL(t *testing.T) {
+	defer func(size int64) { walSegmentBytes = size }(walSegmentBytes)
+	walSegmentBytes = 100
+	dir := t.TempDir()
+	w, err := openWAL(dir, 0)
+	assert.NoError(t, err)
+	assert.False(t, w.pending())
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, w.append(testBatch("foo", i*2, 2)))
+	}
+	assert.True(t, w.pending())
+	assert.Equal(t, []string{"foo 0", "foo 1"}, commitNext(t, w))
+	// a batch peeked is returned again until it is committed
+	messages, err := w.peek()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo 2", "foo 3"}, messageBodies(messages))
+	assert.NoError(t, w.close())
+
+	// the batches not committed are kept across restarts
+	w, err = openWAL(dir, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo 2", "foo 3"}, commitNext(t, w))
+	assert.Equal(t, []string{"foo 4", "foo 5"}, commitNext(t, w))
+	assert.Equal(t, []string{"foo 6", "foo 7"}, commitNext(t, w))
+	assert.Nil(t, commitNext(t, w))
+	assert.False(t, w.pending())
+	// the segments committed are removed
+	segments, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	assert.NoError(t, err)
+	assert.Len(t, segments, 1)
+	assert.NoError(t, w.close())
+}
+
+func TestWALFull(t *testing.T) {
+	w, err := openWAL(t.TempDir(), 100)
+	assert.NoError(t, err)
+	defer w.close()
+	assert.NoError(t, w.append(testBatch("foo", 0, 1)))
+	assert.ErrorIs(t, w.append(testBatch("foo", 1, 2)), errWALFull)
+	// committing a batch frees its room
+	assert.Equal(t, []string{"foo 0"}, commitNext(t, w))
+	assert.NoError(t, w.append(testBatch("foo", 1, 2)))
+}
+
+func TestWALCorrupted(t *testing.T) {
+	defer func(size int64) { walSegmentBytes = size }(walSegmentBytes)
+	walSegmentBytes = 100
+	dir := t.TempDir()
+	w, err := openWAL(dir, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, w.append(testBatch("foo", 0, 2)))
+	assert.NoError(t, w.append(testBatch("foo", 2, 2)))
+	assert.NoError(t, w.append(testBatch("foo", 4, 2)))
+	assert.NoError(t, w.close())
+
+	// a batch cut short by a crash is skipped along with the rest of its segment
+	segment := filepath.Join(dir, fmt.Sprintf("%020d.wal", 0))
+	info, err := os.Stat(segment)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Truncate(segment, info.Size()-1))
+	w, err = openWAL(dir, 0)
+	assert.NoError(t, err)
+	defer w.close()
+	assert.Equal(t, []string{"foo 0", "foo 1"}, commitNext(t, w))
+	assert.Equal(t, []string{"foo 4", "foo 5"}, commitNext(t, w))
+	assert.Nil(t, commitNext(t, w))
+
+	// the batches appended after are not skipped
+	assert.NoError(t, w.append(testBatch("foo", 6, 1)))
+	assert.Equal(t, []string{"foo 6"}, commitNext(t, w))
+}
+
+func TestWALTornTail(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(dir, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, w.append(testBatch("foo", 0, 1)))
+	assert.NoError(t, w.append(testBatch("foo", 1, 1)))
+	assert.NoError(t, w.close())
+
+	// the batch cut short at the end of the segment appended to is truncated on open
+	segment := filepath.Join(dir, fmt.Sprintf("%020d.wal", 0))
+	info, err := os.Stat(segment)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Truncate(segment, info.Size()-1))
+	w, err = openWAL(dir, 0)
+	assert.NoError(t, err)
+	defer w.close()
+
+	// the batches appended after are not skipped along with it
+	assert.NoError(t, w.append(testBatch("foo", 2, 1)))
+	assert.Equal(t, []string{"foo 0"}, commitNext(t, w))
+	assert.Equal(t, []string{"foo 2"}, commitNext(t, w))
+	assert.Nil(t, commitNext(t, w))
+}
@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	l "log"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The policies applied to the messages written while the queue of an adapter is full
+const (
+	// OverflowBlock blocks the writer until there is room in the queue
+	OverflowBlock = "block"
+	// OverflowDropNewest drops the newest message of the app with the most messages queued
+	OverflowDropNewest = "drop-newest"
+	// OverflowDropOldest drops the oldest message of the app with the most messages queued
+	OverflowDropOldest = "drop-oldest"
+	// OverflowSpill writes the messages to a file on disk, to be queued once there is room
+	OverflowSpill = "spill-to-disk"
+)
+
+// errQueueClosed is returned by writes to a queue once its adapter is stopped
+var errQueueClosed = errors.New("storage adapter stopped")
+
+// writeQueue holds the messages written to an adapter until they are flushed. Once it holds its
+// capacity, the messages written are handled by its overflow policy. The app with the most
+// messages queued gives way to the others, so that an app logging heavily does not cause the logs
+// of quiet apps to be dropped, and apps take turns when messages are taken.
+type writeQueue struct {
+	mu       sync.Mutex
+	notFull  *sync.Cond
+	adapter  string
+	policy   string
+	capacity int
+	// length is the number of queued messages ready is signaled at
+	length int
+	ready  chan struct{}
+	size   int
+	apps   map[string][]*message
+	// order lists the apps with queued messages, in the order they take turns from next
+	order []string
+	// dropped counts the messages dropped per app since the last marker
+	dropped map[string]int
+	spill   *spillFile
+	closed  bool
+}
+
+// newWriteQueue returns a queue of capacity messages, signaling ready once it holds length
+// messages. Messages are spilled to a file in spillDir with the spill-to-disk policy.
+func newWriteQueue(adapter string, capacity, length int, cfg *valkeyConfig) (*writeQueue, error) {
+	q := &writeQueue{
+		adapter:  adapter,
+		policy:   cfg.OverflowPolicy,
+		capacity: capacity,
+		length:   length,
+		ready:    make(chan struct{}, 1),
+		apps:     make(map[string][]*message),
+		dropped:  make(map[string]int),
+	}
+	q.notFull = sync.NewCond(&q.mu)
+	switch q.policy {
+	case OverflowBlock, OverflowDropNewest, OverflowDropOldest:
+	case OverflowSpill:
+		spill, err := openSpillFile(filepath.Join(cfg.SpillDir, adapter+".spill"), cfg.SpillMaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("error opening spill file: %v", err)
+		}
+		q.spill = spill
+		q.refill()
+	default:
+		return nil, fmt.Errorf("invalid overflow policy: %s", q.policy)
+	}
+	return q, nil
+}
+
+// write queues a message, applying the overflow policy when the queue is full
+func (q *writeQueue) write(m *message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return errQueueClosed
+	}
+	if q.spill != nil && (q.spill.pending() || q.size >= q.capacity) {
+		// the messages spilled are queued first, for the messages of an app to stay in order
+		if err := q.spill.write(m); err != nil {
+			if !errors.Is(err, errSpillFull) {
+				l.Printf("error spilling message: %v", err)
+			}
+			q.drop(m.app)
+		}
+		return nil
+	}
+	for q.size >= q.capacity {
+		switch q.policy {
+		case OverflowDropNewest, OverflowDropOldest:
+			app := q.largest(m.app)
+			if app == m.app && q.policy == OverflowDropNewest {
+				q.drop(app)
+				return nil
+			}
+			q.remove(app, q.policy == OverflowDropOldest)
+			q.drop(app)
+		default:
+			q.notFull.Wait()
+			if q.closed {
+				return errQueueClosed
+			}
+		}
+	}
+	q.push(m)
+	return nil
+}
+
+// push queues a message, the queue must have room for it
+func (q *writeQueue) push(m *message) {
+	if len(q.apps[m.app]) == 0 {
+		q.order = append(q.order, m.app)
+	}
+	q.apps[m.app] = append(q.apps[m.app], m)
+	q.size++
+	if q.size >= q.length {
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// largest returns the app with the most messages queued, app when it has as many as any other
+func (q *writeQueue) largest(app string) string {
+	largest := app
+	for other, messages := range q.apps {
+		if len(messages) > len(q.apps[largest]) {
+			largest = other
+		}
+	}
+	return largest
+}
+
+// remove removes the oldest or newest message queued for an app
+func (q *writeQueue) remove(app string, oldest bool) {
+	messages := q.apps[app]
+	if oldest {
+		messages[0] = nil
+		messages = messages[1:]
+	} else {
+		messages = messages[:len(messages)-1]
+	}
+	q.apps[app] = messages
+	q.size--
+	if len(messages) == 0 {
+		delete(q.apps, app)
+		for i, other := range q.order {
+			if other == app {
+				q.order = append(q.order[:i], q.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// drop counts a message of an app as dropped
+func (q *writeQueue) drop(app string) {
+	q.dropped[app]++
+	droppedLines.WithLabelValues(q.adapter, q.policy).Inc()
+}
+
+// take removes up to n messages from the queue, the apps taking turns. A marker reporting the
+// number of messages dropped is appended for every app whose messages were dropped since the last
+// one.
+func (q *writeQueue) take(n int) []*message {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var messages []*message
+	for len(messages) < n && len(q.order) > 0 {
+		app := q.order[0]
+		messages = append(messages, q.apps[app][0])
+		q.remove(app, true)
+		// the app takes its next turn after the others, unless it has no more messages queued
+		if len(q.order) > 0 && q.order[0] == app {
+			q.order = append(q.order[1:], app)
+		}
+	}
+	for app, count := range q.dropped {
+		messages = append(messages, droppedMarker(app, count))
+		delete(q.dropped, app)
+	}
+	if q.spill != nil {
+		q.refill()
+	}
+	if len(messages) > 0 {
+		q.notFull.Broadcast()
+	}
+	return messages
+}
+
+// refill queues the messages spilled, as many as there is room for
+func (q *writeQueue) refill() {
+	if !q.spill.pending() || q.size >= q.capacity {
+		return
+	}
+	messages, err := q.spill.read(q.capacity - q.size)
+	if err != nil {
+		l.Printf("error reading spill file: %v", err)
+	}
+	for _, m := range messages {
+		q.push(m)
+	}
+}
+
+// len returns the number of messages queued
+func (q *writeQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// sync syncs the spill file to disk
+func (q *writeQueue) sync() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.spill == nil {
+		return nil
+	}
+	return q.spill.sync()
+}
+
+// close fails the writes from now on, including those blocked, and closes the spill file. The
+// messages queued may still be taken.
+func (q *writeQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notFull.Broadcast()
+	if q.spill != nil {
+		if err := q.spill.close(); err != nil {
+			l.Printf("error closing spill file: %v", err)
+		}
+		q.spill = nil
+	}
+}
+
+// droppedMarker returns the message written to the logs of an app in place of the messages
+// dropped
+func droppedMarker(app string, count int) *message {
+	now := time.Now()
+	line := fmt.Sprintf("%s drycc[logger]: %d lines dropped", now.Format(TimeFormat), count)
+	return &message{
+		app:         app,
+		messageBody: EncodeLine(line, Metadata{Time: now, Process: "logger", Level: "warn"}),
+	}
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWriteQueue(t *testing.T, policy string, capacity int, spillDir string) *writeQueue {
+	q, err := newWriteQueue("test", capacity, capacity, &valkeyConfig{
+		OverflowPolicy: policy,
+		SpillDir:       spillDir,
+		SpillMaxBytes:  1024,
+	})
+	assert.NoError(t, err)
+	return q
+}
+
+func writeTestMessages(t *testing.T, q *writeQueue, app string, count int) {
+	for i := 0; i < count; i++ {
+		assert.NoError(t, q.write(&message{app: app, messageBody: fmt.Sprintf("%s %d", app, i)}))
+	}
+}
+
+// messageBodies returns the bodies of messages, with the markers reduced to their message
+func messageBodies(messages []*message) []string {
+	var bodies []string
+	for _, m := range messages {
+		line, metadata := decodeLine(m.messageBody)
+		if metadata.Process == "logger" {
+			_, text, _ := strings.Cut(line, "drycc[logger]: ")
+			line = m.app + ": " + text
+		}
+		bodies = append(bodies, line)
+	}
+	return bodies
+}
+
+func TestDroppedMarker(t *testing.T) {
+	line, _ := decodeLine(droppedMarker("foo", 2).messageBody)
+	timestamp, text, _ := strings.Cut(line, " ")
+	assert.Equal(t, "drycc[logger]: 2 lines dropped", text)
+	// the time is written as by the log package, with the offset of UTC rather than Z
+	_, err := time.Parse(TimeFormat, timestamp)
+	assert.NoError(t, err)
+	assert.NotContains(t, timestamp, "Z")
+}
+
+func TestWriteQueueInvalidPolicy(t *testing.T) {
+	_, err := newWriteQueue("test", 1, 1, &valkeyConfig{OverflowPolicy: "bogus"})
+	assert.EqualError(t, err, "invalid overflow policy: bogus")
+}
+
+func TestWriteQueueTakeTurns(t *testing.T) {
+	q := newTestWriteQueue(t, OverflowBlock, 10, "")
+	writeTestMessages(t, q, "foo", 3)
+	writeTestMessages(t, q, "bar", 1)
+	assert.Equal(t, 4, q.len())
+	assert.Equal(t, []string{"foo 0", "bar 0", "foo 1"}, messageBodies(q.take(3)))
+	assert.Equal(t, []string{"foo 2"}, messageBodies(q.take(3)))
+	assert.Nil(t, q.take(3))
+}
+
+func TestWriteQueueDropNewest(t *testing.T) {
+	q := newTestWriteQueue(t, OverflowDropNewest, 4, "")
+	writeTestMessages(t, q, "foo", 6)
+	// the app with the most messages queued gives way to the others
+	writeTestMessages(t, q, "bar", 2)
+	assert.Equal(t, []string{"foo 0", "bar 0", "foo 1", "bar 1", "foo: 4 lines dropped"}, messageBodies(q.take(10)))
+	// the marker is written once
+	writeTestMessages(t, q, "foo", 1)
+	assert.Equal(t, []string{"foo 0"}, messageBodies(q.take(10)))
+}
+
+func TestWriteQueueDropOldest(t *testing.T) {
+	q := newTestWriteQueue(t, OverflowDropOldest, 4, "")
+	writeTestMessages(t, q, "foo", 6)
+	assert.Equal(t, []string{"foo 2", "foo 3", "foo 4", "foo 5", "foo: 2 lines dropped"}, messageBodies(q.take(10)))
+}
+
+func TestWriteQueueBlock(t *testing.T) {
+	q := newTestWriteQueue(t, OverflowBlock, 1, "")
+	writeTestMessages(t, q, "foo", 1)
+	written := make(chan error)
+	go func() {
+		written <- q.write(&message{app: "foo", messageBody: "foo 1"})
+	}()
+	select {
+	case <-written:
+		t.Fatal("expected the write to block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, []string{"foo 0"}, messageBodies(q.take(1)))
+	assert.NoError(t, <-written)
+
+	go func() {
+		written <- q.write(&message{app: "foo", messageBody: "foo 2"})
+	}()
+	// blocked writes fail once the adapter is stopped
+	q.close()
+	assert.ErrorIs(t, <-written, errQueueClosed)
+	assert.ErrorIs(t, q.write(&message{app: "foo"}), errQueueClosed)
+	assert.Equal(t, []string{"foo 1"}, messageBodies(q.take(1)))
+}
+
+func TestWriteQueueSpill(t *testing.T) {
+	dir := t.TempDir()
+	q := newTestWriteQueue(t, OverflowSpill, 2, dir)
+	writeTestMessages(t, q, "foo", 4)
+	// the messages spilled are queued once there is room, in order
+	assert.Equal(t, []string{"foo 0"}, messageBodies(q.take(1)))
+	writeTestMessages(t, q, "bar", 1)
+	assert.Equal(t, []string{"foo 1", "foo 2"}, messageBodies(q.take(2)))
+
+	writeTestMessages(t, q, "baz", 2)
+
+	// the messages queued may be taken once stopped, those spilled are kept across restarts
+	q.close()
+	assert.Equal(t, []string{"foo 3", "bar 0"}, messageBodies(q.take(10)))
+	q = newTestWriteQueue(t, OverflowSpill, 2, dir)
+	assert.Equal(t, []string{"baz 0", "baz 1"}, messageBodies(q.take(10)))
+	q.close()
+
+	// once the spill file is full, messages are dropped
+	q = newTestWriteQueue(t, OverflowSpill, 1, dir)
+	defer q.close()
+	assert.NoError(t, q.write(&message{app: "foo", messageBody: "queued"}))
+	large := strings.Repeat("x", 600)
+	assert.NoError(t, q.write(&message{app: "foo", messageBody: large}))
+	assert.NoError(t, q.write(&message{app: "foo", messageBody: large}))
+	assert.Equal(t, []string{"queued", "foo: 1 lines dropped"}, messageBodies(q.take(1)))
+	assert.Equal(t, []string{large}, messageBodies(q.take(1)))
+	assert.FileExists(t, filepath.Join(dir, "test.spill"))
+}
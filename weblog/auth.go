@@ -0,0 +1,218 @@
+package weblog
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+// The actions a scope grants on the logs of an app
+const (
+	// ActionRead allows reading and streaming logs
+	ActionRead = "read"
+	// ActionDelete allows deleting logs
+	ActionDelete = "delete"
+	// ActionAdmin allows listing and replaying dead letters, which are not bound to an app
+	ActionAdmin = "admin"
+)
+
+// ErrUnauthenticated is returned by authenticators for tokens they do not accept
+var ErrUnauthenticated = errors.New("invalid token")
+
+// Principal is who a token identifies, along with the scopes granted to it. A scope is either
+// "admin" or "<action>:<app>", where app may be "*" for every app, e.g. "read:foo" or "delete:*".
+type Principal struct {
+	Name   string
+	Scopes []string
+}
+
+// Allows reports whether the scopes of a principal grant an action on the logs of an app
+func (p *Principal) Allows(action, app string) bool {
+	if action == ActionAdmin {
+		return slices.Contains(p.Scopes, ActionAdmin)
+	}
+	return slices.Contains(p.Scopes, action+":"+app) || slices.Contains(p.Scopes, action+":*")
+}
+
+// Authenticator authenticates the bearer tokens of weblog requests.
+type Authenticator interface {
+	// Authenticate returns the principal a token identifies. It returns ErrUnauthenticated when the
+	// token is not accepted, and other errors when it could not be checked.
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+// authenticators tries each of its authenticators in turn, a token is accepted by the first one
+// accepting it
+type authenticators []Authenticator
+
+func (a authenticators) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	var lastErr error
+	for _, authenticator := range a {
+		principal, err := authenticator.Authenticate(ctx, token)
+		if err == nil {
+			return principal, nil
+		}
+		if !errors.Is(err, ErrUnauthenticated) {
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, ErrUnauthenticated
+}
+
+// staticToken is a long-lived token configured along with its scopes
+type staticToken struct {
+	Name   string   `yaml:"name"`
+	Token  string   `yaml:"token"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// staticTokens authenticates the tokens of its list
+type staticTokens []staticToken
+
+func (s staticTokens) Authenticate(_ context.Context, token string) (*Principal, error) {
+	for _, t := range s {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return &Principal{Name: t.Name, Scopes: t.Scopes}, nil
+		}
+	}
+	return nil, ErrUnauthenticated
+}
+
+// AuthConfig is the on-disk representation of the authenticators of the weblog API. Each section
+// given enables an authenticator.
+//
+// Example auth file (YAML, or the equivalent JSON):
+//
+//	tokens:
+//	- name: dashboard
+//	  token: 5f0e8a...
+//	  scopes: ["read:*"]
+//	hmac:
+//	  secret: 9b1c4d...
+//	tokenReview:
+//	  audiences: ["logger"]
+//	  users:
+//	    system:serviceaccount:drycc:drycc-controller: ["read:*", "delete:*", "admin"]
+type AuthConfig struct {
+	Tokens      []staticToken      `yaml:"tokens"`
+	HMAC        *hmacConfig        `yaml:"hmac"`
+	TokenReview *tokenReviewConfig `yaml:"tokenReview"`
+}
+
+// LoadAuthenticator reads the authenticators of the weblog API from a YAML or JSON file. The
+// TokenReview authenticator reviews tokens with the Kubernetes API server the logger runs in.
+func LoadAuthenticator(path string) (Authenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := new(AuthConfig)
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("error parsing auth config %s: %v", path, err)
+	}
+	var reviewer TokenReviewer
+	if cfg.TokenReview != nil {
+		if reviewer, err = newInClusterTokenReviewer(cfg.TokenReview.Audiences); err != nil {
+			return nil, err
+		}
+	}
+	return newAuthenticator(cfg, reviewer)
+}
+
+// newAuthenticator returns the authenticators enabled by an auth config, reviewing tokens with
+// reviewer when TokenReview is enabled
+func newAuthenticator(cfg *AuthConfig, reviewer TokenReviewer) (Authenticator, error) {
+	var a authenticators
+	if len(cfg.Tokens) > 0 {
+		for _, t := range cfg.Tokens {
+			if t.Token == "" {
+				return nil, fmt.Errorf("token %s: empty token", t.Name)
+			}
+		}
+		a = append(a, staticTokens(cfg.Tokens))
+	}
+	if cfg.HMAC != nil {
+		if cfg.HMAC.Secret == "" {
+			return nil, errors.New("hmac: empty secret")
+		}
+		a = append(a, &hmacAuthenticator{secret: []byte(cfg.HMAC.Secret)})
+	}
+	if cfg.TokenReview != nil {
+		a = append(a, &tokenReviewAuthenticator{reviewer: reviewer, config: cfg.TokenReview})
+	}
+	if len(a) == 0 {
+		return nil, errors.New("no authenticator configured")
+	}
+	return a, nil
+}
+
+// bearerToken returns the bearer token of a request, given in the Authorization header or, for
+// clients unable to set headers such as browser EventSources and WebSockets, the access_token query
+// parameter
+func bearerToken(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// setAuthenticator replaces the authenticator of the requests, nil allows every request
+func (h requestHandler) setAuthenticator(authenticator Authenticator) {
+	h.authenticator.Store(&authenticator)
+}
+
+func (h requestHandler) getAuthenticator() Authenticator {
+	if h.authenticator == nil {
+		return nil
+	}
+	if authenticator := h.authenticator.Load(); authenticator != nil {
+		return *authenticator
+	}
+	return nil
+}
+
+// authorize is a middleware allowing the requests whose token grants an action on the app of the
+// request, all requests are allowed when no authenticator is configured
+func (h requestHandler) authorize(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authenticator := h.getAuthenticator()
+		if authenticator == nil {
+			next(w, r)
+			return
+		}
+		token := bearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="logger"`)
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		principal, err := authenticator.Authenticate(r.Context(), token)
+		if errors.Is(err, ErrUnauthenticated) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="logger", error="invalid_token"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "could not authenticate token", http.StatusServiceUnavailable)
+			return
+		}
+		if app := mux.Vars(r)["app"]; !principal.Allows(action, app) {
+			http.Error(w, fmt.Sprintf("%s is not allowed to %s %s", principal.Name, action, r.URL.Path), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,182 @@
+package weblog
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drycc/logger/storage"
+)
+
+type fakeTokenReviewer struct {
+	statuses map[string]*TokenReviewStatus
+	err      error
+}
+
+func (f *fakeTokenReviewer) Review(_ context.Context, token string) (*TokenReviewStatus, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if status, ok := f.statuses[token]; ok {
+		return status, nil
+	}
+	return &TokenReviewStatus{}, nil
+}
+
+// signToken returns a JSON Web Token signed as the drycc controller does
+func signToken(a *hmacAuthenticator, alg string, claims hmacClaims) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return input + "." + base64.RawURLEncoding.EncodeToString(a.sign(input))
+}
+
+func TestPrincipalAllows(t *testing.T) {
+	p := &Principal{Name: "test", Scopes: []string{"read:foo", "delete:*"}}
+	assert.True(t, p.Allows(ActionRead, "foo"))
+	assert.False(t, p.Allows(ActionRead, "bar"))
+	assert.True(t, p.Allows(ActionDelete, "bar"))
+	assert.False(t, p.Allows(ActionAdmin, ""))
+	assert.True(t, (&Principal{Scopes: []string{"admin"}}).Allows(ActionAdmin, ""))
+}
+
+func TestStaticTokens(t *testing.T) {
+	a := staticTokens{{Name: "dashboard", Token: "secret", Scopes: []string{"read:*"}}}
+	principal, err := a.Authenticate(context.Background(), "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "dashboard", Scopes: []string{"read:*"}}, principal)
+	_, err = a.Authenticate(context.Background(), "other")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	a := &hmacAuthenticator{secret: []byte("secret"), now: func() time.Time { return now }}
+	claims := hmacClaims{Subject: "alice", Scope: "read:foo delete:foo", ExpiresAt: now.Add(time.Minute).Unix()}
+
+	principal, err := a.Authenticate(context.Background(), signToken(a, "HS256", claims))
+	assert.NoError(t, err)
+	assert.Equal(t, &Principal{Name: "alice", Scopes: []string{"read:foo", "delete:foo"}}, principal)
+
+	other := &hmacAuthenticator{secret: []byte("other")}
+	tests := map[string]string{
+		"malformed":     "not.a-token",
+		"bad signature": signToken(other, "HS256", claims),
+		"wrong alg":     signToken(a, "none", claims),
+		"expired":       signToken(a, "HS256", hmacClaims{Subject: "alice", ExpiresAt: now.Unix()}),
+		"no expiry":     signToken(a, "HS256", hmacClaims{Subject: "alice"}),
+		"not valid yet": signToken(a, "HS256", hmacClaims{Subject: "alice", ExpiresAt: claims.ExpiresAt, NotBefore: now.Add(time.Second).Unix()}),
+	}
+	for name, token := range tests {
+		_, err := a.Authenticate(context.Background(), token)
+		assert.ErrorIs(t, err, ErrUnauthenticated, name)
+	}
+}
+
+func TestTokenReviewAuthenticator(t *testing.T) {
+	reviewer := &fakeTokenReviewer{statuses: map[string]*TokenReviewStatus{
+		"controller": {Authenticated: true, Username: "system:serviceaccount:drycc:drycc-controller", Groups: []string{"system:serviceaccounts"}},
+	}}
+	a := &tokenReviewAuthenticator{reviewer: reviewer, config: &tokenReviewConfig{
+		Users:  map[string][]string{"system:serviceaccount:drycc:drycc-controller": {"admin"}},
+		Groups: map[string][]string{"system:serviceaccounts": {"read:*"}},
+	}}
+	principal, err := a.Authenticate(context.Background(), "controller")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"admin", "read:*"}, principal.Scopes)
+	_, err = a.Authenticate(context.Background(), "unknown")
+	assert.ErrorIs(t, err, ErrUnauthenticated)
+
+	reviewer.err = errors.New("api server unavailable")
+	_, err = a.Authenticate(context.Background(), "controller")
+	assert.EqualError(t, err, "api server unavailable")
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	_, err := newAuthenticator(&AuthConfig{}, nil)
+	assert.EqualError(t, err, "no authenticator configured")
+	_, err = newAuthenticator(&AuthConfig{Tokens: []staticToken{{Name: "empty"}}}, nil)
+	assert.EqualError(t, err, "token empty: empty token")
+	_, err = newAuthenticator(&AuthConfig{HMAC: &hmacConfig{}}, nil)
+	assert.EqualError(t, err, "hmac: empty secret")
+
+	path := filepath.Join(t.TempDir(), "auth.yaml")
+	config := "tokens:\n- name: dashboard\n  token: secret\n  scopes: [\"read:*\"]\nhmac:\n  secret: shared\n"
+	assert.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+	a, err := LoadAuthenticator(path)
+	assert.NoError(t, err)
+	principal, err := a.Authenticate(context.Background(), "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "dashboard", principal.Name)
+}
+
+func TestAuthorize(t *testing.T) {
+	storageAdapter, err := storage.NewAdapter("memory", 10)
+	assert.NoError(t, err)
+	assert.NoError(t, storageAdapter.Write("foo", "hello"))
+	assert.NoError(t, storageAdapter.Write("bar", "hello"))
+	reviewer := &fakeTokenReviewer{}
+	authenticator, err := newAuthenticator(&AuthConfig{
+		Tokens: []staticToken{
+			{Name: "reader", Token: "reader", Scopes: []string{"read:foo"}},
+			{Name: "admin", Token: "admin", Scopes: []string{"admin", "delete:*"}},
+		},
+		TokenReview: &tokenReviewConfig{},
+	}, reviewer)
+	assert.NoError(t, err)
+	h := newRequestHandler(storageAdapter, nil)
+	h.setAuthenticator(authenticator)
+	router := newRouter(h)
+
+	tests := []struct {
+		method, url, token string
+		status             int
+	}{
+		{"GET", "/logs/foo", "", http.StatusUnauthorized},
+		{"GET", "/logs/foo", "bogus", http.StatusUnauthorized},
+		{"GET", "/logs/foo", "reader", http.StatusOK},
+		{"GET", "/logs/foo?access_token=reader", "", http.StatusOK},
+		{"GET", "/logs/bar", "reader", http.StatusForbidden},
+		{"DELETE", "/logs/foo", "reader", http.StatusForbidden},
+		{"GET", "/deadletters", "reader", http.StatusForbidden},
+		{"DELETE", "/logs/foo", "admin", http.StatusOK},
+		{"GET", "/healthz", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest(test.method, test.url, nil)
+		assert.NoError(t, err)
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, test.status, w.Code, "%s %s with %q", test.method, test.url, test.token)
+		if test.status == http.StatusUnauthorized {
+			assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+		}
+	}
+
+	// tokens which could not be checked are not rejected as invalid
+	reviewer.err = errors.New("api server unavailable")
+	r, err := http.NewRequest("GET", "/logs/foo", nil)
+	assert.NoError(t, err)
+	r.Header.Set("Authorization", "Bearer unknown")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// the authenticator is replaced when the auth config is reloaded
+	h.setAuthenticator(nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/bar", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,158 @@
+package weblog
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	serviceAccountDir  = "/var/run/secrets/kubernetes.io/serviceaccount"
+	tokenReviewPath    = "/apis/authentication.k8s.io/v1/tokenreviews"
+	tokenReviewTimeout = 10 * time.Second
+)
+
+// tokenReviewConfig configures the authentication of Kubernetes tokens, such as service account
+// tokens, and the scopes granted to their users and groups
+type tokenReviewConfig struct {
+	// Audiences the tokens must be issued for, the audiences of the API server when empty
+	Audiences []string            `yaml:"audiences"`
+	Users     map[string][]string `yaml:"users"`
+	Groups    map[string][]string `yaml:"groups"`
+}
+
+// TokenReviewStatus is the result of the review of a token
+type TokenReviewStatus struct {
+	Authenticated bool
+	Username      string
+	Groups        []string
+}
+
+// TokenReviewer reviews Kubernetes tokens, with the TokenReview API of the API server in a cluster
+// and with fakes in tests.
+type TokenReviewer interface {
+	// Review returns whether a token is authenticated and the user it identifies
+	Review(ctx context.Context, token string) (*TokenReviewStatus, error)
+}
+
+// tokenReviewAuthenticator authenticates the tokens accepted by a TokenReviewer, granting them the
+// scopes of their user and groups
+type tokenReviewAuthenticator struct {
+	reviewer TokenReviewer
+	config   *tokenReviewConfig
+}
+
+func (a *tokenReviewAuthenticator) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	status, err := a.reviewer.Review(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Authenticated {
+		return nil, ErrUnauthenticated
+	}
+	principal := &Principal{Name: status.Username}
+	principal.Scopes = append(principal.Scopes, a.config.Users[status.Username]...)
+	for _, group := range status.Groups {
+		principal.Scopes = append(principal.Scopes, a.config.Groups[group]...)
+	}
+	return principal, nil
+}
+
+// tokenReview is the TokenReview resource of the Kubernetes API, with the fields used by the logger
+type tokenReview struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Token     string   `json:"token"`
+		Audiences []string `json:"audiences,omitempty"`
+	} `json:"spec"`
+	Status struct {
+		Authenticated bool `json:"authenticated"`
+		User          struct {
+			Username string   `json:"username"`
+			Groups   []string `json:"groups"`
+		} `json:"user"`
+		Error string `json:"error"`
+	} `json:"status"`
+}
+
+// kubeTokenReviewer reviews tokens by creating TokenReviews with the API server, authenticated with
+// the service account token of the logger
+type kubeTokenReviewer struct {
+	client    *http.Client
+	url       string
+	tokenFile string
+	audiences []string
+}
+
+// newInClusterTokenReviewer returns a TokenReviewer reviewing tokens with the API server of the
+// cluster the logger runs in. The service account of the logger must be allowed to create
+// TokenReviews.
+func newInClusterTokenReviewer(audiences []string) (TokenReviewer, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("token review: not running in a Kubernetes cluster")
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("token review: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("token review: invalid cluster CA certificate")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &kubeTokenReviewer{
+		client:    &http.Client{Transport: transport, Timeout: tokenReviewTimeout},
+		url:       "https://" + net.JoinHostPort(host, port) + tokenReviewPath,
+		tokenFile: serviceAccountDir + "/token",
+		audiences: audiences,
+	}, nil
+}
+
+func (k *kubeTokenReviewer) Review(ctx context.Context, token string) (*TokenReviewStatus, error) {
+	// the service account token is read for every review, as it is rotated
+	credentials, err := os.ReadFile(k.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("token review: %v", err)
+	}
+	review := tokenReview{APIVersion: "authentication.k8s.io/v1", Kind: "TokenReview"}
+	review.Spec.Token = token
+	review.Spec.Audiences = k.audiences
+	body, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+string(bytes.TrimSpace(credentials)))
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token review: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token review: unexpected status %s", resp.Status)
+	}
+	result := new(tokenReview)
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("token review: %v", err)
+	}
+	return &TokenReviewStatus{
+		Authenticated: result.Status.Authenticated,
+		Username:      result.Status.User.Username,
+		Groups:        result.Status.User.Groups,
+	}, nil
+}
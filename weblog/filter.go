@@ -0,0 +1,104 @@
+package weblog
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/drycc/logger/storage"
+)
+
+// processPattern matches the tag of the process that emitted a log line, e.g. drycc[web.v2]
+var processPattern = regexp.MustCompile(`drycc\[([^\].]+)[^\]]*\]`)
+
+// logFilter selects the log lines returned by getLogs. Every condition given must be satisfied, a
+// condition listing several values is satisfied by any of them.
+type logFilter struct {
+	grep      string
+	regex     *regexp.Regexp
+	processes []string
+	pods      []string
+	streams   []string
+	levels    []string
+}
+
+// parseFilter returns the filter described by the query parameters of a request, or nil when
+// there is none:
+//   - grep, a substring of the line
+//   - regex, a regular expression matching the line
+//   - process, the process type, e.g. web or worker
+//   - pod, the pod name
+//   - stream, stdout or stderr
+//   - level, the level, case insensitively
+//
+// process, pod, stream and level take comma separated values. Lines stored without the metadata
+// pod, stream and level are read from do not satisfy these conditions.
+func parseFilter(query url.Values) (*logFilter, error) {
+	f := &logFilter{
+		grep:      query.Get("grep"),
+		processes: queryValues(query, "process"),
+		pods:      queryValues(query, "pod"),
+		streams:   queryValues(query, "stream"),
+		levels:    queryValues(query, "level"),
+	}
+	for i, level := range f.levels {
+		f.levels[i] = strings.ToLower(level)
+	}
+	if pattern := query.Get("regex"); pattern != "" {
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.regex = regex
+	}
+	if f.grep == "" && f.regex == nil && f.processes == nil && f.pods == nil && f.streams == nil && f.levels == nil {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// queryValues returns the comma separated values of a query parameter, which may be repeated
+func queryValues(query url.Values, key string) []string {
+	var values []string
+	for _, value := range query[key] {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// match reports whether an entry satisfies the filter, a nil filter matches every entry
+func (f *logFilter) match(entry storage.Entry) bool {
+	if f == nil {
+		return true
+	}
+	if f.grep != "" && !strings.Contains(entry.Line, f.grep) {
+		return false
+	}
+	if f.regex != nil && !f.regex.MatchString(entry.Line) {
+		return false
+	}
+	if f.processes != nil && !slices.Contains(f.processes, entryProcess(entry)) {
+		return false
+	}
+	if f.pods != nil && !slices.Contains(f.pods, entry.Metadata.Pod) {
+		return false
+	}
+	if f.streams != nil && !slices.Contains(f.streams, entry.Metadata.Stream) {
+		return false
+	}
+	return f.levels == nil || slices.Contains(f.levels, strings.ToLower(entry.Metadata.Level))
+}
+
+// processType returns the type of the process that emitted a log line, e.g. web for
+// "2006-01-02T15:04:05-07:00 drycc[web.v2]: message", or an empty string for lines without a tag
+func processType(line string) string {
+	if m := processPattern.FindStringSubmatch(line); m != nil {
+		return m[1]
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package weblog
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/drycc/logger/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilter(t *testing.T) {
+	f, err := parseFilter(url.Values{"log_lines": {"10"}})
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+	assert.True(t, f.match(storage.Entry{Line: "message"}))
+
+	f, err = parseFilter(url.Values{"process": {"web, worker", "cron"}, "level": {"ERROR"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"web", "worker", "cron"}, f.processes)
+	assert.Equal(t, []string{"error"}, f.levels)
+
+	_, err = parseFilter(url.Values{"regex": {"("}})
+	assert.Error(t, err)
+}
+
+func TestLogFilterMatch(t *testing.T) {
+	entry := storage.Entry{
+		Line:     "2024-01-01T00:00:00Z drycc[web.v2]: GET /healthz 500",
+		Metadata: storage.Metadata{Pod: "foo-web-1", Stream: "stderr", Level: "Error"},
+	}
+	for query, expected := range map[string]bool{
+		"grep=healthz":                         true,
+		"grep=HEALTHZ":                         false,
+		"regex=" + url.QueryEscape(`\s5\d\d$`): true,
+		"regex=^GET":                           false,
+		"process=worker,web":                   true,
+		"process=web.v2":                       false,
+		"pod=foo-web-1":                        true,
+		"pod=foo-web-2":                        false,
+		"stream=stderr":                        true,
+		"stream=stdout":                        false,
+		"level=error":                          true,
+		"level=info,warn":                      false,
+		"grep=healthz&stream=stdout":           false,
+	} {
+		values, err := url.ParseQuery(query)
+		assert.NoError(t, err)
+		f, err := parseFilter(values)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, f.match(entry), query)
+	}
+	// lines without metadata do not match the conditions on it
+	f, err := parseFilter(url.Values{"stream": {"stdout"}})
+	assert.NoError(t, err)
+	assert.False(t, f.match(storage.Entry{Line: entry.Line}))
+}
+
+func TestProcessType(t *testing.T) {
+	assert.Equal(t, "web", processType("2024-01-01T00:00:00Z drycc[web.v2]: message"))
+	assert.Equal(t, "controller", processType("2024-01-01T00:00:00Z drycc[controller]: INFO message"))
+	assert.Equal(t, "", processType("  continuation"))
+}
@@ -0,0 +1,96 @@
+package weblog
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/drycc/logger/storage"
+)
+
+// The formats logs are returned in by getLogs
+const (
+	// formatText writes the log lines as they were rendered, one per line
+	formatText = "text"
+	// formatJSON writes a logRecord per line, as newline-delimited JSON
+	formatJSON = "json"
+
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// responseFormat returns the format requested by the format query parameter or, when it is not
+// given, negotiated with the Accept header
+func responseFormat(r *http.Request) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case formatText, formatJSON:
+		return format, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported format %q", format)
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			if mediaType, _, err := mime.ParseMediaType(mediaRange); err == nil && mediaType == ndjsonContentType {
+				return formatJSON, nil
+			}
+		}
+	}
+	return formatText, nil
+}
+
+// logRecord is a log line written as JSON, along with its cursor and the message it was rendered
+// from
+type logRecord struct {
+	Cursor    string    `json:"cursor,omitempty"`
+	Time      time.Time `json:"time,omitzero"`
+	App       string    `json:"app"`
+	Process   string    `json:"process,omitempty"`
+	Pod       string    `json:"pod,omitempty"`
+	Container string    `json:"container,omitempty"`
+	Stream    string    `json:"stream,omitempty"`
+	Level     string    `json:"level,omitempty"`
+	Message   string    `json:"message"`
+	Line      string    `json:"line"`
+}
+
+// newLogRecord returns the record of an entry of an app. The message is parsed from the line, as
+// are the time and process type of lines stored without metadata.
+func newLogRecord(app string, entry storage.Entry) logRecord {
+	line := strings.TrimSuffix(entry.Line, "\n")
+	record := logRecord{
+		Cursor:    entry.Cursor,
+		Time:      entry.Metadata.Time,
+		App:       app,
+		Process:   entryProcess(entry),
+		Pod:       entry.Metadata.Pod,
+		Container: entry.Metadata.Container,
+		Stream:    entry.Metadata.Stream,
+		Level:     entry.Metadata.Level,
+		Message:   lineMessage(line),
+		Line:      line,
+	}
+	if record.Time.IsZero() {
+		record.Time, _ = storage.LineTime(line)
+	}
+	return record
+}
+
+// entryProcess returns the type of the process that emitted an entry, as stored along with it or
+// parsed from its line
+func entryProcess(entry storage.Entry) string {
+	if entry.Metadata.Process != "" {
+		return entry.Metadata.Process
+	}
+	return processType(entry.Line)
+}
+
+// lineMessage returns the message of a log line, following the process tag, e.g. message for
+// "2006-01-02T15:04:05-07:00 drycc[web.v2]: message", or the whole line for lines without a tag
+func lineMessage(line string) string {
+	if loc := processPattern.FindStringIndex(line); loc != nil {
+		return strings.TrimPrefix(line[loc[1]:], ": ")
+	}
+	return line
+}
@@ -0,0 +1,13 @@
+package weblog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineMessage(t *testing.T) {
+	assert.Equal(t, "GET / 500", lineMessage("2024-01-01T00:00:00Z drycc[web.v1]: GET / 500"))
+	assert.Equal(t, "INFO deployed", lineMessage("2024-01-01T00:00:00Z drycc[controller]: INFO deployed"))
+	assert.Equal(t, "  continuation", lineMessage("  continuation"))
+}
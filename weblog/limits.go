@@ -0,0 +1,67 @@
+package weblog
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Limits bound the logs requests. Unlike DRYCC_LOGS_MAXIMUM_LINES and DRYCC_LOGS_MAXIMUM_TIMEOUT,
+// which are read once on start, they can be reloaded from a file while requests are served.
+//
+// Example limits file (YAML, or the equivalent JSON):
+//
+//	maximumLines: 500
+//	maximumTimeout: 600
+//	allowedOrigins:
+//	  - https://dashboard.example.com
+type Limits struct {
+	// MaximumLines is the maximum number of lines returned by a request, DryccLogsMaximumLines when 0
+	MaximumLines int `yaml:"maximumLines"`
+	// MaximumTimeout is the maximum number of seconds a follow lasts, DryccLogsMaximumTimeout when 0
+	MaximumTimeout int `yaml:"maximumTimeout"`
+	// AllowedOrigins are the origins WebSocket upgrades are accepted from besides the origin of the
+	// server, such as "https://dashboard.example.com", "*" accepts any
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+}
+
+var currentLimits atomic.Pointer[Limits]
+
+// LoadLimits reads the limits of the logs requests from a YAML or JSON file.
+func LoadLimits(path string) (*Limits, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	limits := new(Limits)
+	if err := yaml.Unmarshal(data, limits); err != nil {
+		return nil, fmt.Errorf("error parsing limits %s: %v", path, err)
+	}
+	if limits.MaximumLines < 0 || limits.MaximumTimeout < 0 {
+		return nil, fmt.Errorf("error parsing limits %s: negative limit", path)
+	}
+	return limits, nil
+}
+
+// SetLimits replaces the limits of the logs requests, nil restores the limits of the environment.
+// It is safe to call while requests are served, the follows in flight keep their timeout.
+func SetLimits(limits *Limits) {
+	currentLimits.Store(limits)
+}
+
+// getLimits returns the current limits, with the limits of the environment for those not set
+func getLimits() Limits {
+	limits := Limits{}
+	if l := currentLimits.Load(); l != nil {
+		limits = *l
+	}
+	if limits.MaximumLines == 0 {
+		limits.MaximumLines = DryccLogsMaximumLines
+	}
+	if limits.MaximumTimeout == 0 {
+		limits.MaximumTimeout = DryccLogsMaximumTimeout
+	}
+	return limits
+}
@@ -0,0 +1,50 @@
+package weblog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drycc/logger/storage"
+)
+
+func TestLoadLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("maximumLines: 2\nallowedOrigins: [https://example.com]\n"), 0o600))
+	limits, err := LoadLimits(path)
+	assert.NoError(t, err)
+	assert.Equal(t, &Limits{MaximumLines: 2, AllowedOrigins: []string{"https://example.com"}}, limits)
+
+	assert.NoError(t, os.WriteFile(path, []byte("maximumTimeout: -1\n"), 0o600))
+	_, err = LoadLimits(path)
+	assert.Error(t, err)
+	_, err = LoadLimits(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestSetLimits(t *testing.T) {
+	defer SetLimits(nil)
+	storageAdapter, err := storage.NewAdapter("memory", 10)
+	assert.NoError(t, err)
+	for _, line := range []string{"one", "two", "three"} {
+		assert.NoError(t, storageAdapter.Write("foo", line))
+	}
+	router := newRouter(newRequestHandler(storageAdapter, nil))
+
+	SetLimits(&Limits{MaximumLines: 2})
+	assert.Equal(t, Limits{MaximumLines: 2, MaximumTimeout: DryccLogsMaximumTimeout}, getLimits())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?log_lines=3", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "two\nthree\n", w.Body.String())
+
+	// the limits of the environment are restored
+	SetLimits(nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?log_lines=3", nil))
+	assert.Equal(t, "one\ntwo\nthree\n", w.Body.String())
+}
@@ -0,0 +1,32 @@
+package weblog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drycc/logger/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentHandlerRoute(t *testing.T) {
+	storageAdapter, err := storage.NewAdapter("memory", 10)
+	assert.NoError(t, err)
+	assert.NoError(t, storageAdapter.Write("foo", "message"))
+	router := newRouter(newRequestHandler(storageAdapter, nil))
+	matched := requestsTotal.WithLabelValues("/logs/{app}", "GET", "200")
+	before := testutil.ToFloat64(matched)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/logs/foo", nil))
+	assert.Equal(t, before+1, testutil.ToFloat64(matched))
+
+	// the requests not matching a route share a label, whatever their path
+	unmatched := requestsTotal.WithLabelValues(unmatchedRoute, "GET", "404")
+	before = testutil.ToFloat64(unmatched)
+	for _, path := range []string{"/foo", "/bar"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+	assert.Equal(t, before+2, testutil.ToFloat64(unmatched))
+}
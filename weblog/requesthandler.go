@@ -2,16 +2,21 @@ package weblog
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
 
+	dlog "github.com/drycc/logger/log"
 	"github.com/drycc/logger/storage"
 )
 
@@ -31,20 +36,49 @@ func init() {
 	}
 }
 
+// deadLettersDefaultCount is the number of dead letters listed when no count is given
+const deadLettersDefaultCount = 100
+
 type requestHandler struct {
 	storageAdapter storage.Adapter
+	deadLetters    dlog.DeadLetterQueue
+	connector      dlog.Connector
+	// authenticator authenticates the requests for logs and dead letters, nil allows every request.
+	// It is replaced when the auth config is reloaded.
+	authenticator *atomic.Pointer[Authenticator]
+	streams       *streamTracker
 }
 
-func newRequestHandler(storageAdapter storage.Adapter) *requestHandler {
-	return &requestHandler{
+// newRequestHandler returns a requestHandler serving the logs of the storage adapter. The dead
+// letters and the connection state of the aggregator are served when it implements
+// dlog.DeadLetterQueue and dlog.Connector, aggregator may be nil.
+func newRequestHandler(storageAdapter storage.Adapter, aggregator dlog.Aggregator) *requestHandler {
+	h := &requestHandler{
 		storageAdapter: storageAdapter,
+		authenticator:  new(atomic.Pointer[Authenticator]),
+		streams:        newStreamTracker(),
 	}
+	h.deadLetters, _ = aggregator.(dlog.DeadLetterQueue)
+	h.connector, _ = aggregator.(dlog.Connector)
+	return h
 }
 
 func (h requestHandler) getHealthz(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// getReadyz reports whether the aggregator is connected to the service it consumes messages from.
+// Aggregators receiving messages on their own listeners are always ready.
+func (h requestHandler) getReadyz(w http.ResponseWriter, _ *http.Request) {
+	if h.connector != nil {
+		if state := h.connector.ConnectionState(); state != dlog.ConnectionStateConnected {
+			http.Error(w, fmt.Sprintf("aggregator %s", state), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h requestHandler) getLogs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Connection", "Keep-Alive")
 	w.Header().Set("Transfer-Encoding", "chunked")
@@ -54,59 +88,212 @@ func (h requestHandler) getLogs(w http.ResponseWriter, r *http.Request) {
 		panic("expected http.ResponseWriter to be an http.Flusher")
 	}
 
-	app := mux.Vars(r)["app"]
-	var logLines int
-	logLinesStr := r.URL.Query().Get("log_lines")
-	if logLinesStr == "" {
-		log.Printf("The number of lines to return was not specified. Defaulting to 100 lines.")
-		logLines = DryccLogsMaximumLines
-	} else {
-		var err error
-		logLines, err = strconv.Atoi(logLinesStr)
-		if err != nil || logLines > DryccLogsMaximumLines || logLines < 0 {
-			log.Printf("The specified number of log lines was invalid. Defaulting to 100 lines.")
-			logLines = DryccLogsMaximumLines
-		}
+	req, err := parseLogsRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	logs, err := h.storageAdapter.Read(app, logLines)
+	entries, cursor, behind, err := h.readLogs(req)
 	if err != nil {
 		log.Println(err)
-		if strings.HasPrefix(err.Error(), "could not find logs for") {
-			w.WriteHeader(http.StatusNoContent)
+		if status := readErrorStatus(err); status == http.StatusBadRequest {
+			http.Error(w, err.Error(), status)
 		} else {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(status)
 		}
 		return
 	}
-	log.Printf("Returning the last %v lines for %s", logLines, app)
-	for _, line := range logs {
-		// strip any trailing newline characters from the logs
-		fmt.Fprintf(w, "%s\n", strings.TrimSuffix(line, "\n"))
+
+	// in formatJSON every line is written as a record carrying its cursor, the cursor of the last
+	// line read is returned in a header too
+	w.Header().Add("Vary", "Accept")
+	if req.format == formatJSON {
+		w.Header().Set("Content-Type", ndjsonContentType)
+	}
+	if cursor != "" {
+		w.Header().Set("X-Log-Cursor", cursor)
+	}
+	encoder := json.NewEncoder(w)
+	writeEntry := func(entry storage.Entry) {
+		if !req.filter.match(entry) {
+			return
+		}
+		if req.format == formatJSON {
+			encoder.Encode(newLogRecord(req.app, entry))
+		} else {
+			// strip any trailing newline characters from the logs
+			fmt.Fprintf(w, "%s\n", strings.TrimSuffix(entry.Line, "\n"))
+		}
+	}
+	log.Printf("Returning %v lines for %s", len(entries), req.app)
+	for _, entry := range entries {
+		writeEntry(entry)
 	}
 	flusher.Flush()
 
+	// a time range with an end is not followed
 	follow, err := strconv.ParseBool(r.URL.Query().Get("follow"))
-	if err == nil && follow {
+	if err == nil && follow && req.until.IsZero() {
+		maximumTimeout := getLimits().MaximumTimeout
 		timeout, err := strconv.Atoi(r.URL.Query().Get("timeout"))
-		if err != nil || timeout > DryccLogsMaximumTimeout || timeout <= 0 {
-			timeout = DryccLogsMaximumTimeout
+		if err != nil || timeout > maximumTimeout || timeout <= 0 {
+			timeout = maximumTimeout
 		}
 		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
 		defer cancel()
-		if channel, err := h.storageAdapter.Chan(ctx, app, 100); err == nil {
-			for {
-				line := <-channel
-				if line == "" {
-					break
-				}
-				fmt.Fprintf(w, "%s\n", strings.TrimSuffix(line, "\n"))
-				flusher.Flush()
+		ctx, end := h.streams.begin(ctx)
+		defer end()
+		channel, err := h.followLogs(ctx, req, cursor, behind, func(entries []storage.Entry) {
+			for _, entry := range entries {
+				writeEntry(entry)
 			}
+			flusher.Flush()
+		})
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		for entry := range channel {
+			writeEntry(entry)
+			flusher.Flush()
 		}
 	}
 	w.Header().Set("Content-Length", "0")
 }
 
+// logsRequest is a request for the logs of an app, as given by the query parameters shared by
+// getLogs and streamLogs
+type logsRequest struct {
+	app   string
+	lines int
+	// sinceCursor resumes right after a line returned by a previous request, since and until
+	// restrict the lines to a time range
+	sinceCursor  string
+	since, until time.Time
+	filter       *logFilter
+	format       string
+}
+
+// parseLogsRequest returns the logs request of an HTTP request, or an error describing the query
+// parameter that is invalid
+func parseLogsRequest(r *http.Request) (*logsRequest, error) {
+	req := &logsRequest{
+		app:         mux.Vars(r)["app"],
+		sinceCursor: r.URL.Query().Get("since_cursor"),
+	}
+	maximumLines := getLimits().MaximumLines
+	logLinesStr := r.URL.Query().Get("log_lines")
+	if logLinesStr == "" {
+		log.Printf("The number of lines to return was not specified. Defaulting to 100 lines.")
+		req.lines = maximumLines
+	} else {
+		var err error
+		req.lines, err = strconv.Atoi(logLinesStr)
+		if err != nil || req.lines > maximumLines || req.lines < 0 {
+			log.Printf("The specified number of log lines was invalid. Defaulting to 100 lines.")
+			req.lines = maximumLines
+		}
+	}
+	var err error
+	now := time.Now()
+	if req.since, err = parseTime(r.URL.Query().Get("since"), now); err != nil {
+		return nil, fmt.Errorf("invalid since: %v", err)
+	}
+	if req.until, err = parseTime(r.URL.Query().Get("until"), now); err != nil {
+		return nil, fmt.Errorf("invalid until: %v", err)
+	}
+	if req.sinceCursor != "" && (!req.since.IsZero() || !req.until.IsZero()) {
+		return nil, errors.New("since_cursor cannot be combined with since or until")
+	}
+	if req.filter, err = parseFilter(r.URL.Query()); err != nil {
+		return nil, fmt.Errorf("invalid regex: %v", err)
+	}
+	if req.format, err = responseFormat(r); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// readLogs returns the entries of a logs request, filtered unless read from a cursor, the cursor
+// the lines written after them are followed from and whether lines may have been written after it,
+// which are then caught up with before following. The cursor of a filtered read is the one of the
+// last line scanned, so that the lines not matching are not read again, nor those written after
+// them missed, when no line matched.
+func (h requestHandler) readLogs(req *logsRequest) ([]storage.Entry, string, bool, error) {
+	switch {
+	case req.sinceCursor != "":
+		entries, err := storage.ReadSince(h.storageAdapter, req.app, req.sinceCursor, req.lines)
+		return entries, lastCursor(entries, req.sinceCursor), len(entries) == req.lines, err
+	case !req.since.IsZero() || !req.until.IsZero() || req.filter != nil:
+		query := storage.Query{Since: req.since, Until: req.until, Match: req.filter.match}
+		entries, cursor, err := storage.ReadQuery(h.storageAdapter, req.app, query, req.lines)
+		return entries, cursor, req.filter != nil, err
+	default:
+		entries, err := storage.ReadEntries(h.storageAdapter, req.app, req.lines)
+		return entries, lastCursor(entries, ""), false, err
+	}
+}
+
+// lastCursor returns the cursor of the last entry, or cursor when there are none
+func lastCursor(entries []storage.Entry, cursor string) string {
+	if len(entries) > 0 {
+		return entries[len(entries)-1].Cursor
+	}
+	return cursor
+}
+
+// readErrorStatus returns the status code responding to an error reading logs
+func readErrorStatus(err error) int {
+	if isNotFound(err) {
+		return http.StatusNoContent
+	}
+	if errors.Is(err, storage.ErrInvalidCursor) || errors.Is(err, storage.ErrCursorsUnsupported) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// isNotFound reports whether an error reading logs is due to an app without logs
+func isNotFound(err error) bool {
+	return strings.HasPrefix(err.Error(), "could not find logs for")
+}
+
+// followLogs follows the logs of a request from the entry at cursor, or from now on when cursor is
+// empty. When behind, the lines written after cursor are first read and passed to catchUp page by
+// page, a follow channel falling behind by more than its size would be closed. The entries of the
+// returned channel are not filtered.
+func (h requestHandler) followLogs(ctx context.Context, req *logsRequest, cursor string, behind bool, catchUp func([]storage.Entry)) (chan storage.Entry, error) {
+	page := getLimits().MaximumLines
+	for behind && cursor != "" && ctx.Err() == nil {
+		entries, err := storage.ReadSince(h.storageAdapter, req.app, cursor, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			catchUp(entries)
+			cursor = entries[len(entries)-1].Cursor
+		}
+		behind = len(entries) == page
+	}
+	// following from the cursor of the last line written leaves no gap after the lines read
+	return storage.FollowSince(ctx, h.storageAdapter, req.app, cursor, 100)
+}
+
+// parseTime parses a time given either in RFC3339 format or as a duration before now, e.g. 15m.
+// An empty value is the zero time.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("negative duration %s", value)
+		}
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
 func (h requestHandler) deleteLogs(w http.ResponseWriter, r *http.Request) {
 	app := mux.Vars(r)["app"]
 	if err := h.storageAdapter.Destroy(app); err != nil {
@@ -114,3 +301,58 @@ func (h requestHandler) deleteLogs(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
+
+func (h requestHandler) getDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if h.deadLetters == nil {
+		http.Error(w, dlog.ErrDeadLetterDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	start := r.URL.Query().Get("start")
+	if start == "" {
+		start = "-"
+	}
+	count, err := strconv.ParseInt(r.URL.Query().Get("count"), 10, 64)
+	if err != nil || count <= 0 {
+		count = deadLettersDefaultCount
+	}
+	deadLetters, err := h.deadLetters.DeadLetters(r.Context(), start, count)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), deadLetterErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deadLetters)
+}
+
+// replayDeadLetters replays the dead letters listed in the request body as {"ids": [...]}, or every
+// dead letter when the body is empty, and responds with the number of replayed entries.
+func (h requestHandler) replayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if h.deadLetters == nil {
+		http.Error(w, dlog.ErrDeadLetterDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	replayed, err := h.deadLetters.Replay(r.Context(), body.IDs...)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), deadLetterErrorStatus(err))
+		return
+	}
+	log.Printf("Replayed %d dead letters", replayed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"replayed": replayed})
+}
+
+func deadLetterErrorStatus(err error) int {
+	if errors.Is(err, dlog.ErrDeadLetterDisabled) || errors.Is(err, dlog.ErrDeadLetterNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
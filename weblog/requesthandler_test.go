@@ -0,0 +1,368 @@
+package weblog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dlog "github.com/drycc/logger/log"
+	"github.com/drycc/logger/storage"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubDeadLetterQueue struct {
+	deadLetters []dlog.DeadLetter
+}
+
+func (q *stubDeadLetterQueue) DeadLetters(_ context.Context, start string, count int64) ([]dlog.DeadLetter, error) {
+	for i, deadLetter := range q.deadLetters {
+		if start == "-" || deadLetter.ID == start {
+			end := i + int(count)
+			if end > len(q.deadLetters) {
+				end = len(q.deadLetters)
+			}
+			return q.deadLetters[i:end], nil
+		}
+	}
+	return nil, nil
+}
+
+func (q *stubDeadLetterQueue) Replay(_ context.Context, ids ...string) (int, error) {
+	if len(ids) == 0 {
+		replayed := len(q.deadLetters)
+		q.deadLetters = nil
+		return replayed, nil
+	}
+	for _, id := range ids {
+		found := false
+		for i, deadLetter := range q.deadLetters {
+			if deadLetter.ID == id {
+				q.deadLetters = append(q.deadLetters[:i], q.deadLetters[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("%w: %s", dlog.ErrDeadLetterNotFound, id)
+		}
+	}
+	return len(ids), nil
+}
+
+func newTestDeadLetterQueue() *stubDeadLetterQueue {
+	return &stubDeadLetterQueue{deadLetters: []dlog.DeadLetter{
+		{ID: "1-0", OriginalID: "10-0", Reason: "invalid character", Data: "{"},
+		{ID: "2-0", OriginalID: "11-0", Reason: "invalid character", Retries: 1, Data: "}"},
+	}}
+}
+
+func TestGetDeadLetters(t *testing.T) {
+	router := newRouter(&requestHandler{deadLetters: newTestDeadLetterQueue()})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/deadletters?start=2-0&count=10", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var deadLetters []dlog.DeadLetter
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &deadLetters))
+	assert.Equal(t, []dlog.DeadLetter{{ID: "2-0", OriginalID: "11-0", Reason: "invalid character", Retries: 1, Data: "}"}}, deadLetters)
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	queue := newTestDeadLetterQueue()
+	router := newRouter(&requestHandler{deadLetters: queue})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/deadletters/replay", strings.NewReader(`{"ids": ["1-0"]}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"replayed": 1}`, w.Body.String())
+	assert.Len(t, queue.deadLetters, 1)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/deadletters/replay", strings.NewReader(`{"ids": ["1-0"]}`)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/deadletters/replay", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"replayed": 1}`, w.Body.String())
+	assert.Empty(t, queue.deadLetters)
+}
+
+func TestDeadLettersUnavailable(t *testing.T) {
+	router := newRouter(newRequestHandler(nil, nil))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/deadletters", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+type stubConnector dlog.ConnectionState
+
+func (c stubConnector) ConnectionState() dlog.ConnectionState {
+	return dlog.ConnectionState(c)
+}
+
+func TestGetReadyz(t *testing.T) {
+	router := newRouter(newRequestHandler(nil, nil))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	router = newRouter(&requestHandler{connector: stubConnector(dlog.ConnectionStateDisconnected)})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "aggregator disconnected\n", w.Body.String())
+
+	router = newRouter(&requestHandler{connector: stubConnector(dlog.ConnectionStateConnected)})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestMetrics(t *testing.T) {
+	router := newRouter(newRequestHandler(nil, nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `logger_http_requests_total{code="200",method="GET",route="/healthz"}`)
+	assert.Contains(t, w.Body.String(), "logger_http_follow_streams 0")
+}
+
+func TestGetLogsCursors(t *testing.T) {
+	storageAdapter, err := storage.NewAdapter("memory", 10)
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, storageAdapter.Write("foo", fmt.Sprintf("message %d", i)))
+	}
+	router := newRouter(newRequestHandler(storageAdapter, nil))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?log_lines=2&format=json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"cursor":"4","app":"foo","message":"message 3","line":"message 3"}`+"\n"+
+		`{"cursor":"5","app":"foo","message":"message 4","line":"message 4"}`+"\n", w.Body.String())
+	assert.Equal(t, "5", w.Header().Get("X-Log-Cursor"))
+
+	// resuming returns the lines after the cursor only
+	assert.NoError(t, storageAdapter.Write("foo", "message 5"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?since_cursor=4", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "message 4\nmessage 5\n", w.Body.String())
+	assert.Equal(t, "6", w.Header().Get("X-Log-Cursor"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?since_cursor=bogus", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetLogsFollowSinceCursor(t *testing.T) {
+	storageAdapter, err := storage.NewAdapter("memory", 10)
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, storageAdapter.Write("foo", fmt.Sprintf("message %d", i)))
+	}
+	router := newRouter(newRequestHandler(storageAdapter, nil))
+	go func() {
+		// written once the follow is subscribed
+		for testutil.ToFloat64(followStreams) == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(100 * time.Millisecond)
+		storageAdapter.Write("foo", "message 5")
+	}()
+	// the catch up is read a page of log_lines at a time before following
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?since_cursor=1&log_lines=2&follow=true&timeout=1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "message 1\nmessage 2\nmessage 3\nmessage 4\nmessage 5\n", w.Body.String())
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tm, err := parseTime("", now)
+	assert.NoError(t, err)
+	assert.True(t, tm.IsZero())
+	tm, err = parseTime("15m", now)
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(-15*time.Minute), tm)
+	tm, err = parseTime("2024-01-01T10:00:00Z", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), tm)
+	for _, value := range []string{"-15m", "yesterday", "2024-01-01"} {
+		_, err := parseTime(value, now)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestGetLogsTimeRange(t *testing.T) {
+	storageAdapter, err := storage.NewAdapter("memory", 10)
+	assert.NoError(t, err)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		timestamp := start.Add(time.Duration(i) * time.Minute).Format(time.RFC3339)
+		assert.NoError(t, storageAdapter.Write("foo", fmt.Sprintf("%s drycc[web.v1]: message %d", timestamp, i)))
+	}
+	router := newRouter(newRequestHandler(storageAdapter, nil))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?since=2024-01-01T00:01:00Z&until=2024-01-01T00:02:00Z&follow=true", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2024-01-01T00:01:00Z drycc[web.v1]: message 1\n2024-01-01T00:02:00Z drycc[web.v1]: message 2\n", w.Body.String())
+
+	// every line is older than an hour
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?since=1h", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	for _, query := range []string{"since=bogus", "until=-1h", "since=1h&since_cursor=1"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestGetLogsFilter(t *testing.T) {
+	storageAdapter, err := storage.NewAdapter("memory", 10)
+	assert.NoError(t, err)
+	write := func(line string, stream string) {
+		assert.NoError(t, storageAdapter.Write("foo", storage.EncodeLine(line, storage.Metadata{Stream: stream})))
+	}
+	write("drycc[web.v1]: message 0", "stdout")
+	write("drycc[worker.v1]: message 1", "stderr")
+	write("drycc[web.v1]: message 2", "stderr")
+	write("drycc[web.v1]: message 3", "stdout")
+	router := newRouter(newRequestHandler(storageAdapter, nil))
+
+	// the last matching lines
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?process=web&stream=stderr,stdout&log_lines=2", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "drycc[web.v1]: message 2\ndrycc[web.v1]: message 3\n", w.Body.String())
+
+	// resuming moves the cursor past the lines not matching
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?since_cursor=0&stream=stderr", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "drycc[worker.v1]: message 1\ndrycc[web.v1]: message 2\n", w.Body.String())
+	assert.Equal(t, "4", w.Header().Get("X-Log-Cursor"))
+
+	// a read matching no line returns the cursor of the last line scanned, which is followed from
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?grep=nothing", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, "4", w.Header().Get("X-Log-Cursor"))
+
+	// the follow stream is filtered too
+	go func() {
+		for testutil.ToFloat64(followStreams) == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(100 * time.Millisecond)
+		write("drycc[web.v1]: message 4", "stdout")
+		write("drycc[web.v1]: message 5", "stderr")
+	}()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?grep=message&stream=stderr&follow=true&timeout=1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "drycc[worker.v1]: message 1\ndrycc[web.v1]: message 2\ndrycc[web.v1]: message 5\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?regex=(", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetLogsJSON(t *testing.T) {
+	storageAdapter, err := storage.NewAdapter("memory", 10)
+	assert.NoError(t, err)
+	metadata := storage.Metadata{
+		Time:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Process:   "web",
+		Pod:       "foo-web-1",
+		Container: "foo-web",
+		Stream:    "stderr",
+		Level:     "error",
+	}
+	assert.NoError(t, storageAdapter.Write("foo", storage.EncodeLine("2024-01-01T00:00:00Z drycc[web.v1]: GET / 500", metadata)))
+	// lines stored without metadata are parsed
+	assert.NoError(t, storageAdapter.Write("foo", "2024-01-01T00:01:00Z drycc[worker.v1]: done"))
+	router := newRouter(newRequestHandler(storageAdapter, nil))
+
+	expected := `{"cursor":"1","time":"2024-01-01T00:00:00Z","app":"foo","process":"web","pod":"foo-web-1",` +
+		`"container":"foo-web","stream":"stderr","level":"error","message":"GET / 500",` +
+		`"line":"2024-01-01T00:00:00Z drycc[web.v1]: GET / 500"}` + "\n" +
+		`{"cursor":"2","time":"2024-01-01T00:01:00Z","app":"foo","process":"worker","message":"done",` +
+		`"line":"2024-01-01T00:01:00Z drycc[worker.v1]: done"}` + "\n"
+	r := httptest.NewRequest("GET", "/logs/foo?format=json", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, expected, w.Body.String())
+
+	r = httptest.NewRequest("GET", "/logs/foo", nil)
+	r.Header.Set("Accept", "text/plain;q=0.5, application/x-ndjson")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, expected, w.Body.String())
+
+	// cursors=true does not request JSON records
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?cursors=true", nil))
+	assert.Equal(t, "2024-01-01T00:00:00Z drycc[web.v1]: GET / 500\n2024-01-01T00:01:00Z drycc[worker.v1]: done\n", w.Body.String())
+
+	// the format parameter takes precedence over the Accept header
+	r = httptest.NewRequest("GET", "/logs/foo?format=text", nil)
+	r.Header.Set("Accept", "application/x-ndjson")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, "2024-01-01T00:00:00Z drycc[web.v1]: GET / 500\n2024-01-01T00:01:00Z drycc[worker.v1]: done\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?format=xml", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetLogsJSONFile(t *testing.T) {
+	storage.LogRoot = t.TempDir()
+	storageAdapter, err := storage.NewAdapter("file", 10)
+	assert.NoError(t, err)
+	defer storageAdapter.Stop()
+	metadata := storage.Metadata{
+		Time:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Process:   "web",
+		Pod:       "foo-web-1",
+		Container: "foo-web",
+		Stream:    "stderr",
+		Level:     "error",
+	}
+	assert.NoError(t, storageAdapter.Write("foo", storage.EncodeLine("2024-01-01T00:00:00Z drycc[web.v1]: GET / 500", metadata)))
+	router := newRouter(newRequestHandler(storageAdapter, nil))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/logs/foo?format=json", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var record logRecord
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &record))
+	assert.Equal(t, logRecord{
+		Cursor:    record.Cursor,
+		Time:      metadata.Time,
+		App:       "foo",
+		Process:   "web",
+		Pod:       "foo-web-1",
+		Container: "foo-web",
+		Stream:    "stderr",
+		Level:     "error",
+		Message:   "GET / 500",
+		Line:      "2024-01-01T00:00:00Z drycc[web.v1]: GET / 500",
+	}, record)
+}
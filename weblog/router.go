@@ -1,16 +1,30 @@
 package weblog
 
 import (
+	"net/http"
+
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func newRouter(rh *requestHandler) *mux.Router {
 	r := mux.NewRouter()
+	r.Use(instrumentHandler)
+	r.NotFoundHandler = instrumentHandler(http.NotFoundHandler())
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
 	r.HandleFunc("/healthz", rh.getHealthz).Methods("GET")
 	r.HandleFunc("/healthz/", rh.getHealthz).Methods("GET")
-	r.HandleFunc("/logs/{app}", rh.getLogs).Methods("GET")
-	r.HandleFunc("/logs/{app}/", rh.getLogs).Methods("GET")
-	r.HandleFunc("/logs/{app}", rh.deleteLogs).Methods("DELETE")
-	r.HandleFunc("/logs/{app}/", rh.deleteLogs).Methods("DELETE")
+	r.HandleFunc("/readyz", rh.getReadyz).Methods("GET")
+	r.HandleFunc("/readyz/", rh.getReadyz).Methods("GET")
+	r.HandleFunc("/logs/{app}", rh.authorize(ActionRead, rh.getLogs)).Methods("GET")
+	r.HandleFunc("/logs/{app}/", rh.authorize(ActionRead, rh.getLogs)).Methods("GET")
+	r.HandleFunc("/logs/{app}/stream", rh.authorize(ActionRead, rh.streamLogs)).Methods("GET")
+	r.HandleFunc("/logs/{app}/stream/", rh.authorize(ActionRead, rh.streamLogs)).Methods("GET")
+	r.HandleFunc("/logs/{app}", rh.authorize(ActionDelete, rh.deleteLogs)).Methods("DELETE")
+	r.HandleFunc("/logs/{app}/", rh.authorize(ActionDelete, rh.deleteLogs)).Methods("DELETE")
+	r.HandleFunc("/deadletters", rh.authorize(ActionAdmin, rh.getDeadLetters)).Methods("GET")
+	r.HandleFunc("/deadletters/", rh.authorize(ActionAdmin, rh.getDeadLetters)).Methods("GET")
+	r.HandleFunc("/deadletters/replay", rh.authorize(ActionAdmin, rh.replayDeadLetters)).Methods("POST")
+	r.HandleFunc("/deadletters/replay/", rh.authorize(ActionAdmin, rh.replayDeadLetters)).Methods("POST")
 	return r
 }
@@ -1,10 +1,12 @@
 package weblog
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 
+	dlog "github.com/drycc/logger/log"
 	"github.com/drycc/logger/storage"
 )
 
@@ -22,14 +24,20 @@ type Server struct {
 	URL string
 	// started defines whether the server has started or not.
 	started bool
+	// handler serves the requests, its follow streams are ended by Shutdown
+	handler *requestHandler
 }
 
 // NewServer returns a new HTTP Server. The caller should call Start to start it and Close when finished
-// to shut it down.
-func NewServer(storageAdapter storage.Adapter) *Server {
+// to shut it down. Requests for logs and dead letters are authenticated by authenticator, unless it
+// is nil.
+func NewServer(storageAdapter storage.Adapter, aggregator dlog.Aggregator, authenticator Authenticator) *Server {
+	h := newRequestHandler(storageAdapter, aggregator)
+	h.setAuthenticator(authenticator)
 	s := &Server{
 		Listener: defaultListener(),
-		Server:   &http.Server{Handler: newRouter(newRequestHandler(storageAdapter))},
+		Server:   &http.Server{Handler: newRouter(h)},
+		handler:  h,
 	}
 	return s
 }
@@ -55,6 +63,30 @@ func (s *Server) Close() {
 	s.started = false
 }
 
+// SetAuthenticator replaces the authenticator of the requests for logs and dead letters, nil allows
+// every request. It is safe to call while requests are served.
+func (s *Server) SetAuthenticator(authenticator Authenticator) {
+	s.handler.setAuthenticator(authenticator)
+}
+
+// Shutdown gracefully shuts down the HTTP Server: it stops accepting requests, ends the follow
+// streams, whose clients reconnect from their last cursor, and waits for the requests in flight
+// until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.handler != nil {
+		s.handler.streams.close()
+	}
+	err := s.Server.Shutdown(ctx)
+	// WebSockets are hijacked connections, which the HTTP server does not wait for
+	if s.handler != nil {
+		if streamsErr := s.handler.streams.wait(ctx); err == nil {
+			err = streamsErr
+		}
+	}
+	s.started = false
+	return err
+}
+
 // defaultListener provides a net.Listener on bindAddr, panicking if it cannot listen on that
 // address.
 func defaultListener() net.Listener {
@@ -2,11 +2,18 @@ package weblog
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
 
 	"github.com/drycc/logger/storage"
 )
@@ -25,7 +32,7 @@ func newTestListener(t *testing.T) net.Listener {
 }
 
 func newTestStorageAdapter(t *testing.T) storage.Adapter {
-	storageAdapter, err := storage.NewAdapter("file", 1)
+	storageAdapter, err := storage.NewAdapter("memory", 1)
 	if err != nil {
 		t.Fatalf("Error creating storage adapter: %v", err)
 	}
@@ -39,7 +46,7 @@ func TestServerStart(t *testing.T) {
 
 	s := &Server{
 		Listener: newTestListener(t),
-		Server:   &http.Server{Handler: newRouter(newRequestHandler(storageAdapter))},
+		Server:   &http.Server{Handler: newRouter(newRequestHandler(storageAdapter, nil))},
 	}
 
 	s.Start()
@@ -69,7 +76,7 @@ func TestServerClose(t *testing.T) {
 
 	s := &Server{
 		Listener: newTestListener(t),
-		Server:   &http.Server{Handler: newRouter(newRequestHandler(storageAdapter))},
+		Server:   &http.Server{Handler: newRouter(newRequestHandler(storageAdapter, nil))},
 	}
 
 	s.Start()
@@ -89,7 +96,7 @@ func TestServerURL(t *testing.T) {
 
 	s := &Server{
 		Listener: newTestListener(t),
-		Server:   &http.Server{Handler: newRouter(newRequestHandler(storageAdapter))},
+		Server:   &http.Server{Handler: newRouter(newRequestHandler(storageAdapter, nil))},
 		URL:      "foo",
 	}
 
@@ -112,3 +119,52 @@ func TestServerURL(t *testing.T) {
 	// explicitly close the connection so other tests can run
 	s.Close()
 }
+
+func TestServerShutdown(t *testing.T) {
+	storageAdapter := newTestStorageAdapter(t)
+	storageAdapter.Start()
+	defer storageAdapter.Stop()
+	assert.NoError(t, storageAdapter.Write("foo", "message"))
+
+	h := newRequestHandler(storageAdapter, nil)
+	s := &Server{
+		Listener: newTestListener(t),
+		Server:   &http.Server{Handler: newRouter(h)},
+		handler:  h,
+	}
+	s.Start()
+
+	follow, err := http.Get(s.URL + "/logs/foo?follow=true")
+	assert.NoError(t, err)
+	defer follow.Body.Close()
+	events, err := http.Get(s.URL + "/logs/foo/stream")
+	assert.NoError(t, err)
+	defer events.Body.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/logs/foo/stream", nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, message, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "message", string(message))
+	for testutil.ToFloat64(followStreams) != 3 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+	assert.Equal(t, float64(0), testutil.ToFloat64(followStreams))
+
+	// the streams end cleanly rather than when their timeout passes
+	body, err := io.ReadAll(follow.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "message\n", string(body))
+	body, err = io.ReadAll(events.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "id: 1\ndata: message\n\n", string(body))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "%v", err)
+
+	_, err = net.Dial("tcp", testBindAddr)
+	assert.Error(t, err)
+}
@@ -0,0 +1,311 @@
+package weblog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/drycc/logger/storage"
+)
+
+var (
+	// streamKeepAlive is how often an idle stream is kept alive, with a comment for Server-Sent
+	// Events and a ping for WebSockets, before proxies time it out
+	streamKeepAlive = 15 * time.Second
+	// streamWriteTimeout is how long a WebSocket message may take to be written
+	streamWriteTimeout = 10 * time.Second
+
+	upgrader = websocket.Upgrader{CheckOrigin: checkOrigin}
+)
+
+// checkOrigin accepts the WebSocket upgrades without an Origin header, such as those of command line
+// clients, and those from the origin of the server or from one of the allowed origins
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range getLimits().AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamTracker tracks the follow streams of a server, so that they end when it shuts down rather
+// than when their timeout passes or their client leaves
+type streamTracker struct {
+	mu sync.Mutex
+	// ctx is done once the server shuts down
+	ctx    context.Context
+	cancel context.CancelFunc
+	active sync.WaitGroup
+}
+
+func newStreamTracker() *streamTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &streamTracker{ctx: ctx, cancel: cancel}
+}
+
+// begin starts tracking a follow stream, it returns the context of the stream, done once parent is
+// done or the server shuts down, and the function ending the stream
+func (t *streamTracker) begin(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ctx.Err() != nil {
+		cancel()
+		return ctx, cancel
+	}
+	t.active.Add(1)
+	followStreams.Inc()
+	stop := context.AfterFunc(t.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+		followStreams.Dec()
+		t.active.Done()
+	}
+}
+
+// shuttingDown reports whether the server is shutting down
+func (t *streamTracker) shuttingDown() bool {
+	return t.ctx.Err() != nil
+}
+
+// close ends the follow streams, the streams beginning afterwards end right away
+func (t *streamTracker) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cancel()
+}
+
+// wait waits for the follow streams to end until ctx is done
+func (t *streamTracker) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// streamLogs follows the logs of an app for as long as the client stays connected, over a
+// WebSocket when the request is an upgrade and as Server-Sent Events otherwise. The query
+// parameters are those of getLogs, without follow and timeout.
+func (h requestHandler) streamLogs(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		h.streamWebSocket(w, r)
+	} else {
+		h.streamEvents(w, r)
+	}
+}
+
+// readStream returns the entries a stream starts with. An app without logs yet is followed all
+// the same, from now on.
+func (h requestHandler) readStream(req *logsRequest) ([]storage.Entry, string, bool, error) {
+	entries, cursor, behind, err := h.readLogs(req)
+	if err != nil && isNotFound(err) && req.sinceCursor == "" {
+		return nil, "", false, nil
+	}
+	return entries, cursor, behind, err
+}
+
+// encodeEntry returns an entry as written to a stream, its line or its record in formatJSON
+func encodeEntry(req *logsRequest, entry storage.Entry) string {
+	if req.format == formatJSON {
+		data, _ := json.Marshal(newLogRecord(req.app, entry))
+		return string(data)
+	}
+	return strings.TrimSuffix(entry.Line, "\n")
+}
+
+// streamEvents streams logs as Server-Sent Events, an event per line with the cursor of the line
+// as its ID. A client reconnecting with Last-Event-ID resumes after the last line it received.
+func (h requestHandler) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		panic("expected http.ResponseWriter to be an http.Flusher")
+	}
+	req, err := parseLogsRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		req.sinceCursor, req.since = id, time.Time{}
+	}
+	entries, cursor, behind, err := h.readStream(req)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), readErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	writeEvent := func(entry storage.Entry) {
+		if !req.filter.match(entry) {
+			return
+		}
+		if entry.Cursor != "" {
+			fmt.Fprintf(w, "id: %s\n", entry.Cursor)
+		}
+		for _, data := range strings.Split(encodeEntry(req, entry), "\n") {
+			fmt.Fprintf(w, "data: %s\n", data)
+		}
+		fmt.Fprint(w, "\n")
+	}
+	writeEvents := func(entries []storage.Entry) {
+		for _, entry := range entries {
+			writeEvent(entry)
+		}
+		flusher.Flush()
+	}
+	writeEvents(entries)
+	// a time range with an end is not followed
+	if !req.until.IsZero() {
+		return
+	}
+
+	ctx, end := h.streams.begin(r.Context())
+	defer end()
+	channel, err := h.followLogs(ctx, req, cursor, behind, writeEvents)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	ticker := time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry, ok := <-channel:
+			// the channel is closed once the client is gone or falls behind, or the server shuts down,
+			// the client reconnects then
+			if !ok {
+				return
+			}
+			writeEvent(entry)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// streamWebSocket streams logs over a WebSocket, a text message per line. The stream is closed
+// with the try again later status when the client falls behind, or going away when the server
+// shuts down, to reconnect with the cursor of the last record it received as since_cursor.
+func (h requestHandler) streamWebSocket(w http.ResponseWriter, r *http.Request) {
+	req, err := parseLogsRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, cursor, behind, err := h.readStream(req)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), readErrorStatus(err))
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer conn.Close()
+
+	// the client is gone once a read fails, the messages it sends are discarded
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	conn.SetReadDeadline(time.Now().Add(2 * streamKeepAlive))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * streamKeepAlive))
+	})
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	writeMessage := func(entry storage.Entry) {
+		if !req.filter.match(entry) || ctx.Err() != nil {
+			return
+		}
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(encodeEntry(req, entry))); err != nil {
+			cancel()
+		}
+	}
+	writeMessages := func(entries []storage.Entry) {
+		for _, entry := range entries {
+			writeMessage(entry)
+		}
+	}
+	closeStream := func(code int) {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(streamWriteTimeout))
+	}
+	writeMessages(entries)
+	// a time range with an end is not followed
+	if !req.until.IsZero() {
+		closeStream(websocket.CloseNormalClosure)
+		return
+	}
+
+	ctx, end := h.streams.begin(ctx)
+	defer end()
+	channel, err := h.followLogs(ctx, req, cursor, behind, writeMessages)
+	if err != nil {
+		log.Println(err)
+		closeStream(websocket.CloseInternalServerErr)
+		return
+	}
+	ticker := time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry, ok := <-channel:
+			if !ok {
+				if h.streams.shuttingDown() {
+					closeStream(websocket.CloseGoingAway)
+				} else if ctx.Err() == nil {
+					closeStream(websocket.CloseTryAgainLater)
+				}
+				return
+			}
+			writeMessage(entry)
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			if h.streams.shuttingDown() {
+				closeStream(websocket.CloseGoingAway)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package weblog
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/drycc/logger/storage"
+)
+
+func newTestStreamServer(t *testing.T, lines int) (storage.Adapter, *httptest.Server) {
+	storageAdapter, err := storage.NewAdapter("memory", 10)
+	assert.NoError(t, err)
+	for i := 0; i < lines; i++ {
+		assert.NoError(t, storageAdapter.Write("foo", fmt.Sprintf("message %d", i)))
+	}
+	server := httptest.NewServer(newRouter(newRequestHandler(storageAdapter, nil)))
+	t.Cleanup(server.Close)
+	return storageAdapter, server
+}
+
+// waitStreamsClosed waits for the streams of a test to end once their clients are gone
+func waitStreamsClosed(t *testing.T) {
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(followStreams) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the streams to end")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readEvent returns the fields of the next event of a Server-Sent Events stream, comments
+// included
+func readEvent(t *testing.T, reader *bufio.Reader) []string {
+	var fields []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read event: %v", err)
+		}
+		if line == "\n" {
+			return fields
+		}
+		fields = append(fields, strings.TrimSuffix(line, "\n"))
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	defer func(keepAlive time.Duration) { streamKeepAlive = keepAlive }(streamKeepAlive)
+	streamKeepAlive = 100 * time.Millisecond
+	defer waitStreamsClosed(t)
+	storageAdapter, server := newTestStreamServer(t, 3)
+
+	r, err := http.NewRequest("GET", server.URL+"/logs/foo/stream?log_lines=2&grep=message", nil)
+	assert.NoError(t, err)
+	resp, err := http.DefaultClient.Do(r)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+	assert.Equal(t, []string{"id: 2", "data: message 1"}, readEvent(t, reader))
+	assert.Equal(t, []string{"id: 3", "data: message 2"}, readEvent(t, reader))
+	// idle streams are kept alive
+	assert.Equal(t, []string{": keepalive"}, readEvent(t, reader))
+	assert.NoError(t, storageAdapter.Write("foo", "skipped"))
+	assert.NoError(t, storageAdapter.Write("foo", "message 3"))
+	event := readEvent(t, reader)
+	for event[0] == ": keepalive" {
+		event = readEvent(t, reader)
+	}
+	assert.Equal(t, []string{"id: 5", "data: message 3"}, event)
+	resp.Body.Close()
+
+	// reconnecting resumes after the last event received
+	r, err = http.NewRequest("GET", server.URL+"/logs/foo/stream?format=json", nil)
+	assert.NoError(t, err)
+	r.Header.Set("Last-Event-ID", "3")
+	resp, err = http.DefaultClient.Do(r)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	reader = bufio.NewReader(resp.Body)
+	assert.Equal(t, []string{"id: 4", `data: {"cursor":"4","app":"foo","message":"skipped","line":"skipped"}`}, readEvent(t, reader))
+
+	r, err = http.NewRequest("GET", server.URL+"/logs/foo/stream", nil)
+	assert.NoError(t, err)
+	r.Header.Set("Last-Event-ID", "bogus")
+	resp, err = http.DefaultClient.Do(r)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	defer waitStreamsClosed(t)
+	storageAdapter, server := newTestStreamServer(t, 3)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/logs/foo/stream?log_lines=1"
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, message, err := conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "message 2", string(message))
+	// followed from the last line sent, so the lines written meanwhile are not missed
+	assert.NoError(t, storageAdapter.Write("foo", "message 3"))
+	_, message, err = conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "message 3", string(message))
+
+	// a time range with an end is closed once read
+	url = "ws" + strings.TrimPrefix(server.URL, "http") + "/logs/foo/stream?until=0s&format=json&grep=3"
+	conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, message, err = conn.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"cursor":"4","app":"foo","message":"message 3","line":"message 3"}`, string(message))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "%v", err)
+
+	_, resp, err = websocket.DefaultDialer.Dial(url+"&regex=(", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStreamWebSocketOrigin(t *testing.T) {
+	defer waitStreamsClosed(t)
+	defer SetLimits(nil)
+	_, server := newTestStreamServer(t, 1)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/logs/foo/stream?log_lines=1"
+	origin := func(origin string) http.Header {
+		return http.Header{"Origin": []string{origin}}
+	}
+
+	// upgrades from another origin are rejected unless it is allowed
+	_, resp, err := websocket.DefaultDialer.Dial(url, origin("https://dashboard.example.com"))
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	SetLimits(&Limits{AllowedOrigins: []string{"https://dashboard.example.com"}})
+	conn, resp, err := websocket.DefaultDialer.Dial(url, origin("https://dashboard.example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	conn.Close()
+	_, resp, err = websocket.DefaultDialer.Dial(url, origin("https://other.example.com"))
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// the origin of the server is accepted
+	conn, _, err = websocket.DefaultDialer.Dial(url, origin(server.URL))
+	assert.NoError(t, err)
+	conn.Close()
+}